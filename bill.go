@@ -13,9 +13,11 @@ import (
 )
 
 //这里的bill就是一个简单的主表-明细表的集合，提供了读写的方法
+//KeyGenerator不为空时，Insert的主表主键为空则自动生成编号，主表只能有一个主键
 type Bill struct {
-	Main  *DBTable
-	Child map[string]*DBTable
+	Main         *DBTable
+	Child        map[string]*DBTable
+	KeyGenerator *NumberGenerator
 }
 type BillRows struct {
 	bill *Bill
@@ -79,7 +81,8 @@ func (b *Bill) ChangeKeyValues(record *BillRecord, pks ...interface{}) {
 }
 func (b *Bill) Clone() *Bill {
 	result := &Bill{
-		Main: b.Main.Clone(),
+		Main:         b.Main.Clone(),
+		KeyGenerator: b.KeyGenerator,
 	}
 	if len(b.Child) == 0 {
 		return result
@@ -108,9 +111,9 @@ func (b *Bill) Count(where string, param map[string]interface{}) (icount int64,
 	var strSql string
 
 	if len(where) == 0 {
-		strSql = fmt.Sprintf("select count(*) from %s", b.Main.Name())
+		strSql = fmt.Sprintf("select count(*) from %s", b.Main.quotedName())
 	} else {
-		strSql = fmt.Sprintf("select count(*) from %s where %s", b.Main.Name(), where)
+		strSql = fmt.Sprintf("select count(*) from %s where %s", b.Main.quotedName(), where)
 	}
 
 	if strSql, err = RenderSql(strSql, param); err != nil {
@@ -127,9 +130,9 @@ func (b *Bill) NameQuery(where string, renderParam map[string]interface{}) (*Bil
 	var strSql string
 
 	if len(where) == 0 {
-		strSql = fmt.Sprintf("select * from %s", b.Main.Name())
+		strSql = fmt.Sprintf("select * from %s", b.Main.quotedName())
 	} else {
-		strSql = fmt.Sprintf("select * from %s where %s", b.Main.Name(), where)
+		strSql = fmt.Sprintf("select * from %s where %s", b.Main.quotedName(), where)
 	}
 	var err error
 	if strSql, err = RenderSql(strSql, renderParam); err != nil {
@@ -172,8 +175,23 @@ func (b *Bill) Remove(oldRecord *BillRecord) error {
 	}
 	return nil
 }
+
+//主表如果有自增字段，插入后会把生成的主键值填回记录，并传递到明细表的主键中
 func (b *Bill) Insert(record *BillRecord) error {
-	if err := b.Main.Insert([]map[string]interface{}{record.Main}); err != nil {
+	if err := b.generateKey(record); err != nil {
+		return err
+	}
+	if len(b.Main.IdentityColumns()) > 0 {
+		main, err := b.Main.InsertReturning(record.Main)
+		if err != nil {
+			return err
+		}
+		pks := []interface{}{}
+		for _, name := range b.Main.PrimaryKeys() {
+			pks = append(pks, main[name])
+		}
+		b.ChangeKeyValues(record, pks...)
+	} else if err := b.Main.Insert([]map[string]interface{}{record.Main}); err != nil {
 		return err
 	}
 	if len(record.Child) == 0 {
@@ -187,6 +205,26 @@ func (b *Bill) Insert(record *BillRecord) error {
 	return nil
 }
 
+//主键为空时用KeyGenerator生成编号，并填充到明细表
+func (b *Bill) generateKey(record *BillRecord) error {
+	if b.KeyGenerator == nil {
+		return nil
+	}
+	pks := b.Main.PrimaryKeys()
+	if len(pks) != 1 {
+		return fmt.Errorf("table %s must have only one primary key to generate", b.Main.Name())
+	}
+	if len(safe.String(record.Main[pks[0]])) > 0 {
+		return nil
+	}
+	no, err := b.KeyGenerator.Next(b.Main.Db)
+	if err != nil {
+		return err
+	}
+	b.ChangeKeyValues(record, no)
+	return nil
+}
+
 //保存一个记录，如果对应的记录存在则被覆盖
 func (b *Bill) Save(record *BillRecord) error {
 	//主表save
@@ -0,0 +1,142 @@
+package dbx
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//自定义字段类型，注册后DBTable、DefineScript以及TableSchema都可以使用，例如UUID、INTERVAL、数组等
+//go值为TypeCustom，除DBType外其他函数都可以为空，为空时按字符串处理
+type ColumnTypeDefine struct {
+	Name        string //类型名称，大写，DefineScript中用小写
+	ChineseName string //显示名称，用于ChineseType
+	//返回指定驱动下的数据库类型定义，字段的MaxLength等属性可以参与定义
+	DBType func(driver string, col *DBTableColumn) string
+	//FetchColumns获取的字段（Type是内置类型或数据库中的类型名，TrueType是完整的类型定义），判断是否属于本类型
+	Detect func(driver string, col *DBTableColumn) bool
+	//数据库返回的值转换成go值
+	Convert func(v interface{}) (interface{}, error)
+	//go值和json值之间的转换
+	ToJson   func(v interface{}) (interface{}, error)
+	FromJson func(v interface{}) (interface{}, error)
+	//字符串转换成go值，用于GoValue
+	Parse func(v string) (interface{}, error)
+	//类型可以带长度，DefineScript中写成name(n)，长度保存在MaxLength中
+	Length bool
+}
+
+var (
+	columnTypesLock sync.RWMutex
+	columnTypes     = map[string]*ColumnTypeDefine{}
+	//按注册的先后顺序进行识别
+	columnTypeNames = []string{}
+)
+
+//内置的字段类型，DefineScript中使用小写
+var builtinColumnTypes = []string{"STR", "INT", "DATE", "DATEONLY", "TIMESTAMP", "TIMESTAMPTZ", "FLOAT", "DEC", "BOOL", "JSON", "BYTEA"}
+
+//注册一个自定义字段类型，同名的类型会被替换，一般在init中调用
+func RegisterColumnType(def *ColumnTypeDefine) {
+	if def == nil || len(def.Name) == 0 {
+		log.Panic("column type name is empty")
+	}
+	if def.DBType == nil {
+		log.Panic(fmt.Errorf("column type %s DBType is nil", def.Name))
+	}
+	name := strings.ToUpper(def.Name)
+	for _, v := range builtinColumnTypes {
+		if v == name {
+			log.Panic(fmt.Errorf("column type %s is builtin", def.Name))
+		}
+	}
+	def.Name = name
+	columnTypesLock.Lock()
+	defer columnTypesLock.Unlock()
+	if _, ok := columnTypes[name]; !ok {
+		columnTypeNames = append(columnTypeNames, name)
+	}
+	columnTypes[name] = def
+}
+
+//注销一个自定义字段类型
+func UnregisterColumnType(name string) {
+	name = strings.ToUpper(name)
+	columnTypesLock.Lock()
+	defer columnTypesLock.Unlock()
+	if _, ok := columnTypes[name]; !ok {
+		return
+	}
+	delete(columnTypes, name)
+	for i, v := range columnTypeNames {
+		if v == name {
+			columnTypeNames = append(columnTypeNames[:i], columnTypeNames[i+1:]...)
+			break
+		}
+	}
+}
+
+//返回注册的自定义字段类型，没有则返回nil
+func GetColumnType(name string) *ColumnTypeDefine {
+	columnTypesLock.RLock()
+	defer columnTypesLock.RUnlock()
+	return columnTypes[strings.ToUpper(name)]
+}
+
+//返回所有可用的字段类型名称，包括内置类型和自定义类型
+func ColumnTypeNames() []string {
+	columnTypesLock.RLock()
+	defer columnTypesLock.RUnlock()
+	rev := append([]string{}, builtinColumnTypes...)
+	return append(rev, columnTypeNames...)
+}
+
+//根据注册的自定义类型识别获取的字段，识别出来就替换类型
+func detectColumnType(driver string, col *DBTableColumn) {
+	columnTypesLock.RLock()
+	defer columnTypesLock.RUnlock()
+	for _, name := range columnTypeNames {
+		def := columnTypes[name]
+		if def.Detect != nil && def.Detect(driver, col) {
+			col.Type = name
+			return
+		}
+	}
+}
+
+//类型是否可以带长度，内置类型中只有str
+func columnTypeHasLength(name string) bool {
+	name = strings.ToUpper(name)
+	if name == "STR" {
+		return true
+	}
+	def := GetColumnType(name)
+	return def != nil && def.Length
+}
+
+//DefineScript中数据类型的正则表达式，长的名称在前，以免interval被int匹配，
+//dec可以带精度，str和声明了Length的自定义类型可以带长度
+func columnTypeRegexp() string {
+	names := ColumnTypeNames()
+	sort.Slice(names, func(i, j int) bool {
+		return len(names[i]) > len(names[j])
+	})
+	list := []string{}
+	for _, v := range names {
+		name := regexp.QuoteMeta(strings.ToLower(v))
+		switch {
+		case v == "DEC":
+			name += `\b(?:\([0-9]+(?:\s*,\s*[0-9]+)?\))?`
+		case columnTypeHasLength(v):
+			name += `\b(?:\([0-9]+\))?`
+		default:
+			name += `\b`
+		}
+		list = append(list, name)
+	}
+	return `\s+(?:` + strings.Join(list, "|") + `)`
+}
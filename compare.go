@@ -0,0 +1,229 @@
+package dbx
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//SchemaDiff 两个数据库结构的比较结果，数据库可以是不同的类型，
+//字段类型按STR、INT、DATE、FLOAT、DEC、BYTEA等内置类型比较，表名和字段名忽略大小写
+type SchemaDiff struct {
+	Source       string //源数据库类型
+	Target       string //目标数据库类型
+	OnlyInSource []string
+	OnlyInTarget []string
+	Tables       []*TableDiff //两边都有但结构不同的表
+}
+
+//TableDiff 一个表的结构差异
+type TableDiff struct {
+	Name          string
+	OnlyInSource  []string //只在源表中的字段
+	OnlyInTarget  []string
+	Columns       []*ColumnDiff
+	SourcePrimary []string //主键不同时，两边的主键
+	TargetPrimary []string
+	//只在一边的索引，单字段的普通索引也包含在内
+	SourceIndexes []*DBIndex
+	TargetIndexes []*DBIndex
+}
+
+//ColumnDiff 一个字段的差异，Differences是可读的差异说明
+type ColumnDiff struct {
+	Name        string
+	Source      *DBTableColumn
+	Target      *DBTableColumn
+	Differences []string
+}
+
+//CompareSchema 比较两个数据库中所有的表
+func CompareSchema(source, target DB) *SchemaDiff {
+	rev := &SchemaDiff{
+		Source:       source.DriverName(),
+		Target:       target.DriverName(),
+		OnlyInSource: []string{},
+		OnlyInTarget: []string{},
+		Tables:       []*TableDiff{},
+	}
+	//表名忽略大小写，例如oracle的ORDERITEM和postgres的OrderItem是同一个表
+	targetNames := map[string]string{}
+	for _, v := range TableNames(target) {
+		targetNames[strings.ToUpper(v)] = v
+	}
+	for _, name := range TableNames(source) {
+		targetName, ok := targetNames[strings.ToUpper(name)]
+		if !ok {
+			rev.OnlyInSource = append(rev.OnlyInSource, name)
+			continue
+		}
+		delete(targetNames, strings.ToUpper(name))
+		src := tableOf(source, name)
+		src.FetchColumns()
+		dest := tableOf(target, targetName)
+		dest.FetchColumns()
+		if diff := CompareTable(src, dest); diff != nil {
+			rev.Tables = append(rev.Tables, diff)
+		}
+	}
+	for _, v := range targetNames {
+		rev.OnlyInTarget = append(rev.OnlyInTarget, v)
+	}
+	sort.Strings(rev.OnlyInSource)
+	sort.Strings(rev.OnlyInTarget)
+	return rev
+}
+
+//CompareTable 比较两个表的结构，相同则返回nil
+func CompareTable(source, target *DBTable) *TableDiff {
+	rev := &TableDiff{
+		Name: source.Name(),
+	}
+	targetColumns := map[string]*DBTableColumn{}
+	for _, v := range target.AllField() {
+		targetColumns[strings.ToUpper(v.Name)] = v
+	}
+	for _, col := range source.AllField() {
+		name := strings.ToUpper(col.Name)
+		dest, ok := targetColumns[name]
+		if !ok {
+			rev.OnlyInSource = append(rev.OnlyInSource, col.Name)
+			continue
+		}
+		delete(targetColumns, name)
+		if diff := compareColumn(col, dest); diff != nil {
+			rev.Columns = append(rev.Columns, diff)
+		}
+	}
+	//保持目标表的字段顺序
+	for _, col := range target.AllField() {
+		if _, ok := targetColumns[strings.ToUpper(col.Name)]; ok {
+			rev.OnlyInTarget = append(rev.OnlyInTarget, col.Name)
+		}
+	}
+	if strings.ToUpper(strings.Join(source.PrimaryKeys(), ",")) != strings.ToUpper(strings.Join(target.PrimaryKeys(), ",")) {
+		rev.SourcePrimary = source.PrimaryKeys()
+		rev.TargetPrimary = target.PrimaryKeys()
+	}
+	rev.SourceIndexes = indexesExcept(source, target)
+	rev.TargetIndexes = indexesExcept(target, source)
+	if len(rev.OnlyInSource) == 0 && len(rev.OnlyInTarget) == 0 && len(rev.Columns) == 0 &&
+		len(rev.SourcePrimary) == 0 && len(rev.TargetPrimary) == 0 &&
+		len(rev.SourceIndexes) == 0 && len(rev.TargetIndexes) == 0 {
+		return nil
+	}
+	return rev
+}
+
+//比较两个字段的类型、长度、精度以及是否为空，相同返回nil
+func compareColumn(source, target *DBTableColumn) *ColumnDiff {
+	diffs := []string{}
+	if !compareType(source, target) {
+		diffs = append(diffs, fmt.Sprintf("type %s <> %s", source.Type, target.Type))
+	} else if source.Type == "DEC" {
+		if (source.Precision > 0 || target.Precision > 0) &&
+			(source.Precision != target.Precision || source.Scale != target.Scale) {
+			diffs = append(diffs, fmt.Sprintf("precision %s <> %s", source.scriptType(), target.scriptType()))
+		}
+	} else if (source.MaxLength > 0 || target.MaxLength > 0) && source.MaxLength != target.MaxLength {
+		diffs = append(diffs, fmt.Sprintf("length %s <> %s", source.scriptType(), target.scriptType()))
+	}
+	if source.Null != target.Null {
+		diffs = append(diffs, fmt.Sprintf("null %v <> %v", source.Null, target.Null))
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+	return &ColumnDiff{
+		Name:        source.Name,
+		Source:      source,
+		Target:      target,
+		Differences: diffs,
+	}
+}
+
+//字段类型是否相同，日期时间类型在任意一方获取的数据库中映射成相同的类型也算相同，
+//例如postgres中DATE和TIMESTAMP都是timestamp without time zone，不能区分
+func compareType(source, target *DBTableColumn) bool {
+	if source.typeEque(target) {
+		return true
+	}
+	return len(target.FetchDriver) > 0 && target.typeEque(source)
+}
+
+//表的所有索引，单字段的普通索引也转换成索引
+func tableIndexes(t *DBTable) []*DBIndex {
+	rev := []*DBIndex{}
+	for _, col := range t.AllField() {
+		if col.Index {
+			rev = append(rev, &DBIndex{Name: col.IndexName, Columns: []string{col.Name}})
+		}
+	}
+	return append(rev, t.Indexes...)
+}
+
+//返回只在tab中，不在other中的索引
+func indexesExcept(tab, other *DBTable) []*DBIndex {
+	signs := map[string]bool{}
+	for _, v := range tableIndexes(other) {
+		signs[v.signature()] = true
+	}
+	rev := []*DBIndex{}
+	for _, v := range tableIndexes(tab) {
+		if !signs[v.signature()] {
+			rev = append(rev, v)
+		}
+	}
+	return rev
+}
+
+//IsEmpty 返回两边的结构是否相同
+func (d *SchemaDiff) IsEmpty() bool {
+	return len(d.OnlyInSource) == 0 && len(d.OnlyInTarget) == 0 && len(d.Tables) == 0
+}
+
+//Report 生成可读的比较报告
+func (d *SchemaDiff) Report() string {
+	out := bytes.NewBuffer(nil)
+	fmt.Fprintf(out, "source:%s target:%s\n", d.Source, d.Target)
+	if d.IsEmpty() {
+		fmt.Fprintln(out, "no difference")
+		return out.String()
+	}
+	for _, v := range d.OnlyInSource {
+		fmt.Fprintf(out, "table %s missing in target\n", v)
+	}
+	for _, v := range d.OnlyInTarget {
+		fmt.Fprintf(out, "table %s missing in source\n", v)
+	}
+	for _, v := range d.Tables {
+		out.WriteString(v.Report())
+	}
+	return out.String()
+}
+
+//Report 生成一个表的可读差异报告
+func (d *TableDiff) Report() string {
+	out := bytes.NewBuffer(nil)
+	fmt.Fprintf(out, "table %s:\n", d.Name)
+	for _, v := range d.OnlyInSource {
+		fmt.Fprintf(out, "  column %s missing in target\n", v)
+	}
+	for _, v := range d.OnlyInTarget {
+		fmt.Fprintf(out, "  column %s missing in source\n", v)
+	}
+	for _, v := range d.Columns {
+		fmt.Fprintf(out, "  column %s: %s\n", v.Name, strings.Join(v.Differences, ", "))
+	}
+	if len(d.SourcePrimary) > 0 || len(d.TargetPrimary) > 0 {
+		fmt.Fprintf(out, "  primary key (%s) <> (%s)\n", strings.Join(d.SourcePrimary, ","), strings.Join(d.TargetPrimary, ","))
+	}
+	for _, v := range d.SourceIndexes {
+		fmt.Fprintf(out, "  %s missing in target\n", v.script())
+	}
+	for _, v := range d.TargetIndexes {
+		fmt.Fprintf(out, "  %s missing in source\n", v.script())
+	}
+	return out.String()
+}
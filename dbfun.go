@@ -7,12 +7,15 @@ import (
 	"dbweb/lib/tempext"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 
 	"math/rand"
 	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
+	"unicode/utf8"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -91,24 +94,52 @@ func Columns(db DB, strSql string, p map[string]interface{}) ([]string, error) {
 
 	return r, err
 }
+
+//TableNames 返回当前schema中的表名，不包括视图，postgres的分区是单独的表，也不包括
 func TableNames(db DB) (names []string) {
 	var strSql string
 	switch db.DriverName() {
 	case "postgres":
-		strSql = "SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema()"
+		strSql = "SELECT c.relname FROM pg_class c join pg_namespace n on n.oid=c.relnamespace " +
+			"WHERE n.nspname = current_schema() and c.relkind in ('r','p') and not c.relispartition"
 	case "oci8":
 		strSql = "SELECT table_name FROM user_tables"
 	case "mysql":
-		strSql = "SELECT table_name FROM information_schema.tables WHERE table_schema = schema()"
+		strSql = "SELECT table_name FROM information_schema.tables WHERE table_schema = schema() and table_type='BASE TABLE'"
+	case "sqlite3":
+		strSql = "SELECT name FROM sqlite_master WHERE type='table' and name not like 'sqlite_%'"
 	default:
 		log.Panic("not impl," + db.DriverName())
 	}
+	return selectNames(db, strSql)
+}
+
+//ViewNames 返回当前schema中的视图名
+func ViewNames(db DB) (names []string) {
+	var strSql string
+	switch db.DriverName() {
+	case "postgres":
+		strSql = "SELECT table_name FROM information_schema.views WHERE table_schema = current_schema()"
+	case "oci8":
+		strSql = "SELECT view_name FROM user_views"
+	case "mysql":
+		strSql = "SELECT table_name FROM information_schema.views WHERE table_schema = schema()"
+	case "sqlite3":
+		strSql = "SELECT name FROM sqlite_master WHERE type='view'"
+	default:
+		log.Panic("not impl," + db.DriverName())
+	}
+	return selectNames(db, strSql)
+}
+
+//执行返回名称的查询，转换成dbx中的名称并排序
+func selectNames(db DB, strSql string) (names []string) {
 	names = []string{}
 	if err := db.Select(&names, strSql); err != nil {
 		log.Panic(err)
 	}
 	for i, v := range names {
-		names[i] = strings.ToUpper(v)
+		names[i] = FetchedName(db.DriverName(), v)
 	}
 	sort.Strings(names)
 	return
@@ -189,11 +220,12 @@ func Exists(db DB, strSql string, p map[string]interface{}) (result bool, err er
 func CreateTableAs(db DB, tableName, strSql string, pks []string) error {
 	switch db.DriverName() {
 	case "postgres", "mysql", "oci8":
-		s := fmt.Sprintf("CREATE TABLE %s as %s", tableName, strSql)
+		s := fmt.Sprintf("CREATE TABLE %s as %s", QuoteName(db.DriverName(), tableName), strSql)
 		if _, err := db.Exec(s); err != nil {
 			return SqlError{s, nil, err}
 		}
-		s = fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY(%s)", tableName, strings.Join(pks, ","))
+		s = fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY(%s)", QuoteName(db.DriverName(), tableName),
+			strings.Join(quoteNames(db.DriverName(), pks), ","))
 		if _, err := db.Exec(s); err != nil {
 			return SqlError{s, nil, err}
 		}
@@ -205,7 +237,53 @@ func CreateTableAs(db DB, tableName, strSql string, pks []string) error {
 
 //TableRemoveColumns 删除表字段
 func TableRemoveColumns(db DB, tabName string, cols []string) error {
+	if db.DriverName() == "sqlite3" {
+		return sqliteRebuild(db, tabName, func(tab *DBTable) {
+			remove := map[string]bool{}
+			for _, v := range cols {
+				remove[strings.ToUpper(v)] = true
+			}
+			columns := []*DBTableColumn{}
+			for _, v := range tab.AllField() {
+				if !remove[strings.ToUpper(v.Name)] {
+					columns = append(columns, v)
+				}
+			}
+			pks := []string{}
+			for _, v := range tab.PrimaryKeys() {
+				if !remove[strings.ToUpper(v)] {
+					pks = append(pks, v)
+				}
+			}
+			indexes := []*DBIndex{}
+			for _, v := range tab.Indexes {
+				if !indexOnColumns(v, remove) {
+					indexes = append(indexes, v)
+				}
+			}
+			tab.Indexes = indexes
+			foreignKeys := []*DBForeignKey{}
+			for _, v := range tab.ForeignKeys {
+				if !indexOnColumns(&DBIndex{Columns: v.Columns}, remove) {
+					foreignKeys = append(foreignKeys, v)
+				}
+			}
+			tab.ForeignKeys = foreignKeys
+			tab.Define(columns, pks)
+		})
+	}
+	strSql, err := tableRemoveColumnsSql(db, tabName, cols)
+	if err != nil {
+		return err
+	}
+	return execDDL(db, strSql)
+}
+
+//删除表字段的语句
+func tableRemoveColumnsSql(db DB, tabName string, cols []string) (string, error) {
 	var strSql string
+	tabName = QuoteName(db.DriverName(), tabName)
+	cols = quoteNames(db.DriverName(), cols)
 	switch db.DriverName() {
 	case "postgres", "mysql":
 		strList := []string{}
@@ -216,14 +294,9 @@ func TableRemoveColumns(db DB, tabName string, cols []string) error {
 	case "oci8":
 		strSql = fmt.Sprintf("ALTER table %s drop(%s)", tabName, strings.Join(cols, ","))
 	default:
-		return fmt.Errorf("not impl," + db.DriverName())
-	}
-	log.Println(strSql)
-	if _, err := db.Exec(strSql); err != nil {
-		return SqlError{strSql, nil, err}
+		return "", fmt.Errorf("not impl," + db.DriverName())
 	}
-	return nil
-
+	return strSql, nil
 }
 
 //GetSlice 返回一个字符串数组
@@ -267,20 +340,27 @@ func MustGetSliceAndSort(db DB, strSQL string, params map[string]interface{}) []
 
 //TableRename 表更名
 func TableRename(db DB, oldName, newName string) error {
+	strSql, err := tableRenameSql(db, oldName, newName)
+	if err != nil {
+		return err
+	}
+	return execDDL(db, strSql)
+}
+
+//表更名的语句
+func tableRenameSql(db DB, oldName, newName string) (string, error) {
 	var strSql string
+	oldName = QuoteName(db.DriverName(), oldName)
+	newName = QuoteName(db.DriverName(), newName)
 	switch db.DriverName() {
 	case "postgres", "sqlite3":
 		strSql = fmt.Sprintf("ALTER table %s RENAME TO %s", oldName, newName)
 	case "oci8", "mysql":
 		strSql = fmt.Sprintf("rename table %s TO %s", oldName, newName)
 	default:
-		return fmt.Errorf("not impl," + db.DriverName())
+		return "", fmt.Errorf("not impl," + db.DriverName())
 	}
-	log.Println(strSql)
-	if _, err := db.Exec(strSql); err != nil {
-		return SqlError{strSql, nil, err}
-	}
-	return nil
+	return strSql, nil
 }
 func TableExists(db DB, tableName string) (bool, error) {
 	schema := ""
@@ -301,7 +381,7 @@ func TableExists(db DB, tableName string) (bool, error) {
 		}
 
 		strSql = fmt.Sprintf(
-			"SELECT count(*) FROM information_schema.tables WHERE table_schema ilike '%s' and table_name ilike :tname", schema)
+			"SELECT count(*) FROM information_schema.tables WHERE table_schema='%s' and table_name=:tname", storedName("postgres", schema))
 	case "oci8":
 		if len(schema) == 0 {
 			schema = safe.String(MustGetSqlFun(db, "select user from dual", nil))
@@ -320,6 +400,52 @@ func TableExists(db DB, tableName string) (bool, error) {
 	}
 	var iCount int64
 	p := map[string]interface{}{"tname": strings.ToUpper(tname)}
+	if db.DriverName() == "postgres" {
+		p["tname"] = storedName("postgres", tname)
+	}
+	if err := NameGet(db, &iCount, strSql, p); err != nil {
+		return false, SqlError{strSql, p, err}
+	}
+	return iCount > 0, nil
+}
+
+//ViewExists 视图是否存在
+func ViewExists(db DB, viewName string) (bool, error) {
+	schema := ""
+	vname := viewName
+	if ns := strings.Split(viewName, "."); len(ns) > 1 {
+		schema = ns[0]
+		vname = ns[1]
+	}
+	var strSql string
+	switch db.DriverName() {
+	case "postgres":
+		if len(schema) == 0 {
+			schema = safe.String(MustGetSqlFun(db, "select current_schema()", nil))
+		}
+		strSql = fmt.Sprintf(
+			"SELECT count(*) FROM information_schema.views WHERE table_schema='%s' and table_name=:vname", storedName("postgres", schema))
+	case "oci8":
+		if len(schema) == 0 {
+			schema = safe.String(MustGetSqlFun(db, "select user from dual", nil))
+		}
+		strSql = fmt.Sprintf("SELECT count(*) FROM all_views where owner='%s' and view_name=:vname", schema)
+	case "mysql":
+		if len(schema) == 0 {
+			schema = safe.String(MustGetSqlFun(db, "select schema()", nil))
+		}
+		strSql = fmt.Sprintf(
+			"SELECT count(*) FROM information_schema.views WHERE table_schema = '%s' and UPPER(table_name)=:vname", schema)
+	case "sqlite3":
+		strSql = "SELECT count(*) FROM sqlite_master WHERE type='view' AND upper(name)=:vname"
+	default:
+		return false, fmt.Errorf("not impl," + db.DriverName())
+	}
+	var iCount int64
+	p := map[string]interface{}{"vname": strings.ToUpper(vname)}
+	if db.DriverName() == "postgres" {
+		p["vname"] = storedName("postgres", vname)
+	}
 	if err := NameGet(db, &iCount, strSql, p); err != nil {
 		return false, SqlError{strSql, p, err}
 	}
@@ -482,35 +608,100 @@ func BindSql(db DB, strSql string, params map[string]interface{}) (result string
 
 //新增单字段索引
 func CreateColumnIndex(db DB, tableName, colName string) error {
-	ns := strings.Split(tableName, ".")
-	schema := ""
-	tname := ""
-	if len(ns) > 1 {
-		schema = ns[0] + "."
-		tname = ns[1]
-	} else {
-		tname = tableName
+	strSql, err := createColumnIndexSql(db, tableName, colName)
+	if err != nil {
+		return err
 	}
+	return execDDL(db, strSql)
+}
+
+//新增单字段索引的语句
+func createColumnIndexSql(db DB, tableName, colName string) (string, error) {
 	var strSql string
-	switch db.DriverName() {
+	driver := db.DriverName()
+	switch driver {
 	case "postgres":
-		strSql = fmt.Sprintf("create index on %s(%s)", tableName, colName)
+		strSql = fmt.Sprintf("create index on %s(%s)", QuoteName(driver, tableName), QuoteName(driver, colName))
 	case "oci8", "mysql", "sqlite3":
-		//这里会有问题，如果表名和字段名比较长就会出错
-		strSql = fmt.Sprintf("create index %si%s%s on %s(%s)", schema, tname, colName, tableName, colName)
+		strSql = fmt.Sprintf("create index %s on %s(%s)", QuoteName(driver, columnIndexName(tableName, colName)),
+			QuoteName(driver, tableName), QuoteName(driver, colName))
 	default:
 		log.Panic("not impl " + db.DriverName())
 	}
-	if _, err := db.Exec(strSql); err != nil {
-		return SqlError{strSql, nil, err}
+	return strSql, nil
+}
+
+//单字段普通索引的名称，postgres是自动生成的，表名和字段名较长时截短
+func columnIndexName(tableName, colName string) string {
+	if ns := strings.Split(tableName, "."); len(ns) > 1 {
+		return ns[0] + "." + objectName("I%s_%s", ns[1], colName)
 	}
-	log.Println(strSql)
-	return nil
+	return objectName("I%s_%s", tableName, colName)
+}
+
+//新增一个索引，可以是多字段、唯一、表达式以及部分索引，索引名称不带schema
+func CreateTableIndex(db DB, tableName string, idx *DBIndex) error {
+	strSql, err := createTableIndexSql(db, tableName, idx)
+	if err != nil {
+		return err
+	}
+	return execDDL(db, strSql)
+}
+
+//新增索引的语句
+func createTableIndexSql(db DB, tableName string, idx *DBIndex) (string, error) {
+	schema := ""
+	if ns := strings.Split(tableName, "."); len(ns) > 1 {
+		schema = ns[0] + "."
+	}
+	unique := ""
+	if idx.Unique {
+		unique = "unique "
+	}
+	cols := strings.Join(quoteNames(db.DriverName(), idx.Columns), ",")
+	if len(idx.Express) > 0 {
+		cols = idx.Express
+	}
+	tableName = QuoteName(db.DriverName(), tableName)
+	var strSql string
+	switch db.DriverName() {
+	case "postgres", "sqlite3":
+		strSql = fmt.Sprintf("create %sindex %s on %s(%s)", unique, QuoteName(db.DriverName(), idx.Name), tableName, cols)
+		if len(idx.Where) > 0 {
+			strSql += " where " + idx.Where
+		}
+	case "oci8", "mysql":
+		if len(idx.Where) > 0 {
+			return "", fmt.Errorf("%s not support partial index %s", db.DriverName(), idx.Name)
+		}
+		name := QuoteName(db.DriverName(), idx.Name)
+		if db.DriverName() == "oci8" {
+			name = QuoteName(db.DriverName(), schema+idx.Name)
+		} else if len(idx.Express) > 0 {
+			//mysql的函数索引，表达式需要再加括号
+			cols = "(" + cols + ")"
+		}
+		strSql = fmt.Sprintf("create %sindex %s on %s(%s)", unique, name, tableName, cols)
+	default:
+		log.Panic("not impl " + db.DriverName())
+	}
+	return strSql, nil
 }
 
 //删除单字段索引
 func DropColumnIndex(db DB, tableName, indexName string) error {
+	strSql, err := dropColumnIndexSql(db, tableName, indexName)
+	if err != nil {
+		return err
+	}
+	return execDDL(db, strSql)
+}
+
+//删除索引的语句
+func dropColumnIndexSql(db DB, tableName, indexName string) (string, error) {
 	var strSql string
+	tableName = QuoteName(db.DriverName(), tableName)
+	indexName = QuoteName(db.DriverName(), indexName)
 
 	switch db.DriverName() {
 	case "postgres", "oci8", "sqlite3":
@@ -520,57 +711,209 @@ func DropColumnIndex(db DB, tableName, indexName string) error {
 	default:
 		log.Panic("not impl," + db.DriverName())
 	}
-	if _, err := db.Exec(strSql); err != nil {
-		return SqlError{strSql, nil, err}
+	return strSql, nil
+}
+
+//新增一个check约束
+func AddTableCheck(db DB, tableName, name, express string) error {
+	strSql, err := addTableCheckSql(db, tableName, name, express)
+	if err != nil {
+		return err
 	}
-	return nil
+	return execDDL(db, strSql)
+}
+
+//新增check约束的语句
+func addTableCheckSql(db DB, tableName, name, express string) (string, error) {
+	var strSql string
+	tableName = QuoteName(db.DriverName(), tableName)
+	name = QuoteName(db.DriverName(), name)
+	switch db.DriverName() {
+	case "postgres", "oci8", "mysql":
+		strSql = fmt.Sprintf("alter table %s add constraint %s check (%s)", tableName, name, express)
+	default:
+		log.Panic("not impl," + db.DriverName())
+	}
+	return strSql, nil
+}
+
+//删除一个check约束
+func DropTableCheck(db DB, tableName, name string) error {
+	strSql, err := dropTableCheckSql(db, tableName, name)
+	if err != nil {
+		return err
+	}
+	return execDDL(db, strSql)
+}
+
+//删除check约束的语句
+func dropTableCheckSql(db DB, tableName, name string) (string, error) {
+	var strSql string
+	tableName = QuoteName(db.DriverName(), tableName)
+	name = QuoteName(db.DriverName(), name)
+	switch db.DriverName() {
+	case "postgres", "oci8":
+		strSql = fmt.Sprintf("alter table %s drop constraint %s", tableName, name)
+	case "mysql":
+		strSql = fmt.Sprintf("alter table %s drop check %s", tableName, name)
+	default:
+		log.Panic("not impl," + db.DriverName())
+	}
+	return strSql, nil
+}
+
+//新增一个外键，名称以及引用的字段需要事先补全
+func AddTableForeignKey(db DB, tableName string, fk *DBForeignKey) error {
+	strSql, err := addTableForeignKeySql(db, tableName, fk)
+	if err != nil {
+		return err
+	}
+	return execDDL(db, strSql)
+}
+
+//新增外键的语句
+func addTableForeignKeySql(db DB, tableName string, fk *DBForeignKey) (string, error) {
+	var strSql string
+	switch db.DriverName() {
+	case "postgres", "oci8", "mysql":
+		strSql = fmt.Sprintf("alter table %s add %s", QuoteName(db.DriverName(), tableName), fk.define(db.DriverName()))
+	default:
+		log.Panic("not impl," + db.DriverName())
+	}
+	return strSql, nil
+}
+
+//删除一个外键
+func DropTableForeignKey(db DB, tableName, name string) error {
+	strSql, err := dropTableForeignKeySql(db, tableName, name)
+	if err != nil {
+		return err
+	}
+	return execDDL(db, strSql)
+}
+
+//删除外键的语句
+func dropTableForeignKeySql(db DB, tableName, name string) (string, error) {
+	var strSql string
+	tableName = QuoteName(db.DriverName(), tableName)
+	name = QuoteName(db.DriverName(), name)
+	switch db.DriverName() {
+	case "postgres", "oci8":
+		strSql = fmt.Sprintf("alter table %s drop constraint %s", tableName, name)
+	case "mysql":
+		strSql = fmt.Sprintf("alter table %s drop foreign key %s", tableName, name)
+	default:
+		log.Panic("not impl," + db.DriverName())
+	}
+	return strSql, nil
+}
+
+//数据库对象名称超长时，截短并加上原名称的hash，以保证唯一，oracle限定30个字符
+//长度按字节计算，截短时不能截断中文等多字节字符
+func shortName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	n := max - 9
+	for n > 0 && !utf8.RuneStart(name[n]) {
+		n--
+	}
+	return fmt.Sprintf("%s_%08x", name[:n], crc32.ChecksumIEEE([]byte(name)))
 }
 
 //新增主键
 func AddTablePrimaryKey(db DB, tableName string, pks []string) error {
-	var strSql string
-	ns := strings.Split(tableName, ".")
-	var clearTableName string
-	if len(ns) > 1 {
-		clearTableName = ns[1]
-	} else {
-		clearTableName = tableName
+	if db.DriverName() == "sqlite3" {
+		return sqliteRebuild(db, tableName, func(tab *DBTable) {
+			tab.Define(tab.AllField(), pks)
+		})
 	}
+	strSql, err := addTablePrimaryKeySql(db, tableName, pks)
+	if err != nil {
+		return err
+	}
+	return execDDL(db, strSql)
+}
+
+//新增主键的语句
+func addTablePrimaryKeySql(db DB, tableName string, pks []string) (string, error) {
+	var strSql string
+	cols := strings.Join(quoteNames(db.DriverName(), pks), ",")
 	switch db.DriverName() {
 	case "postgres", "mysql":
-		strSql = fmt.Sprintf("alter table %s add primary key(%s)", tableName, strings.Join(pks, ","))
+		strSql = fmt.Sprintf("alter table %s add primary key(%s)", QuoteName(db.DriverName(), tableName), cols)
 	case "oci8":
-		strSql = fmt.Sprintf("alter table %s add constraint %s_pk primary key(%s)", tableName, clearTableName, strings.Join(pks, ","))
+		strSql = fmt.Sprintf("alter table %s add constraint %s primary key(%s)", QuoteName(db.DriverName(), tableName),
+			QuoteName(db.DriverName(), primaryKeyName(db, tableName)), cols)
 	default:
 		log.Panic("not impl," + db.DriverName())
 	}
-	if _, err := db.Exec(strSql); err != nil {
-		return SqlError{strSql, nil, err}
+	return strSql, nil
+}
+
+//addTablePrimaryKeySql新增的主键约束的名称，oracle是表名加_PK，postgres是自动生成的表名加_pkey
+func primaryKeyName(db DB, tableName string) string {
+	ns := strings.Split(tableName, ".")
+	name := ns[len(ns)-1]
+	if db.DriverName() == "postgres" {
+		//区分大小写的表名，自动生成的名称也区分大小写
+		if GetIdentPolicy("postgres").caseSensitive(name) {
+			return name + "_pkey"
+		}
+		return name + "_PKEY"
+	}
+	return objectName("%s_PK", name)
+}
+
+//删除addTablePrimaryKeySql新增的主键，oracle、postgres的主键名称是已知的，不需要查询
+func addedPrimaryKeyDropSql(db DB, tableName string) string {
+	switch db.DriverName() {
+	case "oci8", "postgres":
+		return fmt.Sprintf("alter table %s drop constraint %s", QuoteName(db.DriverName(), tableName),
+			QuoteName(db.DriverName(), primaryKeyName(db, tableName)))
+	default:
+		return fmt.Sprintf("alter table %s drop primary key", QuoteName(db.DriverName(), tableName))
 	}
-	return nil
 }
 
 //删除主键
 func DropTablePrimaryKey(db DB, tableName string) error {
+	if db.DriverName() == "sqlite3" {
+		return sqliteRebuild(db, tableName, func(tab *DBTable) {
+			tab.Define(tab.AllField(), nil)
+		})
+	}
+	strSql, err := dropTablePrimaryKeySql(db, tableName, tableName)
+	if err != nil {
+		return err
+	}
+	//没有主键
+	if len(strSql) == 0 {
+		return nil
+	}
+	return execDDL(db, strSql)
+}
+
+//删除主键的语句，主键约束的名称从lookupName表中获取，表如果在之前的步骤中改名，
+//则lookupName是改名前的名称，没有主键的返回空串
+func dropTablePrimaryKeySql(db DB, lookupName, tableName string) (string, error) {
 	log.WithFields(log.Fields{
 		"table": tableName,
 	}).Debug("dropkey")
+	tableName = QuoteName(db.DriverName(), tableName)
 	switch db.DriverName() {
 	case "postgres":
 		//先获取主键索引的名称，然后删除索引
 		strSql := fmt.Sprintf(
 			"select b.relname from  pg_index a inner join pg_class b on a.indexrelid =b.oid where indisprimary and indrelid='%s'::regclass",
-			tableName)
+			QuoteName(db.DriverName(), lookupName))
 		pkCons := ""
 		if err := db.Get(&pkCons, strSql); err != nil {
-			return SqlError{strSql, nil, err}
-		}
-		strSql = fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", tableName, pkCons)
-		if _, err := db.Exec(strSql); err != nil {
-			return SqlError{strSql, nil, err}
+			return "", SqlError{strSql, nil, err}
 		}
+		return fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", tableName, QuoteName(db.DriverName(), pkCons)), nil
 	case "oci8":
-		ns := strings.Split(tableName, ".")
+		ns := strings.Split(lookupName, ".")
 		var strSql string
 		if len(ns) > 1 {
 			strSql = fmt.Sprintf(
@@ -580,42 +923,63 @@ func DropTablePrimaryKey(db DB, tableName string) error {
 		} else {
 			strSql = fmt.Sprintf(
 				"select constraint_name from user_CONSTRAINTS where table_name ='%s' and constraint_type='P'",
-				strings.ToUpper(tableName))
+				strings.ToUpper(lookupName))
 		}
-		pkCons := ""
-		if rows, _, err := QueryRecord(db, strSql, nil); err != nil {
-			return SqlError{strSql, nil, err}
-		} else {
-			if len(rows) > 0 {
-				pkCons = rows[0]["CONSTRAINT_NAME"].(string)
-			} else {
-				return nil
-			}
+		rows, _, err := QueryRecord(db, strSql, nil)
+		if err != nil {
+			return "", SqlError{strSql, nil, err}
 		}
-		strSql = fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", tableName, pkCons)
-		if _, err := db.Exec(strSql); err != nil {
-			return SqlError{strSql, nil, err}
+		if len(rows) == 0 {
+			return "", nil
 		}
+		return fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", tableName, QuoteName(db.DriverName(), rows[0]["CONSTRAINT_NAME"].(string))), nil
 	case "mysql":
-		strSql := fmt.Sprintf("ALTER TABLE %s DROP PRIMARY KEY", tableName)
-		if _, err := db.Exec(strSql); err != nil {
-			return SqlError{strSql, nil, err}
-		}
+		return fmt.Sprintf("ALTER TABLE %s DROP PRIMARY KEY", tableName), nil
 	default:
 		log.Panic("not impl," + db.DriverName())
 	}
+	return "", nil
+}
+
+//执行一个结构调整的语句，并记录日志
+func execDDL(db DB, strSql string) error {
+	if _, err := db.Exec(strSql); err != nil {
+		return SqlError{strSql, nil, err}
+	}
+	log.Println(strSql)
 	return nil
 }
 
 //返回一个字段值的字符串表达式
 func ValueExpress(db DB, dataType int, value string) string {
+	return valueExpress(db.DriverName(), dataType, value)
+}
+
+//指定驱动下字段值的字符串表达式，用于只有驱动名称的地方，例如字段定义中的默认值
+func valueExpress(driver string, dataType int, value string) string {
 	switch dataType {
-	case TypeFloat, TypeInt:
+	case TypeFloat, TypeInt, TypeDecimal:
 		return value
-	case TypeString:
+	case TypeString, TypeJson, TypeCustom:
+		//自定义类型按字符串处理，由数据库隐式转换
 		return safe.SignString(value)
+	case TypeBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Panic(fmt.Errorf("invalid bool:%s", value))
+		}
+		switch driver {
+		case "postgres":
+			return strconv.FormatBool(b)
+		default:
+			//其他数据库用0、1表示
+			if b {
+				return "1"
+			}
+			return "0"
+		}
 	case TypeDatetime:
-		switch db.DriverName() {
+		switch driver {
 		case "oci8":
 			if len(value) == 10 {
 				return fmt.Sprintf("to_date(%s,'yyyy-mm-dd')", safe.SignString(value))
@@ -626,7 +990,7 @@ func ValueExpress(db DB, dataType int, value string) string {
 				return ""
 			}
 		default:
-			log.Panic(fmt.Errorf("not impl datetime,dbtype:%s", db.DriverName()))
+			log.Panic(fmt.Errorf("not impl datetime,dbtype:%s", driver))
 			return ""
 		}
 	default:
@@ -650,7 +1014,10 @@ func Minus(db DB, table1, where1, table2, where2 string, primaryKeys, cols []str
 	if len(where2) > 0 {
 		where2 = "where " + where2
 	}
-
+	table1 = QuoteName(db.DriverName(), table1)
+	table2 = QuoteName(db.DriverName(), table2)
+	primaryKeys = quoteNames(db.DriverName(), primaryKeys)
+	cols = quoteNames(db.DriverName(), cols)
 	switch db.DriverName() {
 	case "oci8":
 		strSql = fmt.Sprintf(
@@ -726,9 +1093,9 @@ func DropIndexIfExists(db DB, indexName string) error {
 		    EXECUTE IMMEDIATE %s;
 		  END IF;
 		END;`, indexName,
-			safe.SignString(fmt.Sprintf("drop index %s", indexName)))
+			safe.SignString(fmt.Sprintf("drop index %s", QuoteName(db.DriverName(), indexName))))
 	case "sqlite3", "postgres":
-		strSQL = fmt.Sprintf("drop index if exists %s", indexName)
+		strSQL = fmt.Sprintf("drop index if exists %s", QuoteName(db.DriverName(), indexName))
 	default:
 		return fmt.Errorf("invalid driver")
 	}
@@ -754,9 +1121,11 @@ func CreateIndexIfNotExists(db DB, indexName, tableName, express string) error {
 		    EXECUTE IMMEDIATE %s;
 		  END IF;
 		END;`, indexName,
-			safe.SignString(fmt.Sprintf("create index %s on %s(%s)", indexName, tableName, express)))
+			safe.SignString(fmt.Sprintf("create index %s on %s(%s)", QuoteName(db.DriverName(), indexName),
+				QuoteName(db.DriverName(), tableName), express)))
 	case "sqlite3", "postgres":
-		strSQL = fmt.Sprintf("create index if not exists %s on %s(%s)", indexName, tableName, express)
+		strSQL = fmt.Sprintf("create index if not exists %s on %s(%s)", QuoteName(db.DriverName(), indexName),
+			QuoteName(db.DriverName(), tableName), express)
 	default:
 		return fmt.Errorf("invalid driver")
 	}
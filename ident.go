@@ -0,0 +1,161 @@
+package dbx
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//IdentPolicy 数据库标识符（表名、字段名、索引名、约束名）的引号和大小写策略
+//dbx中不区分大小写的名称一律是大写，是保留字或者含有中文等特殊字符时，用引号引用，
+//并按数据库保存不带引号标识符的方式转换大小写，以保证和不带引号时是同一个对象
+//区分大小写的名称含有小写字母，例如postgres中用引号建立的"OrderItem"，原样用引号引用
+type IdentPolicy struct {
+	Quote         string //引用标识符的引号，如"、`
+	Lower         bool   //不带引号的标识符保存为小写，例如postgres，否则保存为大写或者原样保存
+	CaseSensitive bool   //带引号的标识符区分大小写，为false时含有小写字母的名称也按不区分大小写处理
+}
+
+var (
+	identPoliciesLock sync.RWMutex
+	identPolicies     = map[string]*IdentPolicy{
+		"postgres": {Quote: `"`, Lower: true, CaseSensitive: true},
+		"oci8":     {Quote: `"`},
+		"mysql":    {Quote: "`"},
+		"sqlite3":  {Quote: `"`},
+	}
+	//普通的标识符，不需要引号
+	plainIdentReg = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	//可以作为标识符的名称，含有其他字符的是表达式，例如count(*)、a+b，不做处理
+	wordIdentReg = regexp.MustCompile(`^[\p{L}\p{N}_$#]+$`)
+)
+
+//各数据库常见的保留字，作为名称时需要引号
+var reservedWords = map[string]bool{}
+
+func init() {
+	for _, v := range strings.Fields(`ACCESS ADD ALL ALTER AND ANY AS ASC AUDIT BETWEEN BOTH BY CASE CAST CHAR CHECK
+		CLUSTER COLLATE COLUMN COMMENT COMPRESS CONNECT CONSTRAINT CREATE CROSS CURRENT CURRENT_DATE CURRENT_TIME
+		CURRENT_TIMESTAMP CURRENT_USER DATE DECIMAL DEFAULT DELETE DESC DISTINCT DO DROP ELSE END EXCEPT EXCLUSIVE
+		EXISTS FALSE FETCH FILE FLOAT FOR FOREIGN FROM FULL GRANT GROUP HAVING IDENTIFIED IMMEDIATE IN INCREMENT INDEX
+		INITIAL INNER INSERT INTEGER INTERSECT INTERVAL INTO IS JOIN KEY KEYS LEADING LEFT LEVEL LIKE LIMIT LOCK LONG
+		MAXEXTENTS MINUS MODE MODIFY NATURAL NOAUDIT NOCOMPRESS NOT NOWAIT NULL NUMBER OF OFFLINE OFFSET ON ONLINE
+		OPTION OR ORDER OUTER PCTFREE PRIMARY PRIOR PRIVILEGES PUBLIC RANGE RAW READ REFERENCES RENAME RESOURCE REVOKE
+		RIGHT ROW ROWID ROWNUM ROWS SELECT SESSION SET SHARE SIZE SMALLINT START SUCCESSFUL SYNONYM SYSDATE TABLE
+		THEN TO TRAILING TRIGGER TRUE UID UNION UNIQUE UPDATE USER USING VALIDATE VALUES VARCHAR VARCHAR2 VIEW WHEN
+		WHENEVER WHERE WINDOW WITH`) {
+		reservedWords[v] = true
+	}
+}
+
+//注册一个数据库驱动的标识符策略，同名的会被替换，一般在init中调用
+func RegisterIdentPolicy(driver string, p *IdentPolicy) {
+	if p == nil || len(p.Quote) == 0 {
+		log.Panic(fmt.Errorf("driver %s ident quote is empty", driver))
+	}
+	identPoliciesLock.Lock()
+	defer identPoliciesLock.Unlock()
+	identPolicies[driver] = p
+}
+
+//返回数据库驱动的标识符策略，没有注册的驱动用双引号，不区分大小写
+func GetIdentPolicy(driver string) *IdentPolicy {
+	identPoliciesLock.RLock()
+	defer identPoliciesLock.RUnlock()
+	if p, ok := identPolicies[driver]; ok {
+		return p
+	}
+	return &IdentPolicy{Quote: `"`}
+}
+
+//是否区分大小写的名称
+func (p *IdentPolicy) caseSensitive(name string) bool {
+	return p.CaseSensitive && name != strings.ToUpper(name)
+}
+
+//引用一个名称，不需要引号的原样返回，已经带有引号的以及表达式也原样返回
+func (p *IdentPolicy) quote(name string) string {
+	if strings.ContainsAny(name, "\"`") || !wordIdentReg.MatchString(name) {
+		return name
+	}
+	if p.caseSensitive(name) {
+		return p.Quote + name + p.Quote
+	}
+	if plainIdentReg.MatchString(name) && !reservedWords[strings.ToUpper(name)] {
+		return name
+	}
+	if p.Lower {
+		name = strings.ToLower(name)
+	}
+	return p.Quote + name + p.Quote
+}
+
+//数据库中保存的名称，用于以字符串作为参数的系统函数和系统表的查询
+func storedName(driver, name string) string {
+	p := GetIdentPolicy(driver)
+	switch {
+	case p.caseSensitive(name):
+		return name
+	case p.Lower:
+		return strings.ToLower(name)
+	}
+	return strings.ToUpper(name)
+}
+
+//数据库返回的名称转换成dbx中的名称，不区分大小写的转换成大写
+func (p *IdentPolicy) fetched(name string) string {
+	if p.CaseSensitive {
+		folded := strings.ToUpper(name)
+		if p.Lower {
+			folded = strings.ToLower(name)
+		}
+		if name != folded {
+			return name
+		}
+	}
+	return strings.ToUpper(name)
+}
+
+//QuoteName 按驱动的策略引用名称，可以带有schema，如S.ORDER，可以重复调用
+func QuoteName(driver, name string) string {
+	if strings.ContainsAny(name, "\"`") {
+		return name
+	}
+	p := GetIdentPolicy(driver)
+	ns := strings.Split(name, ".")
+	for i, v := range ns {
+		ns[i] = p.quote(v)
+	}
+	return strings.Join(ns, ".")
+}
+
+//引用一组名称
+func quoteNames(driver string, names []string) []string {
+	rev := make([]string, len(names))
+	for i, v := range names {
+		rev[i] = QuoteName(driver, v)
+	}
+	return rev
+}
+
+//FetchedName 数据库目录中返回的表名、字段名等转换成dbx中的名称，不区分大小写的是大写，区分大小写的原样返回，
+//查询结果的字段名不用此函数，一律转换为大写
+func FetchedName(driver, name string) string {
+	return GetIdentPolicy(driver).fetched(name)
+}
+
+//用户给出的名称转换成dbx中的名称，带引号的区分大小写，去掉引号后原样返回，否则转换成大写
+func defineName(name string) string {
+	if len(name) > 1 && (name[0] == '"' && name[len(name)-1] == '"' || name[0] == '`' && name[len(name)-1] == '`') {
+		return name[1 : len(name)-1]
+	}
+	return strings.ToUpper(name)
+}
+
+//生成索引、约束等数据库对象的名称，转换成大写，超过oracle的30个字符限定的截短
+func objectName(format string, a ...interface{}) string {
+	return shortName(strings.ToUpper(fmt.Sprintf(format, a...)), 30)
+}
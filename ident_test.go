@@ -0,0 +1,43 @@
+package dbx
+
+import "testing"
+
+func TestQuoteName(t *testing.T) {
+	cases := []struct{ driver, name, want string }{
+		{"postgres", "NAME", "NAME"},
+		{"postgres", "ORDER", `"order"`},
+		{"postgres", "OrderItem", `"OrderItem"`},
+		{"postgres", "订单", `"订单"`},
+		{"postgres", "S.USER", `S."user"`},
+		{"postgres", `"order"`, `"order"`},
+		{"postgres", "count(*)", "count(*)"},
+		{"oci8", "ORDER", `"ORDER"`},
+		{"oci8", "OrderItem", "OrderItem"},
+		{"mysql", "DESC", "`DESC`"},
+	}
+	for _, c := range cases {
+		got := QuoteName(c.driver, c.name)
+		if got != c.want {
+			t.Errorf("%s %s: got %s want %s", c.driver, c.name, got, c.want)
+		}
+		//可以重复调用
+		if again := QuoteName(c.driver, got); again != got {
+			t.Errorf("%s %s: quote again got %s", c.driver, c.name, again)
+		}
+	}
+}
+
+func TestFetchedName(t *testing.T) {
+	cases := []struct{ driver, name, want string }{
+		{"postgres", "order", "ORDER"},
+		{"postgres", "OrderItem", "OrderItem"},
+		{"oci8", "ORDER", "ORDER"},
+		{"oci8", "OrderItem", "ORDERITEM"},
+		{"mysql", "order_item", "ORDER_ITEM"},
+	}
+	for _, c := range cases {
+		if got := FetchedName(c.driver, c.name); got != c.want {
+			t.Errorf("%s %s: got %s want %s", c.driver, c.name, got, c.want)
+		}
+	}
+}
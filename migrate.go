@@ -0,0 +1,347 @@
+package dbx
+
+import (
+	"crypto/sha1"
+	"dbweb/lib/safe"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//默认的迁移历史表名称
+const DefaultMigrationTable = "DBX_MIGRATION"
+
+//执行迁移时在历史表中插入的锁定行，同时只能有一个执行器，异常退出后锁定行需要手工删除
+const migrationLockVersion = "#LOCK"
+
+//不能在事务中执行的步骤，执行前记录在历史表中，校验码前加上此标记，执行完成后去掉
+const migrationPendingPrefix = "PENDING:"
+
+var versionNumberReg = regexp.MustCompile(`^[0-9]+$`)
+
+//比较两个版本，按.分成多段逐段比较，都是数字的段按数值比较，例如2 < 10、1.2 < 1.10，
+//其他的段按字符串比较
+func compareVersion(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, y := as[i], bs[i]
+		if versionNumberReg.MatchString(x) && versionNumberReg.MatchString(y) {
+			x, y = strings.TrimLeft(x, "0"), strings.TrimLeft(y, "0")
+			if len(x) != len(y) {
+				if len(x) < len(y) {
+					return -1
+				}
+				return 1
+			}
+		}
+		if c := strings.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return len(as) - len(bs)
+}
+
+//Migration 一个迁移步骤，按Version的顺序执行，执行过的记录在历史表中
+//Version按compareVersion的规则排序，数字按数值比较
+//Table和Define是DefineScript格式的表定义，用UpdateSchema调整表结构，
+//Up是原始的sql，用BatchExec执行，两者都有时先调整表结构再执行sql
+//Down是回滚的sql，没有Down的步骤不能回滚
+//Policy是调整表结构的策略，为空则只拒绝破坏性的调整，不参与校验
+type Migration struct {
+	Version string
+	Name    string
+	Table   string
+	Define  string
+	Up      string
+	Down    string
+	Policy  *SchemaPolicy
+}
+
+//步骤内容的校验码，执行过的步骤内容不能再修改，Down不参与校验
+func (m *Migration) Checksum() string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join([]string{m.Table, m.Define, m.Up}, "\n"))))
+}
+
+//执行一个步骤
+func (m *Migration) up(db DB) error {
+	if len(m.Table) > 0 {
+		tab := NewTable(db, m.Table)
+		tab.DefineScript(m.Define)
+		if err := tab.UpdateSchemaPolicy(m.Policy); err != nil {
+			return err
+		}
+	}
+	if len(strings.TrimSpace(m.Up)) > 0 {
+		if err := BatchExec(db, m.Up, map[string]interface{}{}); err != nil {
+			return SqlError{m.Up, nil, err}
+		}
+	}
+	return nil
+}
+
+//MigrationRecord 历史表中的一条记录
+type MigrationRecord struct {
+	Version  string
+	Name     string
+	Checksum string
+	Applied  time.Time
+}
+
+//执行中断的记录，步骤执行了一部分或者执行完成但是没有标记完成
+func (r *MigrationRecord) interrupted() bool {
+	return strings.HasPrefix(r.Checksum, migrationPendingPrefix)
+}
+
+//Migrator 迁移的执行器，历史表不存在时自动创建
+type Migrator struct {
+	Db         DB
+	Table      string
+	Migrations []*Migration
+}
+
+func NewMigrator(db DB, migrations ...*Migration) *Migrator {
+	return &Migrator{
+		Db:         db,
+		Table:      DefaultMigrationTable,
+		Migrations: migrations,
+	}
+}
+
+//历史表，db可以是事务
+func (m *Migrator) historyTable(db DB) *DBTable {
+	tab := NewTable(db, m.Table)
+	tab.DefineScript(`VERSION str(50)
+NAME str(200)
+CHECKSUM str(64) not null
+APPLIED timestamp not null
+primary key(VERSION)`)
+	return tab
+}
+
+//创建历史表
+func (m *Migrator) prepare() (*DBTable, error) {
+	tab := m.historyTable(m.Db)
+	if err := prepareTable(tab); err != nil {
+		return nil, err
+	}
+	return tab, nil
+}
+
+//按版本排序的步骤，版本不能为空也不能重复，数值相同的版本如1和01也是重复
+func (m *Migrator) sorted() ([]*Migration, error) {
+	rev := append([]*Migration{}, m.Migrations...)
+	sort.SliceStable(rev, func(i, j int) bool {
+		return compareVersion(rev[i].Version, rev[j].Version) < 0
+	})
+	for i, v := range rev {
+		if len(v.Version) == 0 {
+			return nil, fmt.Errorf("migration %s version is empty", v.Name)
+		}
+		if v.Version == migrationLockVersion {
+			return nil, fmt.Errorf("migration version %s is reserved", v.Version)
+		}
+		if i > 0 && compareVersion(rev[i-1].Version, v.Version) == 0 {
+			return nil, fmt.Errorf("migration version %s dup with %s", v.Version, rev[i-1].Version)
+		}
+	}
+	return rev, nil
+}
+
+//插入锁定行，插入失败说明有其他执行器正在执行，返回解除锁定的函数
+func (m *Migrator) lock(tab *DBTable) (func(), error) {
+	if err := tab.Insert([]map[string]interface{}{{
+		"VERSION":  migrationLockVersion,
+		"NAME":     "locked by migrator",
+		"CHECKSUM": "-",
+		"APPLIED":  time.Now(),
+	}}); err != nil {
+		return nil, fmt.Errorf("migration table %s is locked, another migrator is running, "+
+			"or delete the row of version %s if it exited abnormally:%s", tab.Name(), migrationLockVersion, err)
+	}
+	return func() {
+		if err := tab.RemoveByKeyValues(migrationLockVersion); err != nil {
+			log.Error(err)
+		}
+	}, nil
+}
+
+//Applied 返回已经执行的记录，按版本排序
+func (m *Migrator) Applied() ([]*MigrationRecord, error) {
+	tab, err := m.prepare()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tab.QueryRowsOrder("", nil, []string{"VERSION"})
+	if err != nil {
+		return nil, err
+	}
+	rev := []*MigrationRecord{}
+	for _, v := range rows {
+		if safe.String(v["VERSION"]) == migrationLockVersion {
+			continue
+		}
+		rev = append(rev, &MigrationRecord{
+			Version:  safe.String(v["VERSION"]),
+			Name:     safe.String(v["NAME"]),
+			Checksum: safe.String(v["CHECKSUM"]),
+			Applied:  safe.Date(v["APPLIED"]),
+		})
+	}
+	//数据库中按字符串排序，需要按版本重新排序
+	sort.SliceStable(rev, func(i, j int) bool {
+		return compareVersion(rev[i].Version, rev[j].Version) < 0
+	})
+	return rev, nil
+}
+
+//Pending 返回还没有执行的步骤，已执行的步骤内容被修改过、或者未执行的步骤版本比已执行的旧，都返回错误
+func (m *Migrator) Pending() ([]*Migration, error) {
+	list, err := m.sorted()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := m.Applied()
+	if err != nil {
+		return nil, err
+	}
+	done := map[string]*MigrationRecord{}
+	last := ""
+	for _, v := range applied {
+		done[v.Version] = v
+		last = v.Version
+	}
+	rev := []*Migration{}
+	for _, v := range list {
+		if r, ok := done[v.Version]; ok {
+			if r.interrupted() {
+				return nil, fmt.Errorf("migration %s %s was interrupted, check the database then fix or delete its history row", v.Version, v.Name)
+			}
+			if r.Checksum != v.Checksum() {
+				return nil, fmt.Errorf("migration %s %s changed after applied", v.Version, v.Name)
+			}
+			continue
+		}
+		if len(last) > 0 && compareVersion(v.Version, last) < 0 {
+			return nil, fmt.Errorf("migration %s is older than applied %s", v.Version, last)
+		}
+		rev = append(rev, v)
+	}
+	return rev, nil
+}
+
+//执行一个步骤并记录到历史表，postgres的步骤以及sqlite3只有sql的步骤，和历史记录在同一个事务中执行，
+//其他的DDL不能回滚，或者sqlite3重建表不能在外部的事务中执行，先记录为执行中，执行完成后再标记完成
+func (m *Migrator) apply(v *Migration) error {
+	record := func(db DB, checksum string) error {
+		return m.historyTable(db).Insert([]map[string]interface{}{{
+			"VERSION":  v.Version,
+			"NAME":     v.Name,
+			"CHECKSUM": checksum,
+			"APPLIED":  time.Now(),
+		}})
+	}
+	driver := m.Db.DriverName()
+	if driver == "postgres" || driver == "sqlite3" && len(v.Table) == 0 {
+		run := func(db DB) error {
+			if err := v.up(db); err != nil {
+				return err
+			}
+			return record(db, v.Checksum())
+		}
+		if db, ok := m.Db.(*sqlx.DB); ok {
+			return RunAtTx(db, run)
+		}
+		return run(m.Db)
+	}
+	if err := record(m.Db, migrationPendingPrefix+v.Checksum()); err != nil {
+		return err
+	}
+	if err := v.up(m.Db); err != nil {
+		return err
+	}
+	return m.historyTable(m.Db).UpdateByKey([]interface{}{v.Version}, map[string]interface{}{
+		"CHECKSUM": v.Checksum(),
+		"APPLIED":  time.Now(),
+	})
+}
+
+//Up 按顺序执行所有未执行的步骤，每执行完一个就记录到历史表，出错即停止，
+//不能和历史记录在一个事务中执行的步骤出错后，历史记录保留为执行中，需要人工检查后修正或删除
+//执行期间锁定历史表，并发执行时后来的返回错误
+func (m *Migrator) Up() error {
+	tab, err := m.prepare()
+	if err != nil {
+		return err
+	}
+	unlock, err := m.lock(tab)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	list, err := m.Pending()
+	if err != nil {
+		return err
+	}
+	for _, v := range list {
+		if err := m.apply(v); err != nil {
+			return fmt.Errorf("migration %s %s error:%s", v.Version, v.Name, err)
+		}
+		log.Printf("migration %s %s applied\n", v.Version, v.Name)
+	}
+	return nil
+}
+
+//Down 从最后一个开始回滚n个已执行的步骤，步骤必须有Down，回滚后删除历史记录，执行期间同样锁定历史表
+func (m *Migrator) Down(n int) error {
+	list, err := m.sorted()
+	if err != nil {
+		return err
+	}
+	tab, err := m.prepare()
+	if err != nil {
+		return err
+	}
+	unlock, err := m.lock(tab)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	byVersion := map[string]*Migration{}
+	for _, v := range list {
+		byVersion[v.Version] = v
+	}
+	applied, err := m.Applied()
+	if err != nil {
+		return err
+	}
+	for i := len(applied) - 1; i >= 0 && n > 0; i, n = i-1, n-1 {
+		r := applied[i]
+		v, ok := byVersion[r.Version]
+		if !ok {
+			return fmt.Errorf("migration %s not found", r.Version)
+		}
+		if r.interrupted() {
+			return fmt.Errorf("migration %s %s was interrupted, check the database then fix or delete its history row", v.Version, v.Name)
+		}
+		if v.Checksum() != r.Checksum {
+			return fmt.Errorf("migration %s %s changed after applied", v.Version, v.Name)
+		}
+		if len(strings.TrimSpace(v.Down)) == 0 {
+			return fmt.Errorf("migration %s %s can't rollback, down is empty", v.Version, v.Name)
+		}
+		if err := BatchExec(m.Db, v.Down, map[string]interface{}{}); err != nil {
+			return SqlError{v.Down, nil, err}
+		}
+		if err := tab.RemoveByKeyValues(r.Version); err != nil {
+			return err
+		}
+		log.Printf("migration %s %s rollback\n", v.Version, v.Name)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package dbx
+
+import "testing"
+
+func TestCompareVersion(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"2", "10", -1},
+		{"10", "2", 1},
+		{"01", "1", 0},
+		{"1.2", "1.10", -1},
+		{"1.2", "1.2.1", -1},
+		{"20260101", "20251231", 1},
+		{"1.a", "1.b", -1},
+	}
+	for _, c := range cases {
+		got := compareVersion(c.a, c.b)
+		if got < 0 {
+			got = -1
+		} else if got > 0 {
+			got = 1
+		}
+		if got != c.want {
+			t.Errorf("%s %s: got %d want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestMigratorSorted(t *testing.T) {
+	m := NewMigrator(nil, &Migration{Version: "10"}, &Migration{Version: "9"}, &Migration{Version: "1"})
+	list, err := m.sorted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list[0].Version != "1" || list[1].Version != "9" || list[2].Version != "10" {
+		t.Errorf("sorted %s %s %s", list[0].Version, list[1].Version, list[2].Version)
+	}
+	m.Migrations = append(m.Migrations, &Migration{Version: "01"})
+	if _, err := m.sorted(); err == nil {
+		t.Error("01 must dup with 1")
+	}
+}
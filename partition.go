@@ -0,0 +1,532 @@
+package dbx
+
+import (
+	"dbweb/lib/safe"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//分区的方式
+const (
+	PartitionRange = "RANGE" //按范围分区，一般是日期字段，每个分区是小于上限的值
+	PartitionList  = "LIST"  //按值列表分区，一般是文本字段
+	PartitionHash  = "HASH"  //按键值的哈希分区
+)
+
+//DBPartition 表的分区定义，只在建表时生成，已有表用AddPartition、DropPartition调整分区
+//postgres的每个分区是单独的表，名称就是分区名，主键必须包含分区字段
+type DBPartition struct {
+	Type    string
+	Columns []string
+	Parts   []*DBPartitionPart
+	Count   int //哈希分区的数量，Parts为空时使用，分区名自动生成
+}
+
+//DBPartitionPart 一个分区，Values是不带引号的值，MAXVALUE表示没有上限
+//范围分区是一个上限值，不含上限，postgres的下限是前一个分区的上限
+type DBPartitionPart struct {
+	Name   string
+	Values []string
+}
+
+//复制分区定义
+func (p *DBPartition) clone() *DBPartition {
+	rev := &DBPartition{
+		Type:    p.Type,
+		Columns: append([]string{}, p.Columns...),
+		Count:   p.Count,
+	}
+	for _, v := range p.Parts {
+		rev.Parts = append(rev.Parts, &DBPartitionPart{v.Name, append([]string{}, v.Values...)})
+	}
+	return rev
+}
+
+//哈希分区的所有分区名，没有定义Parts时，用表名加序号
+func (t *DBTable) hashPartNames() []string {
+	rev := []string{}
+	for _, v := range t.Partition.Parts {
+		rev = append(rev, v.Name)
+	}
+	if len(rev) == 0 {
+		for i := 0; i < t.Partition.Count; i++ {
+			rev = append(rev, fmt.Sprintf("%s_P%d", t.TableName, i))
+		}
+	}
+	return rev
+}
+
+//分区值的表达式，按分区字段的类型加引号
+func (t *DBTable) partitionValue(colName, v string) string {
+	if strings.ToUpper(v) == "MAXVALUE" {
+		return "MAXVALUE"
+	}
+	col := t.Field(colName)
+	if col == nil {
+		return v
+	}
+	switch col.GoType() {
+	case TypeInt, TypeFloat, TypeDecimal:
+		return v
+	case TypeDatetime:
+		if t.Db.DriverName() == "oci8" {
+			return fmt.Sprintf("TO_DATE('%s','YYYY-MM-DD HH24:MI:SS')", v)
+		}
+	}
+	return "'" + strings.Replace(v, "'", "''", -1) + "'"
+}
+
+//一个分区的值列表
+func (t *DBTable) partitionValues(part *DBPartitionPart) string {
+	rev := []string{}
+	for i, v := range part.Values {
+		col := t.Partition.Columns[0]
+		if t.Partition.Type == PartitionRange && i < len(t.Partition.Columns) {
+			col = t.Partition.Columns[i]
+		}
+		rev = append(rev, t.partitionValue(col, v))
+	}
+	return strings.Join(rev, ",")
+}
+
+//建表语句后面的分区子句，postgres的分区另外建立
+func (t *DBTable) partitionClause() (string, error) {
+	p := t.Partition
+	if len(p.Columns) == 0 {
+		return "", fmt.Errorf("table %s partition columns is empty", t.Name())
+	}
+	for _, v := range p.Columns {
+		if t.Field(v) == nil {
+			return "", fmt.Errorf("table %s partition column %s not exists", t.Name(), v)
+		}
+	}
+	cols := strings.Join(quoteNames(t.Db.DriverName(), p.Columns), ",")
+	switch t.Db.DriverName() {
+	case "postgres":
+		switch p.Type {
+		case PartitionRange, PartitionList, PartitionHash:
+			return fmt.Sprintf("PARTITION BY %s (%s)", p.Type, cols), nil
+		}
+	case "oci8", "mysql":
+		parts := []string{}
+		switch p.Type {
+		case PartitionRange:
+			for _, v := range p.Parts {
+				parts = append(parts, fmt.Sprintf("PARTITION %s VALUES LESS THAN (%s)", t.quote(v.Name), t.partitionValues(v)))
+			}
+		case PartitionList:
+			in := ""
+			if t.Db.DriverName() == "mysql" {
+				in = " IN"
+			}
+			for _, v := range p.Parts {
+				parts = append(parts, fmt.Sprintf("PARTITION %s VALUES%s (%s)", t.quote(v.Name), in, t.partitionValues(v)))
+			}
+		case PartitionHash:
+			for _, v := range t.hashPartNames() {
+				parts = append(parts, "PARTITION "+t.quote(v))
+			}
+		default:
+			return "", fmt.Errorf("invalid partition type %s", p.Type)
+		}
+		if len(parts) == 0 {
+			return "", fmt.Errorf("table %s partition is empty", t.Name())
+		}
+		method := p.Type
+		//mysql的非整数字段要用COLUMNS、KEY
+		if t.Db.DriverName() == "mysql" {
+			if p.Type == PartitionHash {
+				method = "KEY"
+			} else {
+				method += " COLUMNS"
+			}
+		}
+		return fmt.Sprintf("PARTITION BY %s (%s) (\n%s\n)", method, cols, strings.Join(parts, ",\n")), nil
+	default:
+		return "", fmt.Errorf("%s not support partition", t.Db.DriverName())
+	}
+	return "", fmt.Errorf("invalid partition type %s", p.Type)
+}
+
+//postgres建立一个分区表的语句，lower是范围分区的下限
+func (t *DBTable) pgPartitionSql(part *DBPartitionPart, lower string, remainder int) string {
+	name := part.Name
+	if len(t.Schema) > 0 {
+		name = t.Schema + "." + name
+	}
+	var bound string
+	switch t.Partition.Type {
+	case PartitionRange:
+		bound = fmt.Sprintf("FROM (%s) TO (%s)", lower, t.partitionValues(part))
+	case PartitionList:
+		bound = fmt.Sprintf("IN (%s)", t.partitionValues(part))
+	case PartitionHash:
+		bound = fmt.Sprintf("WITH (MODULUS %d, REMAINDER %d)", len(t.hashPartNames()), remainder)
+	}
+	return fmt.Sprintf("CREATE TABLE %s PARTITION OF %s FOR VALUES %s", t.quote(name), t.quotedName(), bound)
+}
+
+//范围分区的下一个下限，第一个分区是MINVALUE
+func (t *DBTable) pgRangeLower(prev *DBPartitionPart) string {
+	if prev == nil {
+		rev := []string{}
+		for range t.Partition.Columns {
+			rev = append(rev, "MINVALUE")
+		}
+		return strings.Join(rev, ",")
+	}
+	return t.partitionValues(prev)
+}
+
+//postgres建立所有分区的语句
+func (t *DBTable) pgPartitionsSql() []string {
+	rev := []string{}
+	if t.Partition.Type == PartitionHash {
+		for i, v := range t.hashPartNames() {
+			rev = append(rev, t.pgPartitionSql(&DBPartitionPart{Name: v}, "", i))
+		}
+		return rev
+	}
+	var prev *DBPartitionPart
+	for _, v := range t.Partition.Parts {
+		rev = append(rev, t.pgPartitionSql(v, t.pgRangeLower(prev), 0))
+		prev = v
+	}
+	return rev
+}
+
+//AddPartition 新增一个分区，范围分区只能加在最后，表的分区定义需要先获取
+//postgres不支持新增哈希分区
+func (t *DBTable) AddPartition(part *DBPartitionPart) error {
+	if t.Partition == nil {
+		return fmt.Errorf("table %s not partitioned", t.Name())
+	}
+	var strSql string
+	switch t.Db.DriverName() {
+	case "postgres":
+		if t.Partition.Type == PartitionHash {
+			return fmt.Errorf("postgres can't add hash partition")
+		}
+		var prev *DBPartitionPart
+		if n := len(t.Partition.Parts); n > 0 {
+			prev = t.Partition.Parts[n-1]
+		}
+		strSql = t.pgPartitionSql(part, t.pgRangeLower(prev), 0)
+	case "oci8":
+		switch t.Partition.Type {
+		case PartitionRange:
+			strSql = fmt.Sprintf("ALTER TABLE %s ADD PARTITION %s VALUES LESS THAN (%s)", t.quotedName(), t.quote(part.Name), t.partitionValues(part))
+		case PartitionList:
+			strSql = fmt.Sprintf("ALTER TABLE %s ADD PARTITION %s VALUES (%s)", t.quotedName(), t.quote(part.Name), t.partitionValues(part))
+		default:
+			strSql = fmt.Sprintf("ALTER TABLE %s ADD PARTITION %s", t.quotedName(), t.quote(part.Name))
+		}
+	case "mysql":
+		switch t.Partition.Type {
+		case PartitionRange:
+			strSql = fmt.Sprintf("ALTER TABLE %s ADD PARTITION (PARTITION %s VALUES LESS THAN (%s))", t.quotedName(), t.quote(part.Name), t.partitionValues(part))
+		case PartitionList:
+			strSql = fmt.Sprintf("ALTER TABLE %s ADD PARTITION (PARTITION %s VALUES IN (%s))", t.quotedName(), t.quote(part.Name), t.partitionValues(part))
+		default:
+			strSql = fmt.Sprintf("ALTER TABLE %s ADD PARTITION (PARTITION %s)", t.quotedName(), t.quote(part.Name))
+		}
+	default:
+		return fmt.Errorf("%s not support partition", t.Db.DriverName())
+	}
+	if err := execDDL(t.Db, strSql); err != nil {
+		return err
+	}
+	t.Partition.Parts = append(t.Partition.Parts, part)
+	return nil
+}
+
+//DropPartition 删除一个分区及其中的数据，哈希分区不能删除，分区必须是表的分区定义中的
+//postgres先从主表分离再删除分区表，oracle同时更新全局索引，否则主键索引会失效
+func (t *DBTable) DropPartition(name string) error {
+	if t.Partition == nil {
+		return fmt.Errorf("table %s not partitioned", t.Name())
+	}
+	if t.Partition.Type == PartitionHash {
+		return fmt.Errorf("can't drop hash partition %s", name)
+	}
+	parts := []*DBPartitionPart{}
+	var part *DBPartitionPart
+	for _, v := range t.Partition.Parts {
+		if strings.EqualFold(v.Name, name) {
+			part = v
+		} else {
+			parts = append(parts, v)
+		}
+	}
+	if part == nil {
+		return fmt.Errorf("table %s partition %s not exists", t.Name(), name)
+	}
+	strSqls := []string{}
+	switch t.Db.DriverName() {
+	case "postgres":
+		partName := part.Name
+		if len(t.Schema) > 0 {
+			partName = t.Schema + "." + partName
+		}
+		strSqls = append(strSqls,
+			fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s", t.quotedName(), t.quote(partName)),
+			fmt.Sprintf("DROP TABLE %s", t.quote(partName)))
+	case "oci8":
+		strSqls = append(strSqls, fmt.Sprintf("ALTER TABLE %s DROP PARTITION %s UPDATE GLOBAL INDEXES", t.quotedName(), t.quote(part.Name)))
+	case "mysql":
+		strSqls = append(strSqls, fmt.Sprintf("ALTER TABLE %s DROP PARTITION %s", t.quotedName(), t.quote(part.Name)))
+	default:
+		return fmt.Errorf("%s not support partition", t.Db.DriverName())
+	}
+	for _, v := range strSqls {
+		if err := execDDL(t.Db, v); err != nil {
+			return err
+		}
+	}
+	t.Partition.Parts = parts
+	return nil
+}
+
+//Partitions 返回表现有的分区，表没有分区则返回空
+func (t *DBTable) Partitions() ([]*DBPartitionPart, error) {
+	if err := t.FetchPartition(); err != nil {
+		return nil, err
+	}
+	if t.Partition == nil {
+		return nil, nil
+	}
+	return t.Partition.Parts, nil
+}
+
+var (
+	partitionQuoteReg = regexp.MustCompile(`'((?:[^']|'')*)'`)
+	pgPartKeyReg      = regexp.MustCompile(`(?i)^(\w+)\s*\((.*)\)$`)
+	pgPartBoundReg    = regexp.MustCompile(`(?is)\bTO\s*\((.*)\)$`)
+	pgPartInReg       = regexp.MustCompile(`(?is)\bIN\s*\((.*)\)$`)
+	scriptPartByReg   = regexp.MustCompile(`(?i)^partition\s+by\s+(range|list|hash)\s*\(([^)]*)\)\s*([0-9]+)?$`)
+	scriptPartReg     = regexp.MustCompile(`(?i)^partition\s+([\p{Han}_a-zA-Z0-9]+)(?:\s+values\s*\((.*)\))?$`)
+)
+
+//DefineScript中的分区定义，第一行是分区方式，后面每行一个分区，哈希分区没有分区名时是分区数量
+func (p *DBPartition) script() []string {
+	line := fmt.Sprintf("partition by %s(%s)", strings.ToLower(p.Type), strings.Join(p.Columns, ","))
+	if len(p.Parts) == 0 && p.Count > 0 {
+		line += fmt.Sprintf(" %d", p.Count)
+	}
+	rev := []string{line}
+	for _, v := range p.Parts {
+		line := "partition " + v.Name
+		if len(v.Values) > 0 {
+			list := []string{}
+			for _, value := range v.Values {
+				if strings.ToUpper(value) == "MAXVALUE" {
+					list = append(list, "maxvalue")
+				} else {
+					list = append(list, scriptQuote(value))
+				}
+			}
+			line += " values (" + strings.Join(list, ",") + ")"
+		}
+		rev = append(rev, line)
+	}
+	return rev
+}
+
+//解析DefineScript中的分区方式，list是scriptPartByReg的匹配结果
+func parseScriptPartition(list []string) *DBPartition {
+	p := &DBPartition{Type: strings.ToUpper(list[1])}
+	for _, v := range strings.Split(list[2], ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			p.Columns = append(p.Columns, v)
+		}
+	}
+	if len(list[3]) > 0 {
+		p.Count, _ = strconv.Atoi(list[3])
+	}
+	return p
+}
+
+//从数据库返回的分区值表达式中取出值，日期去掉零点的时间
+func parsePartitionValues(express string) []string {
+	express = strings.TrimSpace(express)
+	rev := []string{}
+	if ms := partitionQuoteReg.FindAllStringSubmatch(express, -1); len(ms) > 0 {
+		for _, m := range ms {
+			v := strings.TrimSpace(strings.Replace(m[1], "''", "'", -1))
+			rev = append(rev, strings.TrimSuffix(v, " 00:00:00"))
+		}
+		//oracle的to_date带有格式参数，只取第一个
+		if strings.HasPrefix(strings.ToUpper(express), "TO_DATE") {
+			rev = rev[:1]
+		}
+		return rev
+	}
+	for _, v := range strings.Split(express, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			rev = append(rev, strings.ToUpper(v))
+		}
+	}
+	return rev
+}
+
+//FetchPartition 获取表的分区定义，没有分区则Partition为空
+func (t *DBTable) FetchPartition() error {
+	t.Partition = nil
+	var schema string
+	switch t.Db.DriverName() {
+	case "postgres":
+		if len(t.Schema) > 0 {
+			schema = t.Schema
+		} else {
+			schema = safe.String(MustGetSqlFun(t.Db, "select current_schema()", nil))
+		}
+		strSql := fmt.Sprintf(`select pg_get_partkeydef(c.oid) from pg_class c join pg_namespace n on n.oid=c.relnamespace
+				where c.relkind='p' and n.nspname='%s' and c.relname='%s'`, storedName("postgres", schema), storedName("postgres", t.TableName))
+		def, err := GetSqlFun(t.Db, strSql, nil)
+		if err != nil {
+			return err
+		}
+		m := pgPartKeyReg.FindStringSubmatch(safe.String(def))
+		if m == nil {
+			return nil
+		}
+		p := &DBPartition{Type: strings.ToUpper(m[1])}
+		for _, v := range strings.Split(m[2], ",") {
+			p.Columns = append(p.Columns, strings.ToUpper(strings.TrimSpace(v)))
+		}
+		strSql = fmt.Sprintf(`select upper(c.relname) as "NAME",pg_get_expr(c.relpartbound,c.oid) as "BOUND"
+				from pg_inherits i join pg_class c on c.oid=i.inhrelid
+					join pg_class pc on pc.oid=i.inhparent join pg_namespace n on n.oid=pc.relnamespace
+				where n.nspname='%s' and pc.relname='%s'
+				order by c.relname`, storedName("postgres", schema), storedName("postgres", t.TableName))
+		rows, _, err := QueryRecord(t.Db, strSql, nil)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			part := &DBPartitionPart{Name: safe.String(row["NAME"])}
+			bound := safe.String(row["BOUND"])
+			if m := pgPartBoundReg.FindStringSubmatch(bound); m != nil {
+				part.Values = parsePartitionValues(m[1])
+			} else if m := pgPartInReg.FindStringSubmatch(bound); m != nil {
+				part.Values = parsePartitionValues(m[1])
+			}
+			p.Parts = append(p.Parts, part)
+		}
+		if p.Type == PartitionHash {
+			p.Count = len(p.Parts)
+		} else if p.Type == PartitionRange {
+			//按上限排序，分区名的顺序不一定是范围的顺序
+			sortPartsByValue(p.Parts)
+		}
+		t.Partition = p
+	case "oci8":
+		if len(t.Schema) > 0 {
+			schema = t.Schema
+		} else {
+			schema = safe.String(MustGetSqlFun(t.Db, "select user from dual", nil))
+		}
+		strSql := fmt.Sprintf(`select partitioning_type from all_part_tables where owner='%s' and table_name='%s'`, schema, t.TableName)
+		typ, err := GetSqlFun(t.Db, strSql, nil)
+		if err != nil {
+			return err
+		}
+		if typ == nil {
+			return nil
+		}
+		p := &DBPartition{Type: safe.String(typ)}
+		strSql = fmt.Sprintf(`select column_name from all_part_key_columns
+				where owner='%s' and name='%s' and object_type='TABLE' order by column_position`, schema, t.TableName)
+		if err := t.Db.Select(&p.Columns, strSql); err != nil {
+			return SqlError{strSql, nil, err}
+		}
+		//high_value是long类型，由驱动转换成文本
+		strSql = fmt.Sprintf(`select partition_name as "NAME",high_value as "BOUND" from all_tab_partitions
+				where table_owner='%s' and table_name='%s' order by partition_position`, schema, t.TableName)
+		rows, _, err := QueryRecord(t.Db, strSql, nil)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			part := &DBPartitionPart{Name: safe.String(row["NAME"])}
+			if p.Type != PartitionHash {
+				part.Values = parsePartitionValues(safe.String(row["BOUND"]))
+			}
+			p.Parts = append(p.Parts, part)
+		}
+		if p.Type == PartitionHash {
+			p.Count = len(p.Parts)
+		}
+		t.Partition = p
+	case "mysql":
+		if len(t.Schema) > 0 {
+			schema = t.Schema
+		} else {
+			schema = safe.String(MustGetSqlFun(t.Db, "select upper(SCHEMA())", nil))
+		}
+		strSql := fmt.Sprintf(`select upper(partition_name) as NAME,partition_method as METHOD,
+					partition_expression as EXPRESS,partition_description as BOUND
+				from information_schema.partitions
+				where upper(table_schema)='%s' and upper(table_name)='%s' and partition_name is not null
+				order by partition_ordinal_position`, schema, t.TableName)
+		rows, _, err := QueryRecord(t.Db, strSql, nil)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		p := &DBPartition{}
+		switch method := strings.ToUpper(safe.String(rows[0]["METHOD"])); {
+		case strings.HasPrefix(method, "RANGE"):
+			p.Type = PartitionRange
+		case strings.HasPrefix(method, "LIST"):
+			p.Type = PartitionList
+		default:
+			p.Type = PartitionHash
+		}
+		for _, v := range strings.Split(safe.String(rows[0]["EXPRESS"]), ",") {
+			p.Columns = append(p.Columns, strings.ToUpper(strings.Trim(strings.TrimSpace(v), "`")))
+		}
+		for _, row := range rows {
+			part := &DBPartitionPart{Name: safe.String(row["NAME"])}
+			if p.Type != PartitionHash {
+				part.Values = parsePartitionValues(safe.String(row["BOUND"]))
+			}
+			p.Parts = append(p.Parts, part)
+		}
+		if p.Type == PartitionHash {
+			p.Count = len(p.Parts)
+		}
+		t.Partition = p
+	case "sqlite3":
+		return nil
+	default:
+		return fmt.Errorf("not impl," + t.Db.DriverName())
+	}
+	return nil
+}
+
+//范围分区按上限排序，MAXVALUE在最后，数值按大小，日期和文本按字符串排序
+func sortPartsByValue(parts []*DBPartitionPart) {
+	less := func(a, b *DBPartitionPart) bool {
+		if len(b.Values) == 0 || b.Values[0] == "MAXVALUE" {
+			return len(a.Values) > 0 && a.Values[0] != "MAXVALUE"
+		}
+		if len(a.Values) == 0 || a.Values[0] == "MAXVALUE" {
+			return false
+		}
+		fa, errA := strconv.ParseFloat(a.Values[0], 64)
+		fb, errB := strconv.ParseFloat(b.Values[0], 64)
+		if errA == nil && errB == nil {
+			return fa < fb
+		}
+		return a.Values[0] < b.Values[0]
+	}
+	sort.SliceStable(parts, func(i, j int) bool {
+		return less(parts[i], parts[j])
+	})
+}
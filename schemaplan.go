@@ -0,0 +1,373 @@
+package dbx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//调整语句的分类
+const (
+	StepSafe        = "safe"        //不会丢失数据
+	StepNarrowing   = "narrowing"   //可能丢失部分数据，例如缩短文本长度、类型转换
+	StepDestructive = "destructive" //会丢失数据，例如删除字段、修改主键
+)
+
+//SchemaStep 结构调整计划中的一个语句，Reason是可读的调整原因，Kind是语句的分类
+//oracle、mysql的DDL不能回滚，执行失败时按倒序执行已执行语句的Undo，再倒序执行Restore恢复数据
+type SchemaStep struct {
+	Sql          string
+	Reason       string
+	Kind         string
+	Undo         []string //撤销本语句的补偿语句，为空则不需要撤销
+	Restore      []string //所有语句撤销后再执行的数据恢复语句，例如从备份表恢复删除的字段
+	Irreversible bool     //不能撤销，例如没有主键的表删除字段
+	Check        bool     //检查语句，查询有返回行即出错，例如sqlite3的外键检查
+}
+
+func (s *SchemaStep) undo(strSql ...string) *SchemaStep {
+	s.Undo = append(s.Undo, strSql...)
+	return s
+}
+func (s *SchemaStep) restore(strSql ...string) *SchemaStep {
+	s.Restore = append(s.Restore, strSql...)
+	return s
+}
+func (s *SchemaStep) irreversible() *SchemaStep {
+	s.Irreversible = true
+	return s
+}
+func (s *SchemaStep) narrowing() *SchemaStep {
+	if s.Kind == StepSafe {
+		s.Kind = StepNarrowing
+	}
+	return s
+}
+func (s *SchemaStep) destructive() *SchemaStep {
+	s.Kind = StepDestructive
+	return s
+}
+func (s *SchemaStep) check() *SchemaStep {
+	s.Check = true
+	return s
+}
+
+//SchemaPolicy 结构调整的安全策略，为空时只拒绝破坏性的调整，零值时拒绝破坏性和缩小范围的调整，不归档
+type SchemaPolicy struct {
+	AllowDestructive bool //允许删除字段、修改主键，同时也允许缩小范围的调整
+	AllowNarrowing   bool //允许缩短文本长度、类型转换等可能丢失部分数据的调整
+	Archive          bool //删除字段前，把字段的数据按主键归档到DBX_ARC_开头的表中，归档表不会自动删除
+}
+
+//检查计划是否符合策略
+func (p *SchemaPolicy) check(plan *SchemaPlan) error {
+	if p != nil && p.AllowDestructive {
+		return nil
+	}
+	reasons := []string{}
+	for _, v := range plan.StepsOf(StepDestructive) {
+		reasons = append(reasons, v.Reason)
+	}
+	if len(reasons) > 0 {
+		return fmt.Errorf("table %s destructive change not allowed:\n%s", plan.Table, strings.Join(reasons, "\n"))
+	}
+	if p == nil || p.AllowNarrowing {
+		return nil
+	}
+	for _, v := range plan.StepsOf(StepNarrowing) {
+		reasons = append(reasons, v.Reason)
+	}
+	if len(reasons) > 0 {
+		return fmt.Errorf("table %s narrowing change not allowed:\n%s", plan.Table, strings.Join(reasons, "\n"))
+	}
+	return nil
+}
+
+//ConvertFailure 字段类型转换时，原有数据不能转换的行，Keys是行的主键值，
+//转换表达式执行出错时Keys为空，Value是错误信息
+type ConvertFailure struct {
+	Table  string
+	Column string
+	Keys   map[string]interface{}
+	Value  string
+}
+
+//检查转换时，每个字段最多记录的不能转换的行数
+const convertSampleRows = 100
+
+//SchemaPlan 结构调整的计划，生成时不执行任何语句，可以导出成脚本审核后再用Apply执行
+//有数据不能转换时，需要先修正数据或者给字段指定转换表达式，重新生成计划，
+//FailedRows是不能转换的总行数，Failures中每个字段只记录前convertSampleRows行
+//Transaction为true的计划需要在一个事务中执行，postgres、sqlite3的DDL可以回滚，都在事务中执行
+//Prepare、Finish是需要在事务外执行的语句，例如sqlite3重建表时关闭、打开外键，和事务使用同一个连接
+type SchemaPlan struct {
+	Driver      string
+	Table       string
+	Steps       []*SchemaStep
+	Failures    []*ConvertFailure
+	FailedRows  int64
+	Transaction bool
+	Prepare     []string //事务开始前执行
+	Finish      []string //事务结束后执行，不论成功与否
+}
+
+func (p *SchemaPlan) add(strSql, reason string) *SchemaStep {
+	rev := &SchemaStep{Sql: strSql, Reason: reason, Kind: StepSafe}
+	p.Steps = append(p.Steps, rev)
+	return rev
+}
+
+//StepsOf 返回指定分类的语句
+func (p *SchemaPlan) StepsOf(kind string) []*SchemaStep {
+	rev := []*SchemaStep{}
+	for _, v := range p.Steps {
+		if v.Kind == kind {
+			rev = append(rev, v)
+		}
+	}
+	return rev
+}
+
+//IsEmpty 返回是否没有需要执行的语句
+func (p *SchemaPlan) IsEmpty() bool {
+	return len(p.Steps) == 0
+}
+
+//Script 导出成对应数据库的sql脚本，每个语句前用注释说明原因，不安全的语句标上分类
+//oracle的语句用单独一行的/结束，以便sqlplus执行
+func (p *SchemaPlan) Script() string {
+	out := bytes.NewBuffer(nil)
+	fmt.Fprintf(out, "-- table:%s driver:%s\n", p.Table, p.Driver)
+	if p.FailedRows > 0 {
+		fmt.Fprintf(out, "-- %d rows can't convert\n", p.FailedRows)
+	}
+	for _, v := range p.Failures {
+		fmt.Fprintf(out, "-- can't convert %s.%s %v value:%q\n", v.Table, v.Column, v.Keys, v.Value)
+	}
+	for _, v := range p.Prepare {
+		fmt.Fprintf(out, "\n%s;\n", v)
+	}
+	if p.Transaction {
+		out.WriteString("\nBEGIN;\n")
+	}
+	for _, v := range p.Steps {
+		if v.Kind != StepSafe {
+			fmt.Fprintf(out, "\n-- [%s] %s\n", v.Kind, v.Reason)
+		} else {
+			fmt.Fprintf(out, "\n-- %s\n", v.Reason)
+		}
+		if p.Driver == "oci8" {
+			fmt.Fprintf(out, "%s\n/\n", v.Sql)
+		} else {
+			fmt.Fprintf(out, "%s;\n", v.Sql)
+		}
+	}
+	if p.Transaction {
+		out.WriteString("\nCOMMIT;\n")
+	}
+	for _, v := range p.Finish {
+		fmt.Fprintf(out, "\n%s;\n", v)
+	}
+	return out.String()
+}
+
+//SaveScript 将脚本保存到文件，一般是.sql文件
+func (p *SchemaPlan) SaveScript(fileName string) error {
+	return ioutil.WriteFile(fileName, []byte(p.Script()), 0644)
+}
+
+//SchemaApplyError 计划执行失败的错误，说明出错的语句以及表最终的状态
+type SchemaApplyError struct {
+	Table      string
+	Failed     *SchemaStep   //出错的语句
+	Err        error         //出错的原因
+	Executed   []*SchemaStep //出错前已经执行的语句
+	RolledBack bool          //事务已经回滚，表没有变化
+	InTx       bool          //在调用者的事务中执行，需要调用者回滚
+	Undone     []*SchemaStep //已经撤销的语句，按撤销的顺序
+	UndoFailed *SchemaStep   //撤销出错或者不能撤销的语句
+	UndoErr    error
+}
+
+//State 表最终的状态
+func (e *SchemaApplyError) State() string {
+	switch {
+	case e.RolledBack:
+		return "transaction rolled back, table unchanged"
+	case e.InTx:
+		return "executed in caller's transaction, rollback it to restore the table"
+	case len(e.Executed) == 0:
+		return "nothing executed, table unchanged"
+	case e.UndoFailed == nil:
+		return fmt.Sprintf("%d steps undone, table restored", len(e.Undone))
+	}
+	out := bytes.NewBuffer(nil)
+	if e.UndoErr != nil {
+		fmt.Fprintf(out, "undo %q error:%s", e.UndoFailed.Reason, e.UndoErr)
+	} else {
+		fmt.Fprintf(out, "step %q can't undo", e.UndoFailed.Reason)
+	}
+	out.WriteString(", table left with steps applied:")
+	undone := map[*SchemaStep]bool{}
+	for _, v := range e.Undone {
+		undone[v] = true
+	}
+	for _, v := range e.Executed {
+		if !undone[v] {
+			fmt.Fprintf(out, "\n  %s", v.Sql)
+		}
+	}
+	return out.String()
+}
+
+func (e *SchemaApplyError) Error() string {
+	return fmt.Sprintf("table %s update error at %q:%s\n%s", e.Table, e.Failed.Reason, e.Err, e.State())
+}
+
+//Apply 按顺序执行计划中的语句，出错即停止，数据库类型必须和生成计划时一致
+//需要事务的计划，如果db不是事务，则自动开启事务，出错时回滚
+//不能在事务中执行的，出错时倒序执行补偿语句，返回的错误是*SchemaApplyError，说明表最终的状态
+func (p *SchemaPlan) Apply(db DB) error {
+	if db.DriverName() != p.Driver {
+		return fmt.Errorf("plan driver %s not match %s", p.Driver, db.DriverName())
+	}
+	if len(p.Failures) > 0 {
+		v := p.Failures[0]
+		return fmt.Errorf("%d rows can't convert, first is %s.%s %v value:%q", p.FailedRows, v.Table, v.Column, v.Keys, v.Value)
+	}
+	var applyErr *SchemaApplyError
+	exec := func(db DB) error {
+		if applyErr = p.exec(db); applyErr != nil {
+			return applyErr
+		}
+		return nil
+	}
+	if p.Transaction {
+		if sdb, ok := db.(*sqlx.DB); ok {
+			if len(p.Prepare) > 0 || len(p.Finish) > 0 {
+				return p.applyAtConn(sdb, exec, func() *SchemaApplyError { return applyErr })
+			}
+			if err := RunAtTx(sdb, exec); err != nil {
+				if applyErr == nil {
+					return err
+				}
+				applyErr.RolledBack = true
+				return applyErr
+			}
+			return nil
+		}
+		if len(p.Prepare) > 0 || len(p.Finish) > 0 {
+			return fmt.Errorf("plan of table %s can't apply in caller's transaction", p.Table)
+		}
+		if err := exec(db); err != nil {
+			applyErr.InTx = true
+			return applyErr
+		}
+		return nil
+	}
+	if err := exec(db); err != nil {
+		p.compensate(db, applyErr)
+		return applyErr
+	}
+	return nil
+}
+
+//在一个单独的连接上执行Prepare、事务以及Finish，连接池中其他连接的设置不受影响
+func (p *SchemaPlan) applyAtConn(db *sqlx.DB, exec func(DB) error, applyErr func() *SchemaApplyError) (err error) {
+	ctx := context.Background()
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer func() {
+		for _, v := range p.Finish {
+			if _, e := conn.ExecContext(ctx, v); e != nil && err == nil {
+				err = SqlError{v, nil, e}
+			}
+		}
+	}()
+	for _, v := range p.Prepare {
+		if _, err = conn.ExecContext(ctx, v); err != nil {
+			return SqlError{v, nil, err}
+		}
+	}
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = exec(tx); err != nil {
+		tx.Rollback()
+		if e := applyErr(); e != nil {
+			e.RolledBack = true
+			return e
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+//执行检查语句，有返回行则出错
+func execCheck(db DB, strSql string) error {
+	rows, err := db.Queryx(strSql)
+	if err != nil {
+		return SqlError{strSql, nil, err}
+	}
+	defer rows.Close()
+	if rows.Next() {
+		return fmt.Errorf("check %s failed", strSql)
+	}
+	return rows.Err()
+}
+
+//依次执行所有的语句
+func (p *SchemaPlan) exec(db DB) *SchemaApplyError {
+	for i, v := range p.Steps {
+		exec := execDDL
+		if v.Check {
+			exec = execCheck
+		}
+		if err := exec(db, v.Sql); err != nil {
+			return &SchemaApplyError{
+				Table:    p.Table,
+				Failed:   v,
+				Err:      err,
+				Executed: p.Steps[:i],
+			}
+		}
+	}
+	return nil
+}
+
+//倒序撤销已经执行的语句，遇到不能撤销的语句即停止，全部撤销后再倒序恢复数据
+func (p *SchemaPlan) compensate(db DB, e *SchemaApplyError) {
+	for i := len(e.Executed) - 1; i >= 0; i-- {
+		v := e.Executed[i]
+		if v.Irreversible {
+			e.UndoFailed = v
+			return
+		}
+		for _, strSql := range v.Undo {
+			if err := execDDL(db, strSql); err != nil {
+				e.UndoFailed = v
+				e.UndoErr = err
+				return
+			}
+		}
+		e.Undone = append(e.Undone, v)
+	}
+	for i := len(e.Executed) - 1; i >= 0; i-- {
+		v := e.Executed[i]
+		for _, strSql := range v.Restore {
+			if err := execDDL(db, strSql); err != nil {
+				e.UndoFailed = v
+				e.UndoErr = err
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package dbx
+
+import "testing"
+
+func TestSchemaPolicyCheck(t *testing.T) {
+	plan := &SchemaPlan{Table: "T", Steps: []*SchemaStep{
+		{Sql: "alter table T alter column A type varchar(10)", Kind: StepNarrowing},
+	}}
+	//为空时只拒绝破坏性的调整
+	var nilPolicy *SchemaPolicy
+	if err := nilPolicy.check(plan); err != nil {
+		t.Error(err)
+	}
+	if err := (&SchemaPolicy{}).check(plan); err == nil {
+		t.Error("zero policy must refuse narrowing")
+	}
+	if err := (&SchemaPolicy{AllowNarrowing: true}).check(plan); err != nil {
+		t.Error(err)
+	}
+	plan.Steps = append(plan.Steps, &SchemaStep{Sql: "alter table T drop column B", Kind: StepDestructive})
+	if err := nilPolicy.check(plan); err == nil {
+		t.Error("nil policy must refuse destructive")
+	}
+	if err := (&SchemaPolicy{AllowDestructive: true}).check(plan); err != nil {
+		t.Error(err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"dbweb/lib/safe"
 	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/shopspring/decimal"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -23,6 +25,7 @@ type ConditionLine struct {
 	Value         string
 	RightBrackets string
 	Logic         string
+	JsonPath      string //如果是json字段，可以指定路径如$.customer.id，对路径上的值进行判断
 }
 
 //模板条件
@@ -72,6 +75,19 @@ func StringToFieldValue(str string, dataType int) (val interface{}) {
 		if val, err = strconv.ParseInt(str, 10, 64); err != nil {
 			log.Panic(err)
 		}
+	case TypeDecimal:
+		if val, err = decimal.NewFromString(str); err != nil {
+			log.Panic(err)
+		}
+	case TypeBool:
+		//用户输入的条件值可能不是布尔，作为空值
+		if b, err := strconv.ParseBool(str); err == nil {
+			val = b
+		}
+	case TypeJson:
+		if err = json.Unmarshal([]byte(str), &val); err != nil {
+			log.Panic(err)
+		}
 
 	case TypeString:
 		val = str
@@ -81,85 +97,139 @@ func StringToFieldValue(str string, dataType int) (val interface{}) {
 	return
 }
 
+//布尔字段的条件，包含、前缀、后缀按等于处理，值不能转换成布尔的，等于条件不成立，不等于条件总是成立，
+//其他的运算符返回false，按通常的方式处理
+func (c *ConditionLine) boolExpress(db DB, colName string) (string, bool) {
+	switch c.Operators {
+	case "=", "?", "?>", "<?":
+		if _, err := strconv.ParseBool(c.Value); err != nil {
+			return "1=2", true
+		}
+		return fmt.Sprintf("%s = %s", colName, ValueExpress(db, TypeBool, c.Value)), true
+	case "!=", "!?", "!?>", "!<?":
+		if _, err := strconv.ParseBool(c.Value); err != nil {
+			return "1=1", true
+		}
+		return fmt.Sprintf("(%s <> %s or %[1]s is null)", colName, ValueExpress(db, TypeBool, c.Value)), true
+	case "in", "!in":
+		array, err := csv.NewReader(strings.NewReader(c.Value)).Read()
+		if err != nil {
+			log.Panic(err)
+		}
+		list := []string{}
+		for _, v := range array {
+			if _, err := strconv.ParseBool(v); err == nil {
+				list = append(list, ValueExpress(db, TypeBool, v))
+			}
+		}
+		switch {
+		case len(list) > 0 && c.Operators == "in":
+			return fmt.Sprintf("%s in (%s)", colName, strings.Join(list, ",\n")), true
+		case len(list) > 0:
+			return fmt.Sprintf("%s not in (%s)", colName, strings.Join(list, ",\n")), true
+		case c.Operators == "in":
+			return "1=2", true
+		default:
+			return fmt.Sprintf("%s is not null", colName), true
+		}
+	case ">", ">=", "<", "<=", "~", "!~":
+		if _, err := strconv.ParseBool(c.Value); err != nil {
+			return "1=2", true
+		}
+	}
+	return "", false
+}
+
 func (c *ConditionLine) GetExpress(db DB, dataType int) (strSql string) {
+	colName := QuoteName(db.DriverName(), c.ColumnName)
+	//json路径取出的值统一作为字符串比较
+	if len(c.JsonPath) > 0 {
+		colName = JsonPathExpress(db, colName, c.JsonPath)
+		dataType = TypeString
+	}
+	if dataType == TypeBool && c.Value != "" {
+		if strSql, ok := c.boolExpress(db, colName); ok {
+			return strSql
+		}
+	}
 	//需要考虑到null的情况
 	switch c.Operators {
 	case "=": //等于
 		if c.Value == "" {
-			strSql = fmt.Sprintf("%s is null", c.ColumnName)
+			strSql = fmt.Sprintf("%s is null", colName)
 		} else {
-			strSql = fmt.Sprintf("%s = %s", c.ColumnName, ValueExpress(db, dataType, c.Value))
+			strSql = fmt.Sprintf("%s = %s", colName, ValueExpress(db, dataType, c.Value))
 		}
 	case "!=": //不等于
 		if c.Value == "" {
-			strSql = fmt.Sprintf("%s is not null", c.ColumnName)
+			strSql = fmt.Sprintf("%s is not null", colName)
 		} else {
-			strSql = fmt.Sprintf("(%s <> %s or %[1]s is null)", c.ColumnName, ValueExpress(db, dataType, c.Value))
+			strSql = fmt.Sprintf("(%s <> %s or %[1]s is null)", colName, ValueExpress(db, dataType, c.Value))
 		}
 	case ">": //大于
 		if c.Value == "" {
-			strSql = fmt.Sprintf("%s is not null", c.ColumnName)
+			strSql = fmt.Sprintf("%s is not null", colName)
 		} else {
-			strSql = fmt.Sprintf("%s > %s", c.ColumnName, ValueExpress(db, dataType, c.Value))
+			strSql = fmt.Sprintf("%s > %s", colName, ValueExpress(db, dataType, c.Value))
 		}
 	case ">=": //大于等于
 		if c.Value == "" {
 			strSql = "1=1"
 		} else {
-			strSql = fmt.Sprintf("%s >= %s", c.ColumnName, ValueExpress(db, dataType, c.Value))
+			strSql = fmt.Sprintf("%s >= %s", colName, ValueExpress(db, dataType, c.Value))
 		}
 	case "<": //小于
 		if c.Value == "" {
 			strSql = "1=2"
 		} else {
-			strSql = fmt.Sprintf("(%s < %s or %[1]s is null)", c.ColumnName, ValueExpress(db, dataType, c.Value))
+			strSql = fmt.Sprintf("(%s < %s or %[1]s is null)", colName, ValueExpress(db, dataType, c.Value))
 		}
 	case "<=": //小于等于
 		if c.Value == "" {
-			strSql = fmt.Sprintf("%s is null", c.ColumnName)
+			strSql = fmt.Sprintf("%s is null", colName)
 
 		} else {
-			strSql = fmt.Sprintf("(%s <= %s or %[1]s is null)", c.ColumnName, ValueExpress(db, dataType, c.Value))
+			strSql = fmt.Sprintf("(%s <= %s or %[1]s is null)", colName, ValueExpress(db, dataType, c.Value))
 		}
 	case "?": //包含
 		if c.Value == "" {
-			strSql = fmt.Sprintf("%s is null", c.ColumnName)
+			strSql = fmt.Sprintf("%s is null", colName)
 		} else {
-			strSql = fmt.Sprintf("%s like %s", c.ColumnName, ValueExpress(db, dataType, "%"+c.Value+"%"))
+			strSql = fmt.Sprintf("%s like %s", colName, ValueExpress(db, dataType, "%"+c.Value+"%"))
 		}
 	case "!?": //不包含
 		if c.Value == "" {
-			strSql = fmt.Sprintf("%s is not null", c.ColumnName)
+			strSql = fmt.Sprintf("%s is not null", colName)
 		} else {
-			strSql = fmt.Sprintf("%s not like %s", c.ColumnName, ValueExpress(db, dataType, "%"+c.Value+"%"))
+			strSql = fmt.Sprintf("%s not like %s", colName, ValueExpress(db, dataType, "%"+c.Value+"%"))
 		}
 	case "?>": //前缀
 		if c.Value == "" {
-			strSql = fmt.Sprintf("%s is null", c.ColumnName)
+			strSql = fmt.Sprintf("%s is null", colName)
 		} else {
-			strSql = fmt.Sprintf("%s like %s", c.ColumnName, ValueExpress(db, dataType, c.Value+"%"))
+			strSql = fmt.Sprintf("%s like %s", colName, ValueExpress(db, dataType, c.Value+"%"))
 		}
 	case "!?>": //非前缀
 		if c.Value == "" {
-			strSql = fmt.Sprintf("%s is not null", c.ColumnName)
+			strSql = fmt.Sprintf("%s is not null", colName)
 		} else {
-			strSql = fmt.Sprintf("%s not like %s", c.ColumnName, ValueExpress(db, dataType, c.Value+"%"))
+			strSql = fmt.Sprintf("%s not like %s", colName, ValueExpress(db, dataType, c.Value+"%"))
 		}
 	case "<?": //后缀
 		if c.Value == "" {
-			strSql = fmt.Sprintf("%s is null", c.ColumnName)
+			strSql = fmt.Sprintf("%s is null", colName)
 		} else {
-			strSql = fmt.Sprintf("%s like %s", c.ColumnName, ValueExpress(db, dataType, "%"+c.Value))
+			strSql = fmt.Sprintf("%s like %s", colName, ValueExpress(db, dataType, "%"+c.Value))
 		}
 	case "!<?": //非后缀
 		if c.Value == "" {
-			strSql = fmt.Sprintf("%s is not null", c.ColumnName)
+			strSql = fmt.Sprintf("%s is not null", colName)
 		} else {
-			strSql = fmt.Sprintf("%s not like %s", c.ColumnName, ValueExpress(db, dataType, "%"+c.Value))
+			strSql = fmt.Sprintf("%s not like %s", colName, ValueExpress(db, dataType, "%"+c.Value))
 		}
 	case "in": //在列表
 		if c.Value == "" {
-			strSql = fmt.Sprintf("%s is null", c.ColumnName)
+			strSql = fmt.Sprintf("%s is null", colName)
 		} else {
 			//在列表简化起见，不再类型化
 			if array, err := csv.NewReader(strings.NewReader(c.Value)).Read(); err != nil {
@@ -169,13 +239,13 @@ func (c *ConditionLine) GetExpress(db DB, dataType int) (strSql string) {
 				for _, v := range array {
 					list = append(list, ValueExpress(db, dataType, v))
 				}
-				strSql = fmt.Sprintf("%s in (%s)", c.ColumnName, strings.Join(list, ",\n"))
+				strSql = fmt.Sprintf("%s in (%s)", colName, strings.Join(list, ",\n"))
 			}
 
 		}
 	case "!in": //不在列表
 		if c.Value == "" {
-			strSql = fmt.Sprintf("%s is not null", c.ColumnName)
+			strSql = fmt.Sprintf("%s is not null", colName)
 		} else {
 			//在列表简化起见，不再类型化
 			if array, err := csv.NewReader(strings.NewReader(c.Value)).Read(); err != nil {
@@ -185,67 +255,67 @@ func (c *ConditionLine) GetExpress(db DB, dataType int) (strSql string) {
 				for _, v := range array {
 					list = append(list, ValueExpress(db, dataType, v))
 				}
-				strSql = fmt.Sprintf("%s not in (%s)", c.ColumnName, strings.Join(list, ",\n"))
+				strSql = fmt.Sprintf("%s not in (%s)", colName, strings.Join(list, ",\n"))
 			}
 		}
 	case "~": //正则
 		if c.Value == "" {
-			strSql = fmt.Sprintf("%s is null", c.ColumnName)
+			strSql = fmt.Sprintf("%s is null", colName)
 		} else {
 			switch db.DriverName() {
 			case "oci8":
-				strSql = fmt.Sprintf("regexp_like(%s,%s)", c.ColumnName, ValueExpress(db, dataType, c.Value))
+				strSql = fmt.Sprintf("regexp_like(%s,%s)", colName, ValueExpress(db, dataType, c.Value))
 			case "postgres":
-				strSql = fmt.Sprintf("%s ~ %s", c.ColumnName, ValueExpress(db, dataType, c.Value))
+				strSql = fmt.Sprintf("%s ~ %s", colName, ValueExpress(db, dataType, c.Value))
 			case "mysql":
-				strSql = fmt.Sprintf("%s REGEXP %s", c.ColumnName, ValueExpress(db, dataType, c.Value))
+				strSql = fmt.Sprintf("%s REGEXP %s", colName, ValueExpress(db, dataType, c.Value))
 			default:
 				log.Panic("not impl GetExpress")
 			}
 		}
 	case "!~": //非正则
 		if c.Value == "" {
-			strSql = fmt.Sprintf("%s is not null", c.ColumnName)
+			strSql = fmt.Sprintf("%s is not null", colName)
 		} else {
 			switch db.DriverName() {
 			case "oci8":
-				strSql = fmt.Sprintf("not regexp_like(%s,%s)", c.ColumnName, ValueExpress(db, dataType, c.Value))
+				strSql = fmt.Sprintf("not regexp_like(%s,%s)", colName, ValueExpress(db, dataType, c.Value))
 			case "postgres":
-				strSql = fmt.Sprintf("%s !~ %s", c.ColumnName, ValueExpress(db, dataType, c.Value))
+				strSql = fmt.Sprintf("%s !~ %s", colName, ValueExpress(db, dataType, c.Value))
 			case "mysql":
-				strSql = fmt.Sprintf("%s not REGEXP %s", c.ColumnName, ValueExpress(db, dataType, c.Value))
+				strSql = fmt.Sprintf("%s not REGEXP %s", colName, ValueExpress(db, dataType, c.Value))
 			default:
 				log.Panic("not impl GetExpress")
 			}
 		}
 	case "e": //为空
-		strSql = fmt.Sprintf("%s is null", c.ColumnName)
+		strSql = fmt.Sprintf("%s is null", colName)
 	case "!e": //不为空
-		strSql = fmt.Sprintf("%s is not null", c.ColumnName)
+		strSql = fmt.Sprintf("%s is not null", colName)
 	case "_": //长度等于
 		switch db.DriverName() {
 		case "oci8", "postgres":
-			strSql = fmt.Sprintf("length(%s) = %s", c.ColumnName, c.Value)
+			strSql = fmt.Sprintf("length(%s) = %s", colName, c.Value)
 		case "mysql":
-			strSql = fmt.Sprintf("char_length(%s) = %s", c.ColumnName, c.Value)
+			strSql = fmt.Sprintf("char_length(%s) = %s", colName, c.Value)
 		default:
 			log.Panic("not impl GetExpress")
 		}
 	case "!_": //长度不等于
 		switch db.DriverName() {
 		case "oci8", "postgres":
-			strSql = fmt.Sprintf("length(%s) <> %s", c.ColumnName, c.Value)
+			strSql = fmt.Sprintf("length(%s) <> %s", colName, c.Value)
 		case "mysql":
-			strSql = fmt.Sprintf("char_length(%s) <> %s", c.ColumnName, c.Value)
+			strSql = fmt.Sprintf("char_length(%s) <> %s", colName, c.Value)
 		default:
 			log.Panic("not impl GetExpress")
 		}
 	case "_>": //长度大于
 		switch db.DriverName() {
 		case "oci8", "postgres":
-			strSql = fmt.Sprintf("length(%s) > %s", c.ColumnName, c.Value)
+			strSql = fmt.Sprintf("length(%s) > %s", colName, c.Value)
 		case "mysql":
-			strSql = fmt.Sprintf("char_length(%s) > %s", c.ColumnName, c.Value)
+			strSql = fmt.Sprintf("char_length(%s) > %s", colName, c.Value)
 		default:
 			log.Panic("not impl GetExpress")
 		}
@@ -253,9 +323,9 @@ func (c *ConditionLine) GetExpress(db DB, dataType int) (strSql string) {
 	case "_<": //长度小于
 		switch db.DriverName() {
 		case "oci8", "postgres":
-			strSql = fmt.Sprintf("length(%s) < %s", c.ColumnName, c.Value)
+			strSql = fmt.Sprintf("length(%s) < %s", colName, c.Value)
 		case "mysql":
-			strSql = fmt.Sprintf("char_length(%s) < %s", c.ColumnName, c.Value)
+			strSql = fmt.Sprintf("char_length(%s) < %s", colName, c.Value)
 		default:
 			log.Panic("not impl GetExpress")
 		}
@@ -266,6 +336,36 @@ func (c *ConditionLine) GetExpress(db DB, dataType int) (strSql string) {
 	strSql = fmt.Sprintf("%s%s%s", c.LeftBrackets, strSql, c.RightBrackets)
 	return
 }
+
+//返回取出json字段中指定路径值（文本）的表达式，路径格式如$.customer.id、$.items[0].name
+func JsonPathExpress(db DB, colName, path string) string {
+	switch db.DriverName() {
+	case "postgres":
+		return fmt.Sprintf("%s #>> %s", colName, safe.SignString("{"+strings.Join(jsonPathKeys(path), ",")+"}"))
+	case "oci8":
+		return fmt.Sprintf("JSON_VALUE(%s,%s)", colName, safe.SignString(path))
+	case "mysql":
+		return fmt.Sprintf("JSON_UNQUOTE(JSON_EXTRACT(%s,%s))", colName, safe.SignString(path))
+	case "sqlite3":
+		//json_extract返回的是sql值，数字不能和字符串比较，转换成文本
+		return fmt.Sprintf("cast(json_extract(%s,%s) as text)", colName, safe.SignString(path))
+	default:
+		log.Panic("not impl JsonPathExpress")
+		return ""
+	}
+}
+
+//将$.items[0].name这样的路径拆分成items,0,name
+func jsonPathKeys(path string) []string {
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$")
+	keys := []string{}
+	for _, v := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '.' || r == '[' || r == ']'
+	}) {
+		keys = append(keys, strings.Trim(v, `"`))
+	}
+	return keys
+}
 func (c *SqlCondition) BuildWhere(db DB, table *DBTable) string {
 	strLines := []string{}
 
@@ -420,16 +520,16 @@ func (s *SqlSelect) BuildSql(db DB) (strSql string) {
 			if strings.HasPrefix(v, "-") {
 				if db.DriverName() == "mysql" ||
 					db.DriverName() == "sqlite3" {
-					orderList = append(orderList, v[1:]+" DESC")
+					orderList = append(orderList, QuoteName(db.DriverName(), v[1:])+" DESC")
 				} else {
-					orderList = append(orderList, v[1:]+" DESC NULLS LAST")
+					orderList = append(orderList, QuoteName(db.DriverName(), v[1:])+" DESC NULLS LAST")
 				}
 			} else {
 				if db.DriverName() == "mysql" ||
 					db.DriverName() == "sqlite3" {
-					orderList = append(orderList, v)
+					orderList = append(orderList, QuoteName(db.DriverName(), v))
 				} else {
-					orderList = append(orderList, v+" NULLS FIRST")
+					orderList = append(orderList, QuoteName(db.DriverName(), v)+" NULLS FIRST")
 				}
 			}
 		}
@@ -445,7 +545,7 @@ func (s *SqlSelect) BuildSql(db DB) (strSql string) {
 	}
 
 	if s.ManualPage {
-		if str, err := renderManualPageSql(db, renderSql, s.Columns, whereList, orderList, s.Limit); err != nil {
+		if str, err := renderManualPageSql(db, renderSql, quoteNames(db.DriverName(), s.Columns), whereList, orderList, s.Limit); err != nil {
 			log.Panic(err)
 		} else {
 			strSql = str
@@ -456,7 +556,7 @@ func (s *SqlSelect) BuildSql(db DB) (strSql string) {
 
 		//select
 		if len(s.Columns) > 0 {
-			sel = strings.Join(s.Columns, ",")
+			sel = strings.Join(quoteNames(db.DriverName(), s.Columns), ",")
 		}
 		if len(whereList) > 0 {
 			where = " where " + strings.Join(whereList, " and ")
@@ -525,6 +625,7 @@ func (s *SqlSelect) QueryRows(db DB) (result []map[string]interface{}, cols []*C
 			Name: strings.ToUpper(v),
 		}
 		if s.Table != nil {
+			col.Name = s.Table.keyName(v)
 			if tCol := s.Table.Field(col.Name); tCol != nil {
 				col.Type = tCol.Type
 			}
@@ -544,12 +645,13 @@ func (s *SqlSelect) QueryRows(db DB) (result []map[string]interface{}, cols []*C
 			err = SqlError{strSQL, nil, err}
 			return
 		}
-		result = append(result, s.convertRow(oneRecord))
+		row := s.convertRow(oneRecord)
+		result = append(result, row)
 		//再检查所有没有类型的字段的值，根据值来设置类型，nil值的确实没有办法了
 		for _, v := range cols {
 			if len(v.Type) == 0 {
 				//发现Oracle的数值、整型返回的的是字符串，得想其他办法弥补
-				switch oneRecord[v.Name].(type) {
+				switch row[v.Name].(type) {
 				//由于字符串返回[]byte，所以bytea就没了
 				//case []byte:
 				//	v.Type= "BYTEA"
@@ -557,6 +659,12 @@ func (s *SqlSelect) QueryRows(db DB) (result []map[string]interface{}, cols []*C
 					v.Type = "DATE"
 				case float32, float64:
 					v.Type = "FLOAT"
+				case decimal.Decimal:
+					v.Type = "DEC"
+				case bool:
+					v.Type = "BOOL"
+				case map[string]interface{}, []interface{}:
+					v.Type = "JSON"
 				case int, int64, int32, int16, int8, uint, uint64, uint32, uint16, uint8:
 					v.Type = "INT"
 				case string, []byte, nil: //nil作为str处理
@@ -579,7 +687,7 @@ func (s *SqlSelect) renderSql() (strSql string, err error) {
 func (s *SqlSelect) BuildTotalSql(db DB, cols ...string) (strSql string, err error) {
 	totalCoumns := []string{}
 	for _, col := range cols {
-		totalCoumns = append(totalCoumns, fmt.Sprintf("sum(cast(%s(%s,0) as decimal(29,6))) as %[2]s", IsNull(db), col))
+		totalCoumns = append(totalCoumns, fmt.Sprintf("sum(cast(%s(%s,0) as decimal(29,6))) as %[2]s", IsNull(db), QuoteName(db.DriverName(), col)))
 	}
 	if len(totalCoumns) == 0 {
 		return
@@ -720,7 +828,7 @@ func NewSqlSelect(strSql string, table *DBTable, manualPage bool) *SqlSelect {
 	<<if .Where>>WHERE <<.Where>><<end>>
 	<<if .OrderBy>>ORDER BY <<.OrderBy>><<end>>
 	<<if ge .Limit 0>>LIMIT <<.Limit>><<end>>
-<<end>>`, table.Name())
+<<end>>`, table.quotedName())
 	return &SqlSelect{
 		sql:        strSql,
 		Table:      table,
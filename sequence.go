@@ -0,0 +1,397 @@
+package dbx
+
+import (
+	"dbweb/lib/safe"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//mysql、sqlite3没有序列，用这个表模拟，VALUE是最后一次取出的值
+const SequenceTable = "DBX_SEQUENCE"
+
+//模拟序列的表
+func sequenceTable(db DB) *DBTable {
+	tab := NewTable(db, SequenceTable)
+	tab.DefineScript(`NAME str(200)
+VALUE int not null
+primary key(NAME)`)
+	return tab
+}
+
+//序列名称只能是标识符，可以带schema，以免拼接到语句中出错
+var sequenceNameReg = regexp.MustCompile(`^(?:[A-Za-z_][A-Za-z0-9_$#]*\.)?[A-Za-z_][A-Za-z0-9_$#]*$`)
+
+func checkSequenceName(name string) error {
+	if !sequenceNameReg.MatchString(name) {
+		return fmt.Errorf("invalid sequence name %s", name)
+	}
+	return nil
+}
+
+//创建序列、编号使用的表，并发创建时出错，再检查一次是否存在
+func prepareTable(tab *DBTable) error {
+	db := tab.Db
+	ok, err := TableExists(db, tab.Name())
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+	if err := tab.Create(); err != nil {
+		if ok, _ := TableExists(db, tab.Name()); !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+//SequenceExists 序列是否存在
+func SequenceExists(db DB, name string) (bool, error) {
+	var strSql string
+	switch db.DriverName() {
+	case "postgres":
+		strSql = "SELECT count(*) FROM information_schema.sequences WHERE sequence_schema = current_schema() and upper(sequence_name)=:name"
+	case "oci8":
+		strSql = "SELECT count(*) FROM user_sequences WHERE sequence_name=:name"
+	case "mysql", "sqlite3":
+		ok, err := TableExists(db, SequenceTable)
+		if err != nil || !ok {
+			return false, err
+		}
+		strSql = fmt.Sprintf("SELECT count(*) FROM %s WHERE NAME=:name", SequenceTable)
+	default:
+		return false, fmt.Errorf("not impl," + db.DriverName())
+	}
+	var iCount int64
+	p := map[string]interface{}{"name": strings.ToUpper(name)}
+	if err := NameGet(db, &iCount, strSql, p); err != nil {
+		return false, err
+	}
+	return iCount > 0, nil
+}
+
+//CreateSequence 建立序列，start是第一个取出的值，序列已经存在则忽略
+func CreateSequence(db DB, name string, start int64) error {
+	name = strings.ToUpper(name)
+	if err := checkSequenceName(name); err != nil {
+		return err
+	}
+	switch db.DriverName() {
+	case "postgres":
+		return execDDL(db, fmt.Sprintf("CREATE SEQUENCE IF NOT EXISTS %s START WITH %d", name, start))
+	case "oci8":
+		ok, err := SequenceExists(db, name)
+		if err != nil || ok {
+			return err
+		}
+		//并发创建时出错，再检查一次是否存在
+		if err := execDDL(db, fmt.Sprintf("CREATE SEQUENCE %s START WITH %d", name, start)); err != nil {
+			if ok, _ := SequenceExists(db, name); !ok {
+				return err
+			}
+		}
+		return nil
+	case "mysql", "sqlite3":
+		if err := prepareTable(sequenceTable(db)); err != nil {
+			return err
+		}
+		ignore := "IGNORE"
+		if db.DriverName() == "sqlite3" {
+			ignore = "OR IGNORE"
+		}
+		strSql := fmt.Sprintf("INSERT %s INTO %s(NAME,VALUE) VALUES(:name,:value)", ignore, SequenceTable)
+		p := map[string]interface{}{"name": name, "value": start - 1}
+		str, pam := BindSql(db, strSql, p)
+		if _, err := db.Exec(str, pam...); err != nil {
+			return SqlError{strSql, p, err}
+		}
+		return nil
+	default:
+		return fmt.Errorf("not impl," + db.DriverName())
+	}
+}
+
+//DropSequence 删除序列
+func DropSequence(db DB, name string) error {
+	name = strings.ToUpper(name)
+	if err := checkSequenceName(name); err != nil {
+		return err
+	}
+	switch db.DriverName() {
+	case "postgres", "oci8":
+		return execDDL(db, fmt.Sprintf("DROP SEQUENCE %s", name))
+	case "mysql", "sqlite3":
+		strSql := fmt.Sprintf("DELETE FROM %s WHERE NAME=:name", SequenceTable)
+		p := map[string]interface{}{"name": name}
+		str, pam := BindSql(db, strSql, p)
+		if _, err := db.Exec(str, pam...); err != nil {
+			return SqlError{strSql, p, err}
+		}
+		return nil
+	default:
+		return fmt.Errorf("not impl," + db.DriverName())
+	}
+}
+
+//NextValue 取序列的下一个值，并发时不会重复，序列的值不随事务回滚
+//mysql、sqlite3用模拟表时除外，取值随所在事务回滚，并且锁定该行直到事务结束
+func NextValue(db DB, name string) (int64, error) {
+	name = strings.ToUpper(name)
+	if err := checkSequenceName(name); err != nil {
+		return 0, err
+	}
+	switch db.DriverName() {
+	case "postgres":
+		return nextValueBySql(db, fmt.Sprintf("SELECT nextval('%s')", name))
+	case "oci8":
+		return nextValueBySql(db, fmt.Sprintf("SELECT %s.nextval FROM dual", name))
+	case "mysql":
+		//LAST_INSERT_ID(expr)的值在本次执行的结果中返回，不受连接池影响
+		strSql := fmt.Sprintf("UPDATE %s SET VALUE=LAST_INSERT_ID(VALUE+1) WHERE NAME=?", SequenceTable)
+		rs, err := db.Exec(strSql, name)
+		if err != nil {
+			return 0, SqlError{strSql, name, err}
+		}
+		if n, err := rs.RowsAffected(); err != nil {
+			return 0, err
+		} else if n == 0 {
+			return 0, fmt.Errorf("sequence %s not exists", name)
+		}
+		return rs.LastInsertId()
+	case "sqlite3":
+		//更新和读取需要在一个事务中，否则会读到其他连接的更新
+		var rev int64
+		next := func(tx DB) (err error) {
+			rev, err = sqliteNextValue(tx, name)
+			return
+		}
+		if sdb, ok := db.(*sqlx.DB); ok {
+			return rev, RunAtTx(sdb, next)
+		}
+		return rev, next(db)
+	default:
+		return 0, fmt.Errorf("not impl," + db.DriverName())
+	}
+}
+
+//执行取值的语句
+func nextValueBySql(db DB, strSql string) (int64, error) {
+	v, err := GetSqlFun(db, strSql, nil)
+	if err != nil {
+		return 0, err
+	}
+	return safe.Int(v), nil
+}
+
+//sqlite3在事务中先更新再读取
+func sqliteNextValue(db DB, name string) (int64, error) {
+	strSql := fmt.Sprintf("UPDATE %s SET VALUE=VALUE+1 WHERE NAME=?", SequenceTable)
+	rs, err := db.Exec(strSql, name)
+	if err != nil {
+		return 0, SqlError{strSql, name, err}
+	}
+	if n, err := rs.RowsAffected(); err != nil {
+		return 0, err
+	} else if n == 0 {
+		return 0, fmt.Errorf("sequence %s not exists", name)
+	}
+	v, err := GetSqlFun(db, fmt.Sprintf("SELECT VALUE FROM %s WHERE NAME=:name", SequenceTable), map[string]interface{}{"name": name})
+	if err != nil {
+		return 0, err
+	}
+	return safe.Int(v), nil
+}
+
+//编号的重置周期
+const (
+	ResetNever = ""
+	ResetYear  = "YEAR"
+	ResetMonth = "MONTH"
+	ResetDay   = "DAY"
+)
+
+var numberPatternReg = regexp.MustCompile(`\{(yyyy|yy|MM|dd|n(:\d+)?)\}`)
+
+//单据编号的计数表，每个编号每个周期一行，VALUE是最后一次取出的值，新周期开始时删除旧周期的行
+const NumberTable = "DBX_NUMBER"
+
+//不重置的编号使用的周期
+const numberPeriodAll = "ALL"
+
+//编号的计数表
+func numberTable(db DB) *DBTable {
+	tab := NewTable(db, NumberTable)
+	tab.DefineScript(`NAME str(200)
+PERIOD str(8)
+VALUE int not null
+primary key(NAME,PERIOD)`)
+	return tab
+}
+
+//NumberGenerator 单据编号生成器，流水号取自计数表，并发时不会重复
+//Pattern中{yyyy}、{yy}、{MM}、{dd}是日期部分，{n:6}是补零到6位的流水号，{n}不补零，其他字符原样输出
+//例如 PO{yyyy}-{n:6} 生成 PO2026-000123
+//Reset不为空时，流水号每年、月或日从1开始，每个周期是计数表中的一行，不需要建立新的数据库对象
+type NumberGenerator struct {
+	Name    string //计数表中的名称
+	Pattern string
+	Reset   string
+	Now     func() time.Time //当前时间，为空则是time.Now
+
+	prepareLock sync.Mutex
+	prepared    bool //计数表已经建立，只检查一次
+}
+
+func NewNumberGenerator(name, pattern, reset string) *NumberGenerator {
+	return &NumberGenerator{
+		Name:    name,
+		Pattern: pattern,
+		Reset:   reset,
+	}
+}
+
+//当前时间所在的周期
+func (g *NumberGenerator) period(now time.Time) string {
+	switch g.Reset {
+	case ResetYear:
+		return now.Format("2006")
+	case ResetMonth:
+		return now.Format("200601")
+	case ResetDay:
+		return now.Format("20060102")
+	}
+	return numberPeriodAll
+}
+
+//Prepare 建立计数表，在事务中调用Next之前需要先执行，以免在事务中执行DDL，成功后不再重复检查
+func (g *NumberGenerator) Prepare(db *sqlx.DB) error {
+	g.prepareLock.Lock()
+	defer g.prepareLock.Unlock()
+	if g.prepared {
+		return nil
+	}
+	if err := prepareTable(numberTable(db)); err != nil {
+		return err
+	}
+	g.prepared = true
+	return nil
+}
+
+//Next 生成下一个编号，db可以是事务，db是事务时需要先Prepare
+//计数随所在事务回滚，并且锁定计数行直到事务结束，所以编号是连续的
+func (g *NumberGenerator) Next(db DB) (string, error) {
+	now := time.Now()
+	if g.Now != nil {
+		now = g.Now()
+	}
+	name, period := strings.ToUpper(g.Name), g.period(now)
+	var v int64
+	next := func(tx DB) (err error) {
+		v, err = numberNextValue(tx, name, period)
+		return
+	}
+	//不在事务中的，更新和读取放到一个事务中
+	if sdb, ok := db.(*sqlx.DB); ok {
+		if err := g.Prepare(sdb); err != nil {
+			return "", err
+		}
+		if err := RunAtTx(sdb, next); err != nil {
+			return "", err
+		}
+	} else if err := next(db); err != nil {
+		return "", err
+	}
+	return g.Format(now, v), nil
+}
+
+//计数表中取下一个值，没有则插入1，新周期的第一个值删除旧周期的行
+func numberNextValue(db DB, name, period string) (int64, error) {
+	p := map[string]interface{}{"name": name, "period": period}
+	var rev int64
+	switch db.DriverName() {
+	case "postgres":
+		v, err := GetSqlFun(db, fmt.Sprintf(`INSERT INTO %[1]s(NAME,PERIOD,VALUE) VALUES(:name,:period,1)
+			ON CONFLICT(NAME,PERIOD) DO UPDATE SET VALUE=%[1]s.VALUE+1 RETURNING VALUE`, NumberTable), p)
+		if err != nil {
+			return 0, err
+		}
+		rev = safe.Int(v)
+	case "mysql":
+		//LAST_INSERT_ID(expr)的值在本次执行的结果中返回，不受连接池影响
+		strSql := fmt.Sprintf(`INSERT INTO %s(NAME,PERIOD,VALUE) VALUES(?,?,LAST_INSERT_ID(1))
+			ON DUPLICATE KEY UPDATE VALUE=LAST_INSERT_ID(VALUE+1)`, NumberTable)
+		rs, err := db.Exec(strSql, name, period)
+		if err != nil {
+			return 0, SqlError{strSql, p, err}
+		}
+		if rev, err = rs.LastInsertId(); err != nil {
+			return 0, err
+		}
+	case "oci8", "sqlite3":
+		//先更新再读取，需要在事务中
+		var strSql string
+		if db.DriverName() == "oci8" {
+			strSql = fmt.Sprintf(`MERGE INTO %s t USING (SELECT :name NAME,:period PERIOD FROM dual) s
+				ON (t.NAME=s.NAME and t.PERIOD=s.PERIOD)
+				WHEN MATCHED THEN UPDATE SET t.VALUE=t.VALUE+1
+				WHEN NOT MATCHED THEN INSERT(NAME,PERIOD,VALUE) VALUES(s.NAME,s.PERIOD,1)`, NumberTable)
+		} else {
+			strSql = fmt.Sprintf(`INSERT INTO %s(NAME,PERIOD,VALUE) VALUES(:name,:period,1)
+				ON CONFLICT(NAME,PERIOD) DO UPDATE SET VALUE=VALUE+1`, NumberTable)
+		}
+		str, pam := BindSql(db, strSql, p)
+		if _, err := db.Exec(str, pam...); err != nil {
+			//oracle并发插入同一行时违反主键，再执行一次就是更新
+			if db.DriverName() != "oci8" {
+				return 0, SqlError{strSql, p, err}
+			}
+			if _, err := db.Exec(str, pam...); err != nil {
+				return 0, SqlError{strSql, p, err}
+			}
+		}
+		v, err := GetSqlFun(db, fmt.Sprintf("SELECT VALUE FROM %s WHERE NAME=:name and PERIOD=:period", NumberTable), p)
+		if err != nil {
+			return 0, err
+		}
+		rev = safe.Int(v)
+	default:
+		return 0, fmt.Errorf("not impl," + db.DriverName())
+	}
+	if rev == 1 && period != numberPeriodAll {
+		strSql := fmt.Sprintf("DELETE FROM %s WHERE NAME=:name and PERIOD<>:period", NumberTable)
+		str, pam := BindSql(db, strSql, p)
+		if _, err := db.Exec(str, pam...); err != nil {
+			return 0, SqlError{strSql, p, err}
+		}
+	}
+	return rev, nil
+}
+
+//Format 用指定的时间和流水号生成编号
+func (g *NumberGenerator) Format(now time.Time, value int64) string {
+	return numberPatternReg.ReplaceAllStringFunc(g.Pattern, func(s string) string {
+		switch part := s[1 : len(s)-1]; part {
+		case "yyyy":
+			return now.Format("2006")
+		case "yy":
+			return now.Format("06")
+		case "MM":
+			return now.Format("01")
+		case "dd":
+			return now.Format("02")
+		default:
+			width := 0
+			if len(part) > 2 {
+				width, _ = strconv.Atoi(part[2:])
+			}
+			return fmt.Sprintf("%0*d", width, value)
+		}
+	})
+}
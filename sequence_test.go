@@ -0,0 +1,27 @@
+package dbx
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNumberGeneratorFormat(t *testing.T) {
+	now := time.Date(2026, 3, 5, 10, 20, 30, 0, time.Local)
+	cases := []struct {
+		pattern string
+		value   int64
+		want    string
+	}{
+		{"PO{yyyy}-{n:6}", 123, "PO2026-000123"},
+		{"{yy}{MM}{dd}{n:3}", 7, "260305007"},
+		{"SO{n}", 1234567, "SO1234567"},
+		{"X{n:2}", 123, "X123"},
+		{"{mm}-{n}", 1, "{mm}-1"},
+	}
+	for _, c := range cases {
+		g := NewNumberGenerator("test", c.pattern, ResetNever)
+		if got := g.Format(now, c.value); got != c.want {
+			t.Errorf("%s: got %s want %s", c.pattern, got, c.want)
+		}
+	}
+}
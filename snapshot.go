@@ -0,0 +1,138 @@
+package dbx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+//SchemaSnapshot 整个数据库的表结构快照，用于版本管理
+//每个表的定义是DefineScript格式的脚本，按行保存，表按名称排序，以便比较差异
+type SchemaSnapshot struct {
+	Driver string           `json:"driver"`
+	Tables []*TableSnapshot `json:"tables"`
+}
+
+//TableSnapshot 一个表的结构快照
+type TableSnapshot struct {
+	Name             string              `json:"name"`
+	FormerName       []string            `json:"formerName,omitempty"`
+	Define           []string            `json:"define"`
+	ColumnFormerName map[string][]string `json:"columnFormerName,omitempty"`
+}
+
+//从一个表的定义生成快照
+func NewTableSnapshot(t *DBTable) (*TableSnapshot, error) {
+	script, err := t.Script()
+	if err != nil {
+		return nil, fmt.Errorf("table %s:%s", t.Name(), err)
+	}
+	rev := &TableSnapshot{
+		Name:       t.Name(),
+		FormerName: t.FormerName,
+		Define:     strings.Split(script, "\n"),
+	}
+	for _, col := range t.AllField() {
+		if len(col.FormerName) > 0 {
+			if rev.ColumnFormerName == nil {
+				rev.ColumnFormerName = map[string][]string{}
+			}
+			rev.ColumnFormerName[col.Name] = col.FormerName
+		}
+	}
+	return rev, nil
+}
+
+//Table 将快照转换成表的定义
+func (s *TableSnapshot) Table(db DB) *DBTable {
+	rev := tableOf(db, s.Name)
+	rev.DefineScript(strings.Join(s.Define, "\n"))
+	rev.FormerName = s.FormerName
+	for k, v := range s.ColumnFormerName {
+		if col := rev.Field(k); col != nil {
+			col.FormerName = v
+		}
+	}
+	return rev
+}
+
+//ExportSchema 导出数据库中所有表的结构
+func ExportSchema(db DB) (*SchemaSnapshot, error) {
+	rev := &SchemaSnapshot{
+		Driver: db.DriverName(),
+		Tables: []*TableSnapshot{},
+	}
+	for _, name := range TableNames(db) {
+		tab := tableOf(db, name)
+		tab.FetchColumns()
+		one, err := NewTableSnapshot(tab)
+		if err != nil {
+			return nil, err
+		}
+		rev.Tables = append(rev.Tables, one)
+	}
+	sort.Slice(rev.Tables, func(i, j int) bool {
+		return rev.Tables[i].Name < rev.Tables[j].Name
+	})
+	return rev, nil
+}
+
+//将快照编码成json
+func (s *SchemaSnapshot) Encode() ([]byte, error) {
+	bys, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(bys, '\n'), nil
+}
+
+//从json解码快照
+func DecodeSchemaSnapshot(in []byte) (*SchemaSnapshot, error) {
+	rev := new(SchemaSnapshot)
+	if err := json.Unmarshal(in, rev); err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+//TableDefines 将快照转换成表的定义，表定义中的数据类型等与数据库无关，可以用于其他类型的数据库
+func (s *SchemaSnapshot) TableDefines(db DB) []*DBTable {
+	rev := []*DBTable{}
+	for _, v := range s.Tables {
+		rev = append(rev, v.Table(db))
+	}
+	return rev
+}
+
+//Apply 将快照中的所有表更新到数据库中，按外键的引用关系排序
+func (s *SchemaSnapshot) Apply(db DB) error {
+	return UpdateSchemas(s.TableDefines(db)...)
+}
+
+//ExportSchemaFile 导出数据库的结构到文件中
+func ExportSchemaFile(db DB, fileName string) error {
+	snap, err := ExportSchema(db)
+	if err != nil {
+		return err
+	}
+	bys, err := snap.Encode()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, bys, 0644)
+}
+
+//ImportSchemaFile 从文件中读取结构快照并更新到数据库中
+func ImportSchemaFile(db DB, fileName string) error {
+	bys, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	snap, err := DecodeSchemaSnapshot(bys)
+	if err != nil {
+		return err
+	}
+	return snap.Apply(db)
+}
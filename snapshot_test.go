@@ -0,0 +1,19 @@
+package dbx
+
+import "testing"
+
+//区分大小写的表名，快照转换回表时不能改变大小写
+func TestTableSnapshotName(t *testing.T) {
+	for _, name := range []string{"OrderItem", "S.OrderItem", "ORDER_ITEM"} {
+		src := tableOf(nil, name)
+		src.DefineScript(`id int
+primary key(id)`)
+		snap, err := NewTableSnapshot(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := snap.Table(nil).Name(); got != name {
+			t.Errorf("%s: got %s", name, got)
+		}
+	}
+}
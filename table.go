@@ -7,14 +7,18 @@ import (
 
 	"encoding/base64"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
+	"hash/crc32"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/shopspring/decimal"
 
 	"github.com/linlexing/mapfun"
 
@@ -27,19 +31,35 @@ const (
 	TypeDatetime
 	TypeBytea
 	TypeFloat
+	TypeDecimal
+	TypeBool
+	TypeJson
+	TypeCustom //通过RegisterColumnType注册的类型
 )
 
 type DBTableColumn struct {
 	Name        string `db:"DBNAME"`
 	Type        string `db:"DBTYPE"`
 	MaxLength   int    `db:"DBMAXLENGTH"`
+	Precision   int    `db:"DBPRECISION"` //DEC类型的总位数，小于等于0表示不限定
+	Scale       int    `db:"DBSCALE"`     //DEC类型的小数位数
 	Null        bool   `db:"DBNULL"`
+	Default     string `db:"DBDEFAULT"`   //默认值，是一个sql表达式，字符串需要带上单引号，如'a'、0、current_timestamp
+	Identity    bool   `db:"DBIDENTITY"`  //自增字段，插入时不给值则由数据库生成
+	Comment     string `db:"DBCOMMENT"`   //字段的说明，保存在数据库中，sqlite3不支持
+	Generated   string `db:"DBGENERATED"` //计算字段的表达式，如QTY*PRICE，计算字段不能写入
 	TrueType    string `db:"TRUETYPE"`
 	FetchDriver string //上次获取字段信息时，数据库驱动的名称
+	//数据库隐含的自增，如postgres的serial、sqlite3的rowid别名、oracle用序列作为默认值，
+	//调整结构时定义中没有声明identity的，不去掉自增
+	ImplicitIdentity bool `db:"DBIMPLICITIDENTITY"`
 
 	Index      bool     `db:"-"`
 	IndexName  string   `db:"-"` //如果该字段有索引，存放数据库中索引的名称
 	FormerName []string `db:"-"`
+	Enum       []string `db:"-"` //允许的值列表，字符串值不需要引号，由数据库的check约束保证
+	//类型变化时原有数据的转换表达式，用字段的新名称引用原值，如to_date(A,'yyyymmdd')，为空则按类型自动转换
+	Convert string `db:"-"`
 }
 type ColumnType struct {
 	Name string
@@ -49,11 +69,103 @@ type ColumnType struct {
 //判定两个字段定义是否相等
 func (field *DBTableColumn) Eque(src *DBTableColumn) bool {
 	return field.Name == src.Name &&
-		field.Type == src.Type &&
+		field.typeEque(src) &&
 		(field.MaxLength == src.MaxLength ||
 			field.MaxLength <= 0 && src.MaxLength <= 0) &&
+		(field.Type != "DEC" ||
+			field.Precision == src.Precision && field.Scale == src.Scale ||
+			field.Precision <= 0 && src.Precision <= 0) &&
 		field.Null == src.Null
 }
+
+//判定两个字段的类型是否相同，日期时间类型在获取字段的数据库中是同一个类型的也相同，
+//例如postgres中DATE和TIMESTAMP都是timestamp without time zone
+func (field *DBTableColumn) typeEque(src *DBTableColumn) bool {
+	if field.Type == src.Type {
+		return true
+	}
+	if field.GoType() != TypeDatetime || src.GoType() != TypeDatetime {
+		return false
+	}
+	driver := field.FetchDriver
+	if len(driver) == 0 {
+		driver = src.FetchDriver
+	}
+	return len(driver) > 0 && strings.EqualFold(field.DBType(driver), src.DBType(driver))
+}
+
+//判定两个字段的默认值是否相等，数据库返回的默认值会带有类型转换等内容，需要先规范化
+func (field *DBTableColumn) DefaultEque(src *DBTableColumn) bool {
+	return strings.EqualFold(field.normalizedDefault(), src.normalizedDefault())
+}
+
+//规范化的默认值，布尔的默认值在各数据库中是true、false或者1、0，统一成1、0
+func (field *DBTableColumn) normalizedDefault() string {
+	v := normalizeDefault(field.Default)
+	if field.GoType() == TypeBool {
+		if b, err := strconv.ParseBool(v); err == nil && b {
+			return "1"
+		} else if err == nil {
+			return "0"
+		}
+	}
+	return v
+}
+
+//指定驱动下默认值的表达式，布尔的true、false按ValueExpress转换，例如oracle中是1、0
+func (field *DBTableColumn) defaultExpress(driver string) string {
+	if field.GoType() == TypeBool {
+		if b, err := strconv.ParseBool(normalizeDefault(field.Default)); err == nil {
+			return valueExpress(driver, TypeBool, strconv.FormatBool(b))
+		}
+	}
+	return field.Default
+}
+
+//postgres返回的默认值会带上类型转换，如'a'::character varying
+var defaultCastReg = regexp.MustCompile(`::[a-zA-Z_][a-zA-Z0-9_ ]*(\([0-9, ]*\))?(\[\])?$`)
+
+//规范化默认值，去除尾部的类型转换、外层的括号以及空白，null视同没有默认值
+func normalizeDefault(v string) string {
+	v = strings.TrimSpace(v)
+	for {
+		n := strings.TrimSpace(defaultCastReg.ReplaceAllString(v, ""))
+		if len(n) > 1 && n[0] == '(' && n[len(n)-1] == ')' {
+			if _, rest, err := scriptExpress(n); err == nil && len(rest) == 0 && wrappedExpress(n) {
+				n = strings.TrimSpace(n[1 : len(n)-1])
+			}
+		}
+		if n == v {
+			break
+		}
+		v = n
+	}
+	if strings.EqualFold(v, "null") {
+		return ""
+	}
+	return v
+}
+
+//判断表达式是否被一对最外层的括号包住，如(1)+(2)就不是
+func wrappedExpress(v string) bool {
+	depth := 0
+	quote := false
+	for i, c := range v {
+		switch {
+		case c == '\'':
+			quote = !quote
+		case quote:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 && i < len(v)-1 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
 func (f *DBTableColumn) ToJson(v interface{}) (interface{}, error) {
 	if v == nil {
 		return nil, nil
@@ -61,9 +173,15 @@ func (f *DBTableColumn) ToJson(v interface{}) (interface{}, error) {
 	switch f.GoType() {
 	case TypeBytea: //base64
 		return base64.RawStdEncoding.EncodeToString(safe.Bytea(v)), nil
-	case TypeDatetime: //RFC3339
+	case TypeDatetime: //RFC3339，时间戳需要保留小数秒
 		if tv, ok := v.(time.Time); ok {
-			return tv.Format(time.RFC3339), nil
+			switch f.Type {
+			case "DATEONLY":
+				return tv.Format("2006-01-02"), nil
+			case "DATE":
+				return tv.Format(time.RFC3339), nil
+			}
+			return tv.Format(time.RFC3339Nano), nil
 		} else {
 			return nil, fmt.Errorf("the column %s value %v not is time", f.Name, v)
 		}
@@ -73,12 +191,31 @@ func (f *DBTableColumn) ToJson(v interface{}) (interface{}, error) {
 		} else {
 			return nil, fmt.Errorf("the column %s value %#v not is float64", f.Name, v)
 		}
+	case TypeDecimal: //用字符串表示，避免json的浮点数丢失精度
+		if tv, ok := v.(decimal.Decimal); ok {
+			return tv.String(), nil
+		} else {
+			return nil, fmt.Errorf("the column %s value %#v not is decimal", f.Name, v)
+		}
 	case TypeInt:
 		if tv, ok := v.(int64); ok {
 			return tv, nil
 		} else {
 			return nil, fmt.Errorf("the column %s value %v not is int64", f.Name, v)
 		}
+	case TypeBool:
+		if tv, ok := v.(bool); ok {
+			return tv, nil
+		} else {
+			return nil, fmt.Errorf("the column %s value %v not is bool", f.Name, v)
+		}
+	case TypeJson: //直接内嵌
+		return v, nil
+	case TypeCustom:
+		if def := GetColumnType(f.Type); def.ToJson != nil {
+			return def.ToJson(v)
+		}
+		return safe.String(v), nil
 	case TypeString:
 		return safe.String(v), nil
 	default:
@@ -100,6 +237,9 @@ func (f *DBTableColumn) FromJson(v interface{}) (interface{}, error) {
 		return base64.RawStdEncoding.EncodeToString(safe.Bytea(v)), nil
 	case TypeDatetime: //RFC3339
 		if tv, ok := v.(string); ok {
+			if t, err := parseDatetime(tv); err == nil {
+				return t, nil
+			}
 			return safe.StrToDate(tv)
 		} else {
 			return nil, fmt.Errorf("the column %s json value %v not is time string", f.Name, v)
@@ -110,6 +250,13 @@ func (f *DBTableColumn) FromJson(v interface{}) (interface{}, error) {
 		} else {
 			return nil, fmt.Errorf("the column %s json value %v not is float64", f.Name, v)
 		}
+	case TypeDecimal:
+		switch tv := v.(type) {
+		case string, float64, int64, int:
+			return toDecimal(tv)
+		default:
+			return nil, fmt.Errorf("the column %s json value %v (%T) not is decimal string", f.Name, v, v)
+		}
 	case TypeInt:
 		switch tv := v.(type) {
 		case string:
@@ -125,12 +272,26 @@ func (f *DBTableColumn) FromJson(v interface{}) (interface{}, error) {
 		default:
 			return nil, fmt.Errorf("the column %s json value %v (%T) not is int64 string", f.Name, v, v)
 		}
+	case TypeBool:
+		switch tv := v.(type) {
+		case bool, string, float64:
+			return toBool(tv)
+		default:
+			return nil, fmt.Errorf("the column %s json value %v (%T) not is bool", f.Name, v, v)
+		}
+	case TypeJson:
+		return v, nil
 	case TypeString:
 		if tv, ok := v.(string); ok {
 			return tv, nil
 		} else {
 			return nil, fmt.Errorf("the column %s json value %v not is string", f.Name, v)
 		}
+	case TypeCustom:
+		if def := GetColumnType(f.Type); def.FromJson != nil {
+			return def.FromJson(v)
+		}
+		return v, nil
 	default:
 		return nil, fmt.Errorf("the column %s type error", f.Name)
 	}
@@ -169,9 +330,9 @@ func (field *DBTableColumn) ConvertToTrueType(v interface{}) (result interface{}
 		case nil:
 			result = tv
 		case string:
-			result = safe.Date(tv)
+			result = strToDatetime(tv)
 		case []byte:
-			result = safe.Date(string(tv))
+			result = strToDatetime(string(tv))
 		default:
 			log.Panic(fmt.Errorf("error type,%T", v))
 		}
@@ -182,12 +343,130 @@ func (field *DBTableColumn) ConvertToTrueType(v interface{}) (result interface{}
 		result = safe.Bytea(v)
 	case TypeFloat:
 		result = safe.Float64(v)
+	case TypeDecimal:
+		d, err := toDecimal(v)
+		if err != nil {
+			log.Panic(err)
+		}
+		result = d
+	case TypeBool:
+		b, err := toBool(v)
+		if err != nil {
+			log.Panic(err)
+		}
+		result = b
+	case TypeJson:
+		//json文档解码成map、slice等go值
+		switch tv := v.(type) {
+		case string:
+			if err := json.Unmarshal([]byte(tv), &result); err != nil {
+				log.Panic(err)
+			}
+		case []byte:
+			if err := json.Unmarshal(tv, &result); err != nil {
+				log.Panic(err)
+			}
+		default:
+			result = v
+		}
+	case TypeCustom:
+		if def := GetColumnType(field.Type); def.Convert != nil {
+			var err error
+			if result, err = def.Convert(v); err != nil {
+				log.Panic(err)
+			}
+		} else if tv, ok := v.([]byte); ok {
+			result = string(tv)
+		} else {
+			result = v
+		}
 	default:
 		result = v
 	}
 
 	return
 }
+
+//数据库中日期时间的文本格式，小数秒及时区都需要保留
+var datetimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999-07",
+	time.RFC3339,
+	time.RFC3339Nano,
+	"2006-01-02",
+}
+
+//依次尝试各种格式解析日期时间
+func parseDatetime(v string) (t time.Time, err error) {
+	v = strings.TrimSpace(v)
+	for _, layout := range datetimeLayouts {
+		if t, err = time.Parse(layout, v); err == nil {
+			return
+		}
+	}
+	return
+}
+
+//文本转换成日期时间，无法识别的格式再交给safe.Date处理
+func strToDatetime(v string) time.Time {
+	if t, err := parseDatetime(v); err == nil {
+		return t
+	}
+	return safe.Date(v)
+}
+
+//将数据库返回的值转换成bool，oracle、sqlite3、mysql中是用0、1存储的
+func toBool(v interface{}) (bool, error) {
+	switch tv := v.(type) {
+	case bool:
+		return tv, nil
+	case string:
+		return strconv.ParseBool(strings.TrimSpace(tv))
+	case []byte:
+		return strconv.ParseBool(strings.TrimSpace(string(tv)))
+	case int64:
+		return tv != 0, nil
+	case int:
+		return tv != 0, nil
+	case int32:
+		return tv != 0, nil
+	case float64:
+		return tv != 0, nil
+	default:
+		return false, fmt.Errorf("error type,%T", v)
+	}
+}
+
+//将数据库或者json返回的值转换成精确的decimal，不经过float64
+func toDecimal(v interface{}) (decimal.Decimal, error) {
+	switch tv := v.(type) {
+	case decimal.Decimal:
+		return tv, nil
+	case *decimal.Decimal:
+		return *tv, nil
+	case string:
+		return decimal.NewFromString(strings.TrimSpace(tv))
+	case []byte:
+		return decimal.NewFromString(strings.TrimSpace(string(tv)))
+	case int64:
+		return decimal.New(tv, 0), nil
+	case int:
+		return decimal.New(int64(tv), 0), nil
+	case int32:
+		return decimal.New(int64(tv), 0), nil
+	case float64:
+		return decimal.NewFromFloat(tv), nil
+	case float32:
+		return decimal.NewFromFloat(float64(tv)), nil
+	default:
+		return decimal.Decimal{}, fmt.Errorf("error type,%T", v)
+	}
+}
 func (c *DBTableColumn) ChineseType() string {
 	switch c.Type {
 	case "STR":
@@ -196,11 +475,29 @@ func (c *DBTableColumn) ChineseType() string {
 		return "整型"
 	case "DATE":
 		return "日期"
+	case "DATEONLY":
+		return "纯日期"
+	case "TIMESTAMP":
+		return "时间戳"
+	case "TIMESTAMPTZ":
+		return "带时区的时间戳"
 	case "FLOAT":
 		return "浮点"
 	case "BYTEA":
 		return "二进制"
+	case "DEC":
+		return "定点小数"
+	case "BOOL":
+		return "布尔"
+	case "JSON":
+		return "JSON文档"
 	default:
+		if def := GetColumnType(c.Type); def != nil {
+			if len(def.ChineseName) > 0 {
+				return def.ChineseName
+			}
+			return def.Name
+		}
 		log.Panic("invalid type:" + c.Type)
 		return ""
 	}
@@ -211,13 +508,22 @@ func (c *DBTableColumn) GoType() int {
 		return TypeString
 	case "INT":
 		return TypeInt
-	case "DATE":
+	case "DATE", "DATEONLY", "TIMESTAMP", "TIMESTAMPTZ":
 		return TypeDatetime
 	case "FLOAT":
 		return TypeFloat
 	case "BYTEA":
 		return TypeBytea
+	case "DEC":
+		return TypeDecimal
+	case "BOOL":
+		return TypeBool
+	case "JSON":
+		return TypeJson
 	default:
+		if GetColumnType(c.Type) != nil {
+			return TypeCustom
+		}
 		log.Panic("invalid type:" + c.Type)
 		return -1
 	}
@@ -234,6 +540,12 @@ func ParseGoType(t int) string {
 		return "FLOAT"
 	case TypeBytea:
 		return "BYTEA"
+	case TypeDecimal:
+		return "DEC"
+	case TypeBool:
+		return "BOOL"
+	case TypeJson:
+		return "JSON"
 	default:
 		log.Panic(fmt.Sprintf("invalid type:%d", t))
 		return ""
@@ -241,7 +553,8 @@ func ParseGoType(t int) string {
 
 }
 func (c *DBTableColumn) Clone() *DBTableColumn {
-	return &DBTableColumn{c.Name, c.Type, c.MaxLength, c.Null, c.TrueType, c.FetchDriver, c.Index, c.IndexName, c.FormerName}
+	rev := *c
+	return &rev
 }
 
 //postgres修改字段，不需要名称和notnull
@@ -249,6 +562,9 @@ func (c *DBTableColumn) DBType(driver string) string {
 	if c.FetchDriver == driver && len(c.TrueType) > 0 {
 		return c.TrueType
 	}
+	if def := GetColumnType(c.Type); def != nil {
+		return def.DBType(driver, c)
+	}
 	var dataType string
 	switch driver {
 	case "postgres":
@@ -256,9 +572,23 @@ func (c *DBTableColumn) DBType(driver string) string {
 		case TypeBytea:
 			dataType = "bytea"
 		case TypeDatetime:
-			dataType = "timestamp without time zone"
+			//DATE是原有的类型，带有时间
+			switch c.Type {
+			case "DATEONLY":
+				dataType = "date"
+			case "TIMESTAMPTZ":
+				dataType = "timestamp with time zone"
+			default:
+				dataType = "timestamp without time zone"
+			}
 		case TypeFloat:
 			dataType = "double precision"
+		case TypeDecimal:
+			dataType = "numeric" + c.precisionScale()
+		case TypeBool:
+			dataType = "boolean"
+		case TypeJson:
+			dataType = "jsonb"
 		case TypeInt:
 			dataType = "integer"
 		case TypeString:
@@ -276,9 +606,25 @@ func (c *DBTableColumn) DBType(driver string) string {
 		case TypeBytea:
 			dataType = "BLOB"
 		case TypeDatetime:
-			dataType = "DATE"
+			//oracle的DATE带有时间，精确到秒
+			switch c.Type {
+			case "TIMESTAMP":
+				dataType = "TIMESTAMP"
+			case "TIMESTAMPTZ":
+				dataType = "TIMESTAMP WITH TIME ZONE"
+			default:
+				dataType = "DATE"
+			}
 		case TypeFloat:
 			dataType = "BINARY_DOUBLE"
+		case TypeDecimal:
+			dataType = "NUMBER" + c.precisionScale()
+		case TypeBool:
+			//oracle没有布尔类型，用0、1表示，并在DBDefine中加上check约束
+			dataType = "NUMBER(1)"
+		case TypeJson:
+			//oracle 12c开始支持is json约束，在DBDefine中加上
+			dataType = "CLOB"
 		case TypeInt:
 			dataType = "INT"
 		case TypeString:
@@ -301,9 +647,24 @@ func (c *DBTableColumn) DBType(driver string) string {
 		case TypeBytea:
 			dataType = "BLOB"
 		case TypeDatetime:
-			dataType = "DATE"
+			switch c.Type {
+			case "TIMESTAMP":
+				dataType = "TIMESTAMP"
+			case "TIMESTAMPTZ":
+				dataType = "TIMESTAMPTZ"
+			default:
+				dataType = "DATE"
+			}
 		case TypeFloat:
 			dataType = "REAL"
+		case TypeDecimal:
+			dataType = "NUMERIC" + c.precisionScale()
+		case TypeBool:
+			//长度1用于FetchColumns时区分布尔和整型
+			dataType = "INTEGER(1)"
+		case TypeJson:
+			//类型名中含有TEXT即是文本近似，JSON字样用于FetchColumns时识别
+			dataType = "JSON TEXT"
 		case TypeInt:
 			dataType = "INTEGER"
 		case TypeString:
@@ -320,9 +681,30 @@ func (c *DBTableColumn) DBType(driver string) string {
 		case TypeBytea:
 			dataType = "BLOB"
 		case TypeDatetime:
-			dataType = "DATETIME"
+			//mysql的timestamp会按会话时区转换成UTC保存，作为带时区的时间戳
+			switch c.Type {
+			case "DATEONLY":
+				dataType = "DATE"
+			case "TIMESTAMP":
+				dataType = "DATETIME(6)"
+			case "TIMESTAMPTZ":
+				dataType = "TIMESTAMP(6)"
+			default:
+				dataType = "DATETIME"
+			}
 		case TypeFloat:
 			dataType = "DOUBLE PRECISION"
+		case TypeDecimal:
+			//mysql的decimal不指定精度时默认是(10,0)，所以需要给出最大精度
+			if c.Precision <= 0 {
+				dataType = "DECIMAL(65,30)"
+			} else {
+				dataType = "DECIMAL" + c.precisionScale()
+			}
+		case TypeBool:
+			dataType = "TINYINT(1)"
+		case TypeJson:
+			dataType = "JSON"
 		case TypeInt:
 			dataType = "BIGINT"
 		case TypeString:
@@ -340,12 +722,147 @@ func (c *DBTableColumn) DBType(driver string) string {
 	}
 	return dataType
 }
+
+//返回DEC类型的精度定义，如(18,2)，没有精度则返回空
+func (c *DBTableColumn) precisionScale() string {
+	if c.Precision <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("(%d,%d)", c.Precision, c.Scale)
+}
+
+//返回字段定义中附加的check约束，目前只有oracle的布尔和json需要
+func (c *DBTableColumn) dbCheck(driver string) string {
+	if driver == "oci8" {
+		switch c.GoType() {
+		case TypeBool:
+			return fmt.Sprintf(" CHECK (%s IN (0,1))", QuoteName(driver, c.Name))
+		case TypeJson:
+			return fmt.Sprintf(" CHECK (%s IS JSON)", QuoteName(driver, c.Name))
+		}
+	}
+	return ""
+}
+
+//返回字段定义中的默认值子句，没有默认值返回空
+func (c *DBTableColumn) dbDefault(driver string) string {
+	if len(c.Default) == 0 {
+		return ""
+	}
+	return " DEFAULT " + c.defaultExpress(driver)
+}
+
+//返回自增字段的定义子句，自增字段忽略默认值
+//sqlite3中INTEGER类型的单字段主键就是rowid，本身就是自增的，不需要子句
+func (c *DBTableColumn) dbIdentity(driver string) string {
+	switch driver {
+	case "postgres":
+		return " GENERATED BY DEFAULT AS IDENTITY"
+	case "oci8":
+		return " GENERATED BY DEFAULT ON NULL AS IDENTITY"
+	case "mysql":
+		return " AUTO_INCREMENT"
+	}
+	return ""
+}
 func (c *DBTableColumn) DBDefine(driver string) string {
 	nullStr := ""
 	if !c.Null {
 		nullStr = " NOT NULL"
 	}
-	return fmt.Sprintf("%s %s%s", c.Name, c.DBType(driver), nullStr)
+	def := c.dbDefault(driver)
+	if c.Identity {
+		def = c.dbIdentity(driver)
+	}
+	if len(c.Generated) > 0 {
+		def = c.dbGenerated(driver)
+	}
+	return fmt.Sprintf("%s %s%s%s%s%s", QuoteName(driver, c.Name), c.DBType(driver), def, nullStr, c.dbCheck(driver), c.dbComment(driver))
+}
+
+//返回枚举值的check约束条件，如STATUS IN ('a','b')
+func (c *DBTableColumn) enumExpress(driver string) string {
+	list := []string{}
+	for _, v := range c.Enum {
+		switch c.GoType() {
+		case TypeInt, TypeFloat, TypeDecimal:
+			list = append(list, v)
+		default:
+			list = append(list, safe.SignString(v))
+		}
+	}
+	return fmt.Sprintf("%s IN (%s)", QuoteName(driver, c.Name), strings.Join(list, ","))
+}
+
+//判断值是否在枚举值中，数值类型按数值比较，没有枚举值或者是空值都返回true
+func (c *DBTableColumn) inEnum(v interface{}) bool {
+	if len(c.Enum) == 0 || v == nil {
+		return true
+	}
+	switch c.GoType() {
+	case TypeInt, TypeFloat, TypeDecimal:
+		dv, err := toDecimal(v)
+		if err != nil {
+			return false
+		}
+		for _, e := range c.Enum {
+			if ev, err := decimal.NewFromString(e); err == nil && ev.Equal(dv) {
+				return true
+			}
+		}
+	default:
+		sv := safe.String(v)
+		for _, e := range c.Enum {
+			if e == sv {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//字段枚举值约束的名称
+func (t *DBTable) enumCheckName(colName string) string {
+	return objectName("%s_%s_CK", t.TableName, colName)
+}
+
+//返回建表语句中的约束定义，包括命名的check约束和字段的枚举值约束
+func (t *DBTable) checkDefines() []string {
+	driver := t.Db.DriverName()
+	rev := []string{}
+	for _, v := range t.Checks {
+		rev = append(rev, fmt.Sprintf("CONSTRAINT %s CHECK (%s)", QuoteName(driver, v.Name), v.Express))
+	}
+	for _, v := range t.AllField() {
+		if len(v.Enum) > 0 {
+			rev = append(rev, fmt.Sprintf("CONSTRAINT %s CHECK (%s)", QuoteName(driver, t.enumCheckName(v.Name)), v.enumExpress(driver)))
+		}
+	}
+	return rev
+}
+
+//返回计算字段的定义子句，postgres和mysql是存储的，oracle和sqlite3是虚拟的
+//sqlite3的alter table不能增加存储的计算字段
+func (c *DBTableColumn) dbGenerated(driver string) string {
+	switch driver {
+	case "postgres", "mysql":
+		return fmt.Sprintf(" GENERATED ALWAYS AS (%s) STORED", c.Generated)
+	default:
+		return fmt.Sprintf(" GENERATED ALWAYS AS (%s) VIRTUAL", c.Generated)
+	}
+}
+
+//判定两个字段的计算表达式是否相等
+func (field *DBTableColumn) GeneratedEque(src *DBTableColumn) bool {
+	return normalizeCheck(field.Generated) == normalizeCheck(src.Generated)
+}
+
+//mysql的字段说明是字段定义的一部分，其他数据库用comment on语句
+func (c *DBTableColumn) dbComment(driver string) string {
+	if driver == "mysql" && len(c.Comment) > 0 {
+		return " COMMENT " + safe.SignString(c.Comment)
+	}
+	return ""
 }
 
 //如果是null，则有null字样
@@ -354,58 +871,125 @@ func (c *DBTableColumn) DBDefineNull(driver string) string {
 	if !c.Null {
 		nullStr = " NOT NULL"
 	}
-	return fmt.Sprintf("%s %s%s", c.Name, c.DBType(driver), nullStr)
+	return fmt.Sprintf("%s %s%s", QuoteName(driver, c.Name), c.DBType(driver), nullStr)
 }
 func (c *DBTableColumn) GoValue(v string) interface{} {
+	rev, err := c.parseValue(v)
+	if err != nil {
+		log.Panic(err)
+	}
+	return rev
+}
+
+//文本转换成字段类型的go值，空串是nil
+func (c *DBTableColumn) parseValue(v string) (interface{}, error) {
 	if len(v) == 0 {
-		return nil
+		return nil, nil
 	}
 	switch c.GoType() {
 	case TypeString:
-		return v
+		return v, nil
 	case TypeInt:
-		if i, err := strconv.ParseInt(v, 10, 64); err != nil {
-			log.Panic(err)
-			return nil
-		} else {
-			return i
-		}
+		return strconv.ParseInt(v, 10, 64)
 	case TypeDatetime:
-		t, err := time.Parse("2006-01-02 15:04:05", v)
-		if err != nil {
-			t, err = time.Parse("2006-01-02T15:04:05", v)
-		}
-		if err != nil {
-			t, err = time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", v)
-		}
-		if err != nil {
-			t, err = time.Parse(time.RFC3339, v)
+		return parseDatetime(v)
+	case TypeBytea:
+		return []byte(v), nil
+	case TypeFloat:
+		return strconv.ParseFloat(v, 64)
+	case TypeDecimal:
+		return decimal.NewFromString(v)
+	case TypeBool:
+		return strconv.ParseBool(v)
+	case TypeJson:
+		var j interface{}
+		if err := json.Unmarshal([]byte(v), &j); err != nil {
+			return nil, err
+		}
+		return j, nil
+	case TypeCustom:
+		def := GetColumnType(c.Type)
+		if def.Parse != nil {
+			return def.Parse(v)
+		} else if def.Convert != nil {
+			return def.Convert(v)
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("not impl gotype %v", c)
+	}
+}
+
+//类型变化时原值的转换表达式，postgres用于using，oracle、mysql、sqlite3用于复制到新字段
+//oracle的文本转换成数值和日期需要显式转换，mysql的赋值会自动转换
+func (c *DBTableColumn) convertExpress(driver string, oldCol *DBTableColumn) string {
+	if len(c.Convert) > 0 {
+		return c.Convert
+	}
+	name := QuoteName(driver, c.Name)
+	switch driver {
+	case "postgres":
+		return fmt.Sprintf("%s::%s", name, c.DBType(driver))
+	case "oci8":
+		if oldCol.GoType() == TypeString {
+			switch c.GoType() {
+			case TypeInt, TypeFloat, TypeDecimal:
+				return fmt.Sprintf("to_number(%s)", name)
+			case TypeDatetime:
+				return fmt.Sprintf("to_timestamp(%s,'YYYY-MM-DD HH24:MI:SS.FF')", name)
+			}
 		}
-		if err != nil {
-			t, err = time.Parse(time.RFC3339Nano, v)
+	case "sqlite3":
+		//sqlite3是动态类型，数值和文本之间需要转换，日期是用文本存储的
+		switch c.GoType() {
+		case TypeInt:
+			return fmt.Sprintf("cast(%s as integer)", name)
+		case TypeFloat:
+			return fmt.Sprintf("cast(%s as real)", name)
+		case TypeDecimal:
+			return fmt.Sprintf("cast(%s as numeric)", name)
+		case TypeString:
+			return fmt.Sprintf("cast(%s as text)", name)
 		}
+	}
+	return name
+}
 
-		if err != nil {
-			t, err = time.Parse("2006-01-02", v)
-		}
-		if err != nil {
-			log.Panic(err)
-			return -1
+//原值不能按convertExpress转换的条件，用各数据库自己的转换规则判断，为空则不需要检查，
+//有转换表达式的，表达式结果为空即不能转换，postgres需要16以上的版本，oracle需要12.2以上的版本
+func (c *DBTableColumn) convertFailExpress(driver string, oldCol *DBTableColumn) string {
+	if len(c.Convert) > 0 {
+		return fmt.Sprintf("(%s) is null", c.Convert)
+	}
+	if oldCol.GoType() != TypeString || c.GoType() == TypeString {
+		return ""
+	}
+	name := QuoteName(driver, c.Name)
+	switch driver {
+	case "postgres":
+		return fmt.Sprintf("not pg_input_is_valid(%s::text,'%s')", name, c.DBType(driver))
+	case "oci8":
+		switch c.GoType() {
+		case TypeInt, TypeFloat, TypeDecimal:
+			return fmt.Sprintf("validate_conversion(%s as number)=0", name)
+		case TypeDatetime:
+			return fmt.Sprintf("validate_conversion(%s as timestamp,'YYYY-MM-DD HH24:MI:SS.FF')=0", name)
 		}
-		return t
-	case TypeBytea:
-		return []byte(v)
-	case TypeFloat:
-		if f, err := strconv.ParseFloat(v, 64); err != nil {
-			log.Panic(err)
-			return nil
-		} else {
-			return f
+	case "mysql":
+		//mysql严格模式下赋值时不能转换的值会出错
+		switch c.GoType() {
+		case TypeInt, TypeBool:
+			return fmt.Sprintf("%s not regexp '^[[:space:]]*[-+]?[0-9]+[[:space:]]*$'", name)
+		case TypeFloat, TypeDecimal:
+			return fmt.Sprintf("%s not regexp '^[[:space:]]*[-+]?([0-9]+[.]?[0-9]*|[.][0-9]+)([eE][-+]?[0-9]+)?[[:space:]]*$'", name)
+		case TypeDatetime:
+			return fmt.Sprintf("cast(%s as datetime) is null", name)
+		case TypeJson:
+			return fmt.Sprintf("not json_valid(%s)", name)
 		}
-	default:
-		log.Panic("not impl gotype", c)
-		return nil
 	}
+	//sqlite3的cast不会出错
+	return ""
 }
 
 type DBTable struct {
@@ -413,6 +997,11 @@ type DBTable struct {
 	TableName      string
 	Schema         string //对应数据库中方案的名称
 	FormerName     []string
+	Comment        string     //表的说明，保存在数据库中，sqlite3不支持
+	Checks         []*DBCheck //命名的check约束
+	Indexes        []*DBIndex //单字段普通索引以外的索引
+	ForeignKeys    []*DBForeignKey
+	Partition      *DBPartition //表分区，只在建表时生成
 	primaryKeys    []string
 	columns        []*DBTableColumn
 	notnullColumns []string
@@ -420,37 +1009,212 @@ type DBTable struct {
 	columnsMap     map[string]*DBTableColumn //用于快速查询
 }
 
-func NewTable(db DB, tabName string) *DBTable {
-	if len(tabName) == 0 {
-		log.Panic("table name is empty")
+//表的check约束，字段的枚举值约束不在其中
+type DBCheck struct {
+	Name    string
+	Express string //约束的条件表达式，不含check字样和外层的括号
+}
+
+//判定两个约束是否相等，数据库返回的表达式会加上括号、类型转换等，所以比较规范化后的表达式
+func (c *DBCheck) Eque(src *DBCheck) bool {
+	return strings.EqualFold(c.Name, src.Name) &&
+		normalizeCheck(c.Express) == normalizeCheck(src.Express)
+}
+
+var checkCastReg = regexp.MustCompile(`::[a-z_]+( varying| precision| without time zone| with time zone)?(\[\])?`)
+
+//规范化约束表达式，去掉类型转换、括号、引号以及空白，仅用于比较
+func normalizeCheck(v string) string {
+	v = checkCastReg.ReplaceAllString(strings.ToLower(v), "")
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || strings.ContainsRune("()\"`", r) {
+			return -1
+		}
+		return r
+	}, v)
+}
+
+//表的索引，单字段的普通索引不在其中，用字段的Index标识
+type DBIndex struct {
+	Name    string   //索引名称，为空则自动生成
+	Columns []string //索引的字段，表达式索引为空
+	Unique  bool
+	Express string //表达式索引的表达式，如lower(a)，多个用逗号分隔
+	Where   string //部分索引的条件，只有postgres和sqlite3支持
+}
+
+//是否单字段的普通索引
+func (i *DBIndex) single() bool {
+	return !i.Unique && len(i.Columns) == 1 && len(i.Express) == 0 && len(i.Where) == 0
+}
+
+//索引的特征，用于比较两个索引是否相同，名称不参与比较
+func (i *DBIndex) signature() string {
+	cols := []string{}
+	for _, v := range i.Columns {
+		cols = append(cols, strings.ToUpper(v))
 	}
-	ns := strings.Split(tabName, ".")
-	rev := &DBTable{
-		Db: db,
+	return fmt.Sprintf("%v|%s|%s|%s", i.Unique, strings.Join(cols, ","), normalizeCheck(i.Express), normalizeCheck(i.Where))
+}
+
+//返回索引的名称，没有名称则根据表名和字段生成，表达式索引用表达式的hash
+func (t *DBTable) indexName(idx *DBIndex) string {
+	if len(idx.Name) > 0 {
+		return idx.Name
 	}
-	if len(ns) > 1 {
-		rev.Schema = strings.ToUpper(ns[0])
-		rev.TableName = strings.ToUpper(ns[1])
-	} else {
-		rev.TableName = strings.ToUpper(tabName)
+	prev := "I"
+	if idx.Unique {
+		prev = "U"
 	}
-	return rev
-}
-func (t *DBTable) Name() string {
-	if len(t.Schema) > 0 {
-		return t.Schema + "." + t.TableName
-	} else {
-		return t.TableName
+	if len(idx.Express) > 0 {
+		return objectName("%s%s_%08x", prev, t.TableName, crc32.ChecksumIEEE([]byte(normalizeCheck(idx.Express+idx.Where))))
 	}
+	return objectName("%s%s_%s", prev, t.TableName, strings.Join(idx.Columns, "_"))
 }
-func (t *DBTable) PrimaryKeys() []string {
-	if t.primaryKeys != nil {
-		return t.primaryKeys
-	}
-	result := []string{}
-	schema := t.Schema
 
-	switch t.Db.DriverName() {
+//外键约束
+type DBForeignKey struct {
+	Name       string //约束名称，为空则自动生成
+	Columns    []string
+	RefTable   string   //引用的表，不同schema的需要带上schema
+	RefColumns []string //引用表的字段，为空则是引用表的主键
+	OnDelete   string   //CASCADE、SET NULL、SET DEFAULT、RESTRICT，为空是NO ACTION
+	OnUpdate   string   //同OnDelete，oracle不支持
+}
+
+//规范化外键的动作，NO ACTION视同为空，mysql中RESTRICT和NO ACTION是一样的，oracle没有on update
+func foreignKeyRule(driver, rule string, update bool) string {
+	rule = strings.Join(strings.Fields(strings.ToUpper(rule)), " ")
+	if rule == "NO ACTION" || driver == "mysql" && rule == "RESTRICT" || driver == "oci8" && update {
+		return ""
+	}
+	return rule
+}
+
+//外键的特征，用于比较两个外键是否相同，名称不参与比较，RefColumns需要先补全
+func (f *DBForeignKey) signature(driver string) string {
+	upper := func(list []string) string {
+		rev := []string{}
+		for _, v := range list {
+			rev = append(rev, strings.ToUpper(v))
+		}
+		return strings.Join(rev, ",")
+	}
+	return fmt.Sprintf("%s|%s|%s|%s|%s", upper(f.Columns), strings.ToUpper(f.RefTable), upper(f.RefColumns),
+		foreignKeyRule(driver, f.OnDelete, false), foreignKeyRule(driver, f.OnUpdate, true))
+}
+
+//返回建表语句或者alter table add中的外键定义，名称和RefColumns需要先补全
+func (f *DBForeignKey) define(driver string) string {
+	rev := fmt.Sprintf("CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s(%s)",
+		QuoteName(driver, f.Name), strings.Join(quoteNames(driver, f.Columns), ","),
+		QuoteName(driver, f.RefTable), strings.Join(quoteNames(driver, f.RefColumns), ","))
+	if rule := foreignKeyRule(driver, f.OnDelete, false); len(rule) > 0 {
+		rev += " ON DELETE " + rule
+	}
+	if rule := foreignKeyRule(driver, f.OnUpdate, true); len(rule) > 0 {
+		rev += " ON UPDATE " + rule
+	}
+	return rev
+}
+
+//外键约束的名称
+func (t *DBTable) foreignKeyName(fk *DBForeignKey) string {
+	if len(fk.Name) > 0 {
+		return fk.Name
+	}
+	return objectName("%s_%s_FK", t.TableName, strings.Join(fk.Columns, "_"))
+}
+
+var (
+	enumStringReg = regexp.MustCompile(`'((?:[^']|'')*)'`)
+	enumNumberReg = regexp.MustCompile(`-?[0-9]+(\.[0-9]+)?`)
+)
+
+//从数据库返回的枚举约束表达式中取出值列表，字符串取出所有的字面量，其他的取出所有的数字
+func parseEnumValues(express, colName string) []string {
+	rev := []string{}
+	if list := enumStringReg.FindAllStringSubmatch(express, -1); len(list) > 0 {
+		for _, v := range list {
+			rev = append(rev, strings.Replace(v[1], "''", "'", -1))
+		}
+		return rev
+	}
+	//去掉字段名，以免字段名中的数字被识别
+	express = regexp.MustCompile(`(?i)\b`+regexp.QuoteMeta(colName)+`\b`).ReplaceAllString(express, "")
+	return enumNumberReg.FindAllString(express, -1)
+}
+
+//NewTable 新建一个表，表名不区分大小写，转换成大写，区分大小写的表名需要用引号，如"OrderItem"
+func NewTable(db DB, tabName string) *DBTable {
+	if len(tabName) == 0 {
+		log.Panic("table name is empty")
+	}
+	ns := strings.Split(tabName, ".")
+	rev := &DBTable{
+		Db: db,
+	}
+	if len(ns) > 1 {
+		rev.Schema = defineName(ns[0])
+		rev.TableName = defineName(ns[1])
+	} else {
+		rev.TableName = defineName(tabName)
+	}
+	return rev
+}
+
+//用dbx中的名称新建一个表，例如Name()、TableNames返回的名称，不再转换大小写
+func tableOf(db DB, name string) *DBTable {
+	rev := NewTable(db, name)
+	if ns := strings.Split(name, "."); len(ns) > 1 {
+		rev.Schema, rev.TableName = ns[0], ns[1]
+	} else {
+		rev.TableName = name
+	}
+	return rev
+}
+func (t *DBTable) Name() string {
+	if len(t.Schema) > 0 {
+		return t.Schema + "." + t.TableName
+	} else {
+		return t.TableName
+	}
+}
+
+//sql语句中引用的表名
+func (t *DBTable) quotedName() string {
+	return QuoteName(t.Db.DriverName(), t.Name())
+}
+
+//sql语句中引用的字段名
+func (t *DBTable) quote(colName string) string {
+	return QuoteName(t.Db.DriverName(), colName)
+}
+
+//行数据中的键对应的字段名，查询结果的键是大写，需要对应回区分大小写的字段
+func (t *DBTable) keyName(k string) string {
+	if t.Field(k) != nil {
+		return k
+	}
+	upper := strings.ToUpper(k)
+	if t.Field(upper) != nil {
+		return upper
+	}
+	for _, col := range t.AllField() {
+		if strings.ToUpper(col.Name) == upper {
+			return col.Name
+		}
+	}
+	return upper
+}
+func (t *DBTable) PrimaryKeys() []string {
+	if t.primaryKeys != nil {
+		return t.primaryKeys
+	}
+	result := []string{}
+	schema := t.Schema
+
+	switch t.Db.DriverName() {
 	case "postgres":
 
 		if err := t.Db.Select(&result,
@@ -459,7 +1223,7 @@ func (t *DBTable) PrimaryKeys() []string {
 			JOIN   pg_attribute a ON a.attrelid = i.indrelid
 			        AND a.attnum = ANY(i.indkey)
 			WHERE  i.indrelid = $1::regclass
-			AND    i.indisprimary;`, t.Name()); err != nil {
+			AND    i.indisprimary;`, t.quotedName()); err != nil {
 			log.Panic(err)
 		}
 	case "oci8":
@@ -479,7 +1243,7 @@ func (t *DBTable) PrimaryKeys() []string {
 			log.Panic(err)
 		}
 	case "sqlite3":
-		strSql := fmt.Sprintf(`PRAGMA table_info(%s)`, t.Name())
+		strSql := fmt.Sprintf(`PRAGMA table_info(%s)`, t.quotedName())
 		r, _, err := QueryRecord(t.Db, strSql, nil)
 		if err != nil {
 			log.Panic(err)
@@ -491,7 +1255,7 @@ func (t *DBTable) PrimaryKeys() []string {
 		}
 	case "mysql":
 
-		strSql := fmt.Sprintf("SHOW KEYS FROM %s WHERE Key_name = 'PRIMARY'", t.Name())
+		strSql := fmt.Sprintf("SHOW KEYS FROM %s WHERE Key_name = 'PRIMARY'", t.quotedName())
 		rows, _, err := QueryRecord(t.Db, strSql, nil)
 		if err != nil {
 			log.Panic(err)
@@ -503,7 +1267,7 @@ func (t *DBTable) PrimaryKeys() []string {
 		log.Panic(fmt.Errorf("not impl"))
 	}
 	for i, v := range result {
-		result[i] = strings.ToUpper(v)
+		result[i] = FetchedName(t.Db.DriverName(), v)
 	}
 	t.primaryKeys = result
 	return result
@@ -519,6 +1283,28 @@ func (t *DBTable) Columns() (result []string) {
 	}
 	return t.columnsNames
 }
+
+//返回计算字段
+func (t *DBTable) GeneratedColumns() []string {
+	rev := []string{}
+	for _, v := range t.AllField() {
+		if len(v.Generated) > 0 {
+			rev = append(rev, v.Name)
+		}
+	}
+	return rev
+}
+
+//返回自增字段
+func (t *DBTable) IdentityColumns() []string {
+	rev := []string{}
+	for _, v := range t.AllField() {
+		if v.Identity {
+			rev = append(rev, v.Name)
+		}
+	}
+	return rev
+}
 func (t *DBTable) NotNullColumns() []string {
 	if t.notnullColumns == nil {
 		t.notnullColumns = []string{}
@@ -555,7 +1341,7 @@ func (t *DBTable) Row(pks ...interface{}) map[string]interface{} {
 func (t *DBTable) ToJsonRow(row map[string]interface{}) (map[string]interface{}, error) {
 	transRecord := map[string]interface{}{}
 	for k, v := range row {
-		k = strings.ToUpper(k)
+		k = t.keyName(k)
 		if field := t.Field(k); field != nil {
 			if sv, err := field.ToJson(v); err != nil {
 				return nil, err
@@ -574,7 +1360,7 @@ func (t *DBTable) FromJsonRow(row map[string]interface{}) (map[string]interface{
 	transRecord := map[string]interface{}{}
 
 	for k, v := range row {
-		k = strings.ToUpper(k)
+		k = t.keyName(k)
 		if field := t.Field(k); field != nil {
 			if sv, err := field.FromJson(v); err != nil {
 				return nil, err
@@ -589,11 +1375,11 @@ func (t *DBTable) FromJsonRow(row map[string]interface{}) (map[string]interface{
 }
 
 //将一行数据转换成实际的数据类型，根据字段名从表中查出类型
-//同时将字段名转换成大写
+//同时将字段名转换成大写，区分大小写的字段名除外
 func (t *DBTable) ConvertToTrueType(row map[string]interface{}) map[string]interface{} {
 	transRecord := map[string]interface{}{}
 	for k, v := range row {
-		k = strings.ToUpper(k)
+		k = t.keyName(k)
 		if field := t.Field(k); field != nil {
 			transRecord[k] = field.ConvertToTrueType(v)
 		} else {
@@ -617,13 +1403,13 @@ func (t *DBTable) QueryRowsOrder(where string, param map[string]interface{}, ord
 	}
 	columnsStr := "*"
 	if len(columns) > 0 {
-		columnsStr = strings.Join(columns, ",")
+		columnsStr = strings.Join(quoteNames(t.Db.DriverName(), columns), ",")
 	}
 	str_orderby := ""
 	if len(orderby) > 0 {
-		str_orderby = " order by " + strings.Join(orderby, ",")
+		str_orderby = " order by " + strings.Join(quoteNames(t.Db.DriverName(), orderby), ",")
 	}
-	strSql := fmt.Sprintf("select %s from %s%s%s", columnsStr, t.Name(), where, str_orderby)
+	strSql := fmt.Sprintf("select %s from %s%s%s", columnsStr, t.quotedName(), where, str_orderby)
 	var rows *sqlx.Rows
 	rows, err = t.Db.NamedQuery(strSql, param)
 	if err != nil {
@@ -661,7 +1447,7 @@ func (t *DBTable) Exists(query map[string]interface{}) (result bool, err error)
 	for k, v := range query {
 		pname := fmt.Sprintf("p%d", icount)
 		icount++
-		strWhere = append(strWhere, fmt.Sprintf("%s=:%s", k, pname))
+		strWhere = append(strWhere, fmt.Sprintf("%s=:%s", t.quote(k), pname))
 		newQuery[pname] = v
 	}
 	where := ""
@@ -669,7 +1455,7 @@ func (t *DBTable) Exists(query map[string]interface{}) (result bool, err error)
 		where = " where " + strings.Join(strWhere, " and ")
 	}
 	var rows *sqlx.Rows
-	strSql := fmt.Sprintf("select 1 from %s%s", t.Name(), where)
+	strSql := fmt.Sprintf("select 1 from %s%s", t.quotedName(), where)
 	rows, err = t.Db.NamedQuery(strSql, newQuery)
 	if err != nil {
 		err = SqlError{strSql, newQuery, err}
@@ -698,10 +1484,10 @@ func (t *DBTable) MustCount(params ...interface{}) int64 {
 func (t *DBTable) Count(params ...interface{}) (int64, error) {
 	var strSql string
 	var pam map[string]interface{}
-	strSql = "select count(*) from " + t.Name()
+	strSql = "select count(*) from " + t.quotedName()
 	if len(params) > 0 && len(strings.TrimSpace(params[0].(string))) > 0 {
 
-		strSql = fmt.Sprintf("select count(*) from %s where %s", t.Name(), params[0].(string))
+		strSql = fmt.Sprintf("select count(*) from %s where %s", t.quotedName(), params[0].(string))
 	}
 	if len(params) > 1 {
 		pam = params[1].(map[string]interface{})
@@ -726,7 +1512,7 @@ func (t *DBTable) Rows(query map[string]interface{}, columns ...string) (record
 		pname := fmt.Sprintf("p%d", icount)
 		icount++
 
-		strWhere = append(strWhere, fmt.Sprintf("%s=:%s", k, pname))
+		strWhere = append(strWhere, fmt.Sprintf("%s=:%s", t.quote(k), pname))
 		newQuery[pname] = v
 	}
 	return t.QueryRows(strings.Join(strWhere, " and "), newQuery, columns...)
@@ -741,14 +1527,65 @@ func (t *DBTable) checkNotNull(row map[string]interface{}) error {
 }
 
 //检查row中是否含有非空字段的值，以及去掉多余的字段值
-//如果是oracle，则需要去除时间中的时区，以免触发ORA-01878错误
+//如果是oracle，则需要去除不带时区字段值中的时区，以免触发ORA-01878错误，布尔值也要转换成0、1
+//json字段的值统一编码成文本，如果已经是编码好的文本，需要传入json.RawMessage
+//自增字段没有值则去掉，由数据库生成，有枚举值的字段检查值是否合法，计算字段不能写入，也去掉
 func (t *DBTable) checkAndConvertRow(row map[string]interface{}) (map[string]interface{}, error) {
 	rev := mapfun.Pick(row, t.Columns()...)
+	for _, k := range t.GeneratedColumns() {
+		delete(rev, k)
+	}
+	for _, k := range t.IdentityColumns() {
+		if v, ok := rev[k]; ok && v == nil {
+			delete(rev, k)
+		}
+	}
+	for k, v := range rev {
+		if fld := t.Field(k); !fld.inEnum(v) {
+			return nil, fmt.Errorf("the column %s value %v not in %v", k, v, fld.Enum)
+		}
+	}
+	//json字段的字符串是已经编码的json文本，和ConvertToTrueType解码的输入一致，空字符串当null处理，
+	//其他的go值编码后保存
+	for k, v := range rev {
+		if v == nil || t.Field(k).GoType() != TypeJson {
+			continue
+		}
+		switch tv := v.(type) {
+		case string:
+			if len(tv) == 0 {
+				rev[k] = nil
+			} else if !json.Valid([]byte(tv)) {
+				return nil, fmt.Errorf("the column %s value %v not is json", k, v)
+			}
+		case []byte:
+			if len(tv) == 0 {
+				rev[k] = nil
+			} else if !json.Valid(tv) {
+				return nil, fmt.Errorf("the column %s value %v not is json", k, v)
+			} else {
+				rev[k] = string(tv)
+			}
+		default:
+			bys, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("the column %s value %v encode json error:%s", k, v, err)
+			}
+			rev[k] = string(bys)
+		}
+	}
 	if t.Db.DriverName() == "oci8" {
 		for k, v := range rev {
-			if t.Field(k).Type == "DATE" && v != nil {
+			if t.Field(k).GoType() == TypeDatetime && t.Field(k).Type != "TIMESTAMPTZ" && v != nil {
 				rev[k] = safe.TruncateTimeZone(safe.Date(v))
 			}
+			if tv, ok := v.(bool); ok && t.Field(k).Type == "BOOL" {
+				if tv {
+					rev[k] = int64(1)
+				} else {
+					rev[k] = int64(0)
+				}
+			}
 		}
 	}
 	if err := t.checkNotNull(rev); err != nil {
@@ -831,7 +1668,7 @@ func (t *DBTable) CreateAs(dataDB DB, strSql string,
 	//再构造insert语句
 	insertSql := fmt.Sprintf(
 		"insert into %s(%s)values(%s)",
-		t.Name(), strings.Join(cols, ","),
+		t.quotedName(), strings.Join(quoteNames(t.Db.DriverName(), cols), ","),
 		strings.Join(strings.Split(strings.Repeat("?", len(cols)), ""), ","))
 	insertSql = t.Db.Rebind(insertSql)
 	//再开始事务
@@ -915,15 +1752,17 @@ func (t *DBTable) CreateAs(dataDB DB, strSql string,
 
 }
 
-//生成一个InsertStmt
+//生成一个InsertStmt，计算字段除外
 func (t *DBTable) InsertStmt() (stmt *sqlx.NamedStmt, colMap map[string]string, err error) {
 	columns := []string{}
 	pColumns := []string{}
 	colMap = map[string]string{}
 	icount := 0
 	for _, field := range t.Columns() {
-		//字段名转换成大写
-		columns = append(columns, field)
+		if len(t.Field(field).Generated) > 0 {
+			continue
+		}
+		columns = append(columns, t.quote(field))
 		pname := fmt.Sprintf("p%d", icount)
 		icount++
 		pColumns = append(pColumns, ":"+pname)
@@ -931,7 +1770,7 @@ func (t *DBTable) InsertStmt() (stmt *sqlx.NamedStmt, colMap map[string]string,
 	}
 	strSql := fmt.Sprintf(
 		"insert into %s(%s)values(%s)",
-		t.Name(), strings.Join(columns, ","),
+		t.quotedName(), strings.Join(columns, ","),
 		strings.Join(pColumns, ","))
 	if stmt, err = t.Db.PrepareNamed(strSql); err != nil {
 		err = SqlError{strSql, nil, err}
@@ -941,29 +1780,113 @@ func (t *DBTable) InsertStmt() (stmt *sqlx.NamedStmt, colMap map[string]string,
 
 //仅非空字段生成语句
 func (t *DBTable) insertAsPack(row map[string]interface{}) (err error) {
+	strSql, param := t.insertPackSql(row)
+	if _, err = t.Db.NamedExec(strSql, param); err != nil {
+		return SqlError{strSql, param, err}
+	}
+	return
+}
+
+//生成仅含非空字段的insert语句及参数
+func (t *DBTable) insertPackSql(row map[string]interface{}) (strSql string, param map[string]interface{}) {
 	columns := []string{}
 	pColumns := []string{}
 	icount := 0
-	param := map[string]interface{}{}
+	param = map[string]interface{}{}
 	mapfun.Pack(row)
 	for k, v := range row {
-		//字段名转换成大写
-		columns = append(columns, strings.ToUpper(k))
+		columns = append(columns, t.quote(t.keyName(k)))
 		pname := fmt.Sprintf("p%d", icount)
 		param[pname] = v
 		icount++
 		pColumns = append(pColumns, ":"+pname)
 	}
-	strSql := fmt.Sprintf(
+	strSql = fmt.Sprintf(
 		"insert into %s(%s)values(%s)",
-		t.Name(), strings.Join(columns, ","),
+		t.quotedName(), strings.Join(columns, ","),
 		strings.Join(pColumns, ","))
-	if _, err = t.Db.NamedExec(strSql, param); err != nil {
-		return SqlError{strSql, param, err}
-	}
 	return
 }
 
+//插入一条记录，并取回数据库生成的自增字段值，返回的是填入了自增字段值的新记录
+//postgres用returning，oracle用returning into，mysql和sqlite3用LastInsertId，后两者只支持一个自增字段
+func (t *DBTable) InsertReturning(row map[string]interface{}) (map[string]interface{}, error) {
+	one, err := t.checkAndConvertRow(row)
+	if err != nil {
+		return nil, err
+	}
+	rev := map[string]interface{}{}
+	for k, v := range row {
+		rev[k] = v
+	}
+	//没有给出值的自增字段才需要取回
+	ids := []string{}
+	for _, name := range t.IdentityColumns() {
+		if _, ok := one[name]; !ok {
+			ids = append(ids, name)
+		}
+	}
+	if len(ids) == 0 {
+		return rev, t.insertAsPack(one)
+	}
+	strSql, param := t.insertPackSql(one)
+	values := map[string]interface{}{}
+	switch t.Db.DriverName() {
+	case "postgres":
+		strSql += " returning " + strings.Join(quoteNames(t.Db.DriverName(), ids), ",")
+		rows, err := t.Db.NamedQuery(strSql, param)
+		if err != nil {
+			return nil, SqlError{strSql, param, err}
+		}
+		defer rows.Close()
+		if !rows.Next() {
+			return nil, SqlError{strSql, param, sql.ErrNoRows}
+		}
+		if err = rows.MapScan(values); err != nil {
+			return nil, SqlError{strSql, param, err}
+		}
+		row := make(map[string]interface{}, len(values))
+		for k, v := range values {
+			row[t.keyName(k)] = v
+		}
+		values = row
+	case "oci8":
+		outs := []string{}
+		dests := make([]int64, len(ids))
+		for i := range ids {
+			pname := fmt.Sprintf("r%d", i)
+			outs = append(outs, ":"+pname)
+			param[pname] = sql.Out{Dest: &dests[i]}
+		}
+		strSql += fmt.Sprintf(" returning %s into %s", strings.Join(quoteNames(t.Db.DriverName(), ids), ","), strings.Join(outs, ","))
+		if _, err := t.Db.NamedExec(strSql, param); err != nil {
+			return nil, SqlError{strSql, param, err}
+		}
+		for i, name := range ids {
+			values[name] = dests[i]
+		}
+	case "mysql", "sqlite3":
+		if len(ids) > 1 {
+			return nil, fmt.Errorf("table %s has more than one identity column:%v", t.Name(), ids)
+		}
+		r, err := t.Db.NamedExec(strSql, param)
+		if err != nil {
+			return nil, SqlError{strSql, param, err}
+		}
+		id, err := r.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		values[ids[0]] = id
+	default:
+		log.Panic("not impl " + t.Db.DriverName())
+	}
+	for _, name := range ids {
+		rev[name] = t.Field(name).ConvertToTrueType(values[name])
+	}
+	return rev, nil
+}
+
 //编码key值，如果是复合主键，则用gob序列化
 func (t *DBTable) EncodeKey(keys ...interface{}) []byte {
 	if len(keys) == 1 {
@@ -1030,8 +1953,7 @@ func (t *DBTable) Insert(rows []map[string]interface{}) (err error) {
 	pColumnMap := map[string]string{}
 	icount := 0
 	for k, _ := range data[0] {
-		//字段名转换成大写
-		columns = append(columns, strings.ToUpper(k))
+		columns = append(columns, t.quote(k))
 		pname := fmt.Sprintf("p%d", icount)
 		icount++
 		pColumns = append(pColumns, ":"+pname)
@@ -1039,7 +1961,7 @@ func (t *DBTable) Insert(rows []map[string]interface{}) (err error) {
 	}
 	strSql := fmt.Sprintf(
 		"insert into %s(%s)values(%s)",
-		t.Name(), strings.Join(columns, ","),
+		t.quotedName(), strings.Join(columns, ","),
 		strings.Join(pColumns, ","))
 	if stmt, err = t.Db.PrepareNamed(strSql); err != nil {
 		err = SqlError{strSql, nil, err}
@@ -1049,7 +1971,7 @@ func (t *DBTable) Insert(rows []map[string]interface{}) (err error) {
 	for _, one := range data {
 		newData := map[string]interface{}{}
 		for k, v := range one {
-			newData[pColumnMap[strings.ToUpper(k)]] = v
+			newData[pColumnMap[k]] = v
 		}
 		if _, err = stmt.Exec(newData); err != nil {
 			return
@@ -1079,10 +2001,10 @@ func (t *DBTable) RemoveByQuery(query map[string]interface{}) (err error) {
 	where := []string{}
 	for keyName, keyValue := range query {
 		pname := fmt.Sprintf("p%d", pcount)
-		where = append(where, fmt.Sprintf("%s=:%s", keyName, pname))
+		where = append(where, fmt.Sprintf("%s=:%s", t.quote(keyName), pname))
 		param[pname] = keyValue
 	}
-	strSql := fmt.Sprintf("delete from %s where %s", t.Name(), strings.Join(where, " and "))
+	strSql := fmt.Sprintf("delete from %s where %s", t.quotedName(), strings.Join(where, " and "))
 
 	var sqlr sql.Result
 	if sqlr, err = t.Db.NamedExec(strSql, param); err != nil {
@@ -1112,19 +2034,19 @@ func (t *DBTable) Remove(row map[string]interface{}) (err error) {
 	for k, v := range row {
 		icount++
 		pname := fmt.Sprintf("p%d", icount)
-		//如果是没有长度的string，即text，以及bytea则不参与where条件
-		if fld := t.Field(k); fld.GoType() != TypeBytea && (fld.GoType() != TypeString || fld.MaxLength > 0) {
+		//如果是没有长度的string，即text，以及bytea、json则不参与where条件
+		if fld := t.Field(k); fld.GoType() != TypeBytea && fld.GoType() != TypeJson && (fld.GoType() != TypeString || fld.MaxLength > 0) {
 
 			if v == nil {
-				strWhere = append(strWhere, fmt.Sprintf("%s is null", k))
+				strWhere = append(strWhere, fmt.Sprintf("%s is null", t.quote(k)))
 			} else {
-				strWhere = append(strWhere, fmt.Sprintf("%s=:%s", k, pname))
+				strWhere = append(strWhere, fmt.Sprintf("%s=:%s", t.quote(k), pname))
 				newRow[pname] = v
 			}
 		}
 	}
 	strSql := fmt.Sprintf(
-		"delete from %s where %s", t.Name(), strings.Join(strWhere, " and "))
+		"delete from %s where %s", t.quotedName(), strings.Join(strWhere, " and "))
 	var sqlr sql.Result
 	if sqlr, err = t.Db.NamedExec(strSql, newRow); err != nil {
 		err = SqlError{strSql, newRow, err}
@@ -1164,10 +2086,10 @@ func (t *DBTable) UpdateByQuery(query map[string]interface{}, row map[string]int
 	where := []string{}
 	for k, v := range query {
 		if v == nil {
-			where = append(where, fmt.Sprintf("%s is null", k))
+			where = append(where, fmt.Sprintf("%s is null", t.quote(k)))
 		} else {
 			pname := fmt.Sprintf("p%d", pcount)
-			where = append(where, fmt.Sprintf("%s=:%s", k, pname))
+			where = append(where, fmt.Sprintf("%s=:%s", t.quote(k), pname))
 			param[pname] = v
 			pcount++
 		}
@@ -1175,7 +2097,7 @@ func (t *DBTable) UpdateByQuery(query map[string]interface{}, row map[string]int
 	set := []string{}
 	for k, v := range row {
 		pname := fmt.Sprintf("p%d", pcount)
-		set = append(set, fmt.Sprintf("%s=:%s", k, pname))
+		set = append(set, fmt.Sprintf("%s=:%s", t.quote(k), pname))
 		param[pname] = v
 		pcount++
 	}
@@ -1184,7 +2106,7 @@ func (t *DBTable) UpdateByQuery(query map[string]interface{}, row map[string]int
 		whereStr = "where " + strings.Join(where, " and ")
 	}
 	strSql := fmt.Sprintf("update %s set %s %s",
-		t.Name(), strings.Join(set, ","), whereStr)
+		t.quotedName(), strings.Join(set, ","), whereStr)
 	var sqlr sql.Result
 	var rowAffe int64
 	if sqlr, err = t.Db.NamedExec(strSql, param); err != nil {
@@ -1226,18 +2148,19 @@ func (t *DBTable) Update(oldData, newData map[string]interface{}) (err error) {
 	for k, v := range oldData {
 		pname := fmt.Sprintf("p%d", icount)
 		icount++
-		//如果是没有长度的string，即text，以及bytea、datetime则不参与where条件
+		//如果是没有长度的string，即text，以及bytea、json、datetime则不参与where条件
 		//datetime由于有时区和精度的问题，参与的话会比较复杂
-		if fld := t.Field(k); fld.GoType() != TypeDatetime && fld.GoType() != TypeBytea && (fld.GoType() != TypeString || fld.MaxLength > 0) {
+		if fld := t.Field(k); fld.GoType() != TypeDatetime && fld.GoType() != TypeBytea && fld.GoType() != TypeJson &&
+			(fld.GoType() != TypeString || fld.MaxLength > 0) {
 			if v == nil {
-				where = append(where, fmt.Sprintf("%s is null", k))
+				where = append(where, fmt.Sprintf("%s is null", t.quote(k)))
 			} else {
-				where = append(where, fmt.Sprintf("%s=:%s_o", k, pname))
+				where = append(where, fmt.Sprintf("%s=:%s_o", t.quote(k), pname))
 				param[pname+"_o"] = v
 			}
 		}
 		if !reflect.DeepEqual(v, newData[k]) {
-			set = append(set, fmt.Sprintf("%s=:%s", k, pname))
+			set = append(set, fmt.Sprintf("%s=:%s", t.quote(k), pname))
 			param[pname] = newData[k]
 		}
 
@@ -1248,7 +2171,7 @@ func (t *DBTable) Update(oldData, newData map[string]interface{}) (err error) {
 	}
 	var sqlr sql.Result
 	var rowAffe int64
-	strSql := fmt.Sprintf("update %s set %s where %s", t.Name(),
+	strSql := fmt.Sprintf("update %s set %s where %s", t.quotedName(),
 		strings.Join(set, ","), strings.Join(where, " and "))
 	if sqlr, err = t.Db.NamedExec(strSql, param); err != nil {
 		err = SqlError{strSql, param, err}
@@ -1289,9 +2212,9 @@ func (t *DBTable) Save(row map[string]interface{}) error {
 		icount++
 		//非主键才更新
 		if _, ok := keyIndex[k]; !ok {
-			set = append(set, fmt.Sprintf("%s=:%s", k, pname))
+			set = append(set, fmt.Sprintf("%s=:%s", t.quote(k), pname))
 		} else {
-			where = append(where, fmt.Sprintf("%s=:%s", k, pname))
+			where = append(where, fmt.Sprintf("%s=:%s", t.quote(k), pname))
 		}
 	}
 	//没有字段被更新，则说明是仅有主键字段，则需要进行exits检查
@@ -1307,7 +2230,7 @@ func (t *DBTable) Save(row map[string]interface{}) error {
 	//先更新
 	var sqlr sql.Result
 	var rowAffe int64
-	strSql := fmt.Sprintf("update %s set %s where %s", t.Name(),
+	strSql := fmt.Sprintf("update %s set %s where %s", t.quotedName(),
 		strings.Join(set, ","), strings.Join(where, " and "))
 	if sqlr, err = t.Db.NamedExec(strSql, param); err != nil {
 
@@ -1346,13 +2269,7 @@ func (t *DBTable) Replace(oldRows, newRows []map[string]interface{}) (err error)
 }
 
 func (t *DBTable) FetchColumns() {
-	type columnIndex struct {
-		Owner      string `db:"INDEXOWNER"`
-		IndexName  string `db:"INDEXNAME"`
-		ColumnName string `db:"COLUMNNAME"`
-	}
 	columns := []*DBTableColumn{}
-	indexColumns := []*columnIndex{}
 	var schema string
 	switch t.Db.DriverName() {
 	case "postgres":
@@ -1361,21 +2278,43 @@ func (t *DBTable) FetchColumns() {
 		} else {
 			schema = safe.String(MustGetSqlFun(t.Db, "select upper(current_schema())", nil))
 		}
-		strSql := fmt.Sprintf(`select upper(column_name) as "DBNAME",
+		//DATE和TIMESTAMP都是timestamp without time zone，不能区分，按原有的DATE返回，ToJson的格式不变
+		strSql := fmt.Sprintf(`select column_name as "DBNAME",
 					(case when is_nullable='YES' then true else false end) as "DBNULL",
 					(case when data_type in ('text', 'character varying')
 						then 'STR'
 						when  data_type in ('integer','bigint')
 						then 'INT'
-						when data_type in ('timestamp with time zone', 'timestamp without time zone')
+						when data_type = 'timestamp with time zone'
+						then 'TIMESTAMPTZ'
+						when data_type = 'timestamp without time zone'
 						then 'DATE'
-						when data_type in('numeric','double precision','real')
+						when data_type = 'date'
+						then 'DATEONLY'
+						when data_type = 'boolean'
+						then 'BOOL'
+						when data_type in ('json', 'jsonb')
+						then 'JSON'
+						when data_type = 'numeric'
+						then 'DEC'
+						when data_type in('double precision','real')
 						then 'FLOAT'
 						when data_type ='bytea'
 						then 'BYTEA'
 						else data_type
 					end) as "DBTYPE",
 					(case when character_maximum_length is null then 0 else character_maximum_length end) as "DBMAXLENGTH",
+					(case when data_type = 'numeric' then coalesce(numeric_precision,0) else 0 end) as "DBPRECISION",
+					(case when data_type = 'numeric' then coalesce(numeric_scale,0) else 0 end) as "DBSCALE",
+					(case when is_identity='YES' or coalesce(column_default,'') like 'nextval(%%'
+						then true else false end) as "DBIDENTITY",
+					(case when is_identity<>'YES' and coalesce(column_default,'') like 'nextval(%%'
+						then true else false end) as "DBIMPLICITIDENTITY",
+					(case when coalesce(column_default,'') like 'nextval(%%' then ''
+						else coalesce(column_default,'') end) as "DBDEFAULT",
+					coalesce(col_description((quote_ident(table_schema)||'.'||quote_ident(table_name))::regclass,
+						ordinal_position),'') as "DBCOMMENT",
+					(case when is_generated='ALWAYS' then coalesce(generation_expression,'') else '' end) as "DBGENERATED",
 					(SELECT format_type(a.atttypid, a.atttypmod)
 						FROM pg_attribute a 
 							JOIN pg_class b ON (a.attrelid = b.relfilenode)
@@ -1385,40 +2324,13 @@ func (t *DBTable) FetchColumns() {
 							c.nspname = outa.table_schema AND
 							a.attname = outa.column_name) as "TRUETYPE"
 				from information_schema.columns outa
-				where table_schema ilike '%s' and table_name ilike '%s'`, schema, t.TableName)
+				where table_schema='%s' and table_name='%s'`, storedName("postgres", schema), storedName("postgres", t.TableName))
 		if err := t.Db.Select(&columns, strSql); err != nil {
 			log.Panic(SqlError{strSql, nil, err})
 		}
-		strSql = fmt.Sprintf(`select
-					(select nspname from pg_namespace where oid=i.relnamespace) as "INDEXOWNER",
-					i.relname as "INDEXNAME",
-				    upper(min(a.attname)) as "COLUMNNAME"
-				from
-				    pg_class t,
-				    pg_class i,
-				    pg_index ix,
-				    pg_attribute a,
-				    pg_namespace tn
-				where
-				    t.oid = ix.indrelid
-				    and i.oid = ix.indexrelid
-				    and a.attrelid = t.oid
-				    and t.relnamespace=tn.oid 
-				    and upper(tn.nspname) = '%s'
-				    and a.attnum = ANY(ix.indkey)
-				    and t.relkind = 'r'
-				    and upper(t.relname) =$1
-				group by
-				   t.relname,
-				   i.relnamespace,
-				   i.relname
-				having count(*)=1
-				order by
-				    t.relname,
-				    i.relname;`, schema)
-		if err := t.Db.Select(&indexColumns, strSql, t.TableName); err != nil {
-			log.Panic(SqlError{strSql, t.TableName, err})
-		}
+		t.Comment = safe.String(MustGetSqlFun(t.Db, fmt.Sprintf(`select coalesce(obj_description(c.oid,'pg_class'),'')
+				from pg_class c join pg_namespace n on n.oid=c.relnamespace
+				where n.nspname='%s' and c.relname='%s'`, storedName("postgres", schema), storedName("postgres", t.TableName)), nil))
 	case "oci8":
 		if len(t.Schema) > 0 {
 			schema = t.Schema
@@ -1433,15 +2345,24 @@ func (t *DBTable) FetchColumns() {
 						then 'INT'
 						when data_type ='DATE'
 						then 'DATE'
-						when data_type in('NUMBER','BINARY_DOUBLE')
+						when data_type like 'TIMESTAMP%%TIME ZONE'
+						then 'TIMESTAMPTZ'
+						when data_type like 'TIMESTAMP%%'
+						then 'TIMESTAMP'
+						when data_type ='NUMBER'
+						then 'DEC'
+						when data_type in('BINARY_DOUBLE','BINARY_FLOAT','FLOAT')
 						then 'FLOAT'
 						when data_type ='BLOB'
 						then 'BYTEA'
 						else data_type
 					end) as "DBTYPE",
 					CHAR_LENGTH as "DBMAXLENGTH",
+					(case when data_type ='NUMBER' then nvl(data_precision,0) else 0 end) as "DBPRECISION",
+					(case when data_type ='NUMBER' then nvl(data_scale,0) else 0 end) as "DBSCALE",
 					data_type||
 						case
+						when data_type like 'TIMESTAMP%%' then null
 						when data_precision is not null and nvl(data_scale,0)>0 then '('||data_precision||','||data_scale||')'
 						when data_precision is not null and nvl(data_scale,0)=0 then '('||data_precision||')'
 						when data_precision is null and data_scale is not null then '(*,'||data_scale||')'
@@ -1457,16 +2378,91 @@ func (t *DBTable) FetchColumns() {
 		if err := t.Db.Select(&columns, strSql); err != nil {
 			log.Panic(SqlError{strSql, nil, err})
 		}
-		strSql = fmt.Sprintf(`SELECT min(index_owner) as "INDEXOWNER",
-					index_name as "INDEXNAME",min(column_name) as "COLUMNNAME"
-				from all_ind_columns a
-				where table_owner='%s' and table_name = :name and
-					exists(select 1 from all_indexes b where 
-						a.index_owner=b.owner and a.index_name =b.index_name and 
-						UNIQUENESS ='NONUNIQUE')
-				group by index_name having count(*)=1`, schema)
-		if err := t.Db.Select(&indexColumns, strSql, t.TableName); err != nil {
-			log.Panic(SqlError{strSql, t.TableName, err})
+		//默认值是long类型，单独获取，虚拟字段的表达式也在其中
+		strSql = fmt.Sprintf(`select column_name as "DBNAME",data_default as "DBDEFAULT",virtual_column as "VIRTUAL"
+				from all_tab_cols
+				where owner='%s' and table_name='%s' and hidden_column='NO' and default_length>0`, schema, t.TableName)
+		defaults, _, err := QueryRecord(t.Db, strSql, nil)
+		if err != nil {
+			log.Panic(SqlError{strSql, nil, err})
+		}
+		for _, row := range defaults {
+			for _, c := range columns {
+				if c.Name == safe.String(row["DBNAME"]) {
+					if safe.String(row["VIRTUAL"]) == "YES" {
+						c.Generated = strings.TrimSpace(safe.String(row["DBDEFAULT"]))
+						break
+					}
+					c.Default = safe.String(row["DBDEFAULT"])
+					//用序列作为默认值的字段，也视同自增字段
+					if strings.HasSuffix(strings.ToUpper(strings.TrimSpace(c.Default)), ".NEXTVAL") {
+						c.Identity = true
+						c.ImplicitIdentity = true
+					}
+				}
+			}
+		}
+		//字段说明
+		strSql = fmt.Sprintf(`select column_name as "DBNAME",comments as "DBCOMMENT" from all_col_comments
+				where owner='%s' and table_name='%s' and comments is not null`, schema, t.TableName)
+		comments, _, err := QueryRecord(t.Db, strSql, nil)
+		if err != nil {
+			log.Panic(SqlError{strSql, nil, err})
+		}
+		for _, row := range comments {
+			for _, c := range columns {
+				if c.Name == safe.String(row["DBNAME"]) {
+					c.Comment = safe.String(row["DBCOMMENT"])
+				}
+			}
+		}
+		t.Comment = safe.String(MustGetSqlFun(t.Db, fmt.Sprintf(`select comments from all_tab_comments
+				where owner='%s' and table_name='%s'`, schema, t.TableName), nil))
+		//12c的identity字段，默认值是系统生成的序列，需要去掉，低版本没有该视图则忽略
+		identityColumns := []string{}
+		strSql = fmt.Sprintf(`select column_name from all_tab_identity_cols
+				where owner='%s' and table_name='%s'`, schema, t.TableName)
+		if err := t.Db.Select(&identityColumns, strSql); err == nil {
+			for _, name := range identityColumns {
+				for _, c := range columns {
+					if c.Name == name {
+						c.Identity = true
+						c.Default = ""
+					}
+				}
+			}
+		}
+		//带有is json约束的clob是json字段，all_json_columns是12c才有的视图，低版本出错则忽略
+		jsonColumns := []string{}
+		strSql = fmt.Sprintf(`select column_name from all_json_columns
+				where owner='%s' and table_name='%s'`, schema, t.TableName)
+		if err := t.Db.Select(&jsonColumns, strSql); err == nil {
+			for _, name := range jsonColumns {
+				for _, c := range columns {
+					if c.Name == name {
+						c.Type = "JSON"
+					}
+				}
+			}
+		}
+		//NUMBER(1)带有字段上的0、1 check约束才是布尔，search_condition是long类型，取出后再判断
+		strSql = fmt.Sprintf(`select cc.column_name as "DBNAME",c.search_condition as "EXPRESS"
+				from all_constraints c join all_cons_columns cc
+					on cc.owner=c.owner and cc.constraint_name=c.constraint_name
+				where c.owner='%s' and c.table_name='%s' and c.constraint_type='C' and c.generated='GENERATED NAME'`,
+			schema, t.TableName)
+		boolChecks, _, err := QueryRecord(t.Db, strSql, nil)
+		if err != nil {
+			log.Panic(SqlError{strSql, nil, err})
+		}
+		for _, row := range boolChecks {
+			for _, c := range columns {
+				if c.Name == safe.String(row["DBNAME"]) && c.Type == "DEC" && c.Precision == 1 && c.Scale == 0 &&
+					oracleBoolCheckReg.MatchString(safe.String(row["EXPRESS"])) {
+					c.Type = "BOOL"
+					c.Precision = 0
+				}
+			}
 		}
 	case "mysql":
 		if len(t.Schema) > 0 {
@@ -1478,12 +2474,30 @@ func (t *DBTable) FetchColumns() {
 					upper(column_name) as DBNAME,
 				    (case when is_nullable='YES' then 1 else 0 end) as DBNULL,
 				    (case when data_type in('varchar','text','char') then 'STR'
-						  when data_type ='int' then 'INT'
-						  when data_type in('decimal','double') then 'FLOAT'
+						  when data_type ='tinyint' and column_type like 'tinyint(1)%%' then 'BOOL'
+						  when data_type ='json' then 'JSON'
+						  when data_type in('int','bigint') then 'INT'
+						  when data_type ='decimal' then 'DEC'
+						  when data_type in('double','float') then 'FLOAT'
 				          when data_type ='blob' then 'BYTEA'
-				          when data_type in('date','datetime') then 'DATE'
+				          when data_type ='date' then 'DATEONLY'
+				          when column_type ='datetime' then 'DATE'
+				          when data_type ='datetime' then 'TIMESTAMP'
+				          when data_type ='timestamp' then 'TIMESTAMPTZ'
 				    end) as DBTYPE,
 				    ifnull(CHARACTER_MAXIMUM_LENGTH,0) as DBMAXLENGTH,
+				    (case when data_type ='decimal' then ifnull(NUMERIC_PRECISION,0) else 0 end) as DBPRECISION,
+				    (case when data_type ='decimal' then ifnull(NUMERIC_SCALE,0) else 0 end) as DBSCALE,
+				    (case when column_default is null then ''
+				          when extra like '%%DEFAULT_GENERATED%%' or upper(column_default) like 'CURRENT_TIMESTAMP%%' then column_default
+				          when data_type in('varchar','text','char','date','datetime','timestamp','json')
+				          then concat('''', replace(column_default,'''',''''''), '''')
+				          else column_default
+				    end) as DBDEFAULT,
+				    (case when extra like '%%auto_increment%%' then 1 else 0 end) as DBIDENTITY,
+				    column_comment as DBCOMMENT,
+				    (case when extra like '%%GENERATED%%' and extra not like '%%DEFAULT_GENERATED%%'
+				          then ifnull(generation_expression,'') else '' end) as DBGENERATED,
 					column_type as TRUETYPE
 				from information_schema.columns 
 				where upper(table_name)=? and upper(table_schema)= '%s'
@@ -1491,165 +2505,693 @@ func (t *DBTable) FetchColumns() {
 		if err := t.Db.Select(&columns, strSql, t.TableName); err != nil {
 			log.Panic(SqlError{strSql, t.TableName, err})
 		}
-		strSql = `SELECT INDEX_SCHEMA AS INDEXOWNER,
-					INDEX_NAME as INDEXNAME,
-					COLUMN_NAME AS COLUMNNAME
-				FROM INFORMATION_SCHEMA.STATISTICS 
-				WHERE upper(table_schema) = '%s' and upper(table_name)=?
-				group by index_name having count(*)=1
-				ORDER BY table_name, index_name, seq_in_index`
-		if err := t.Db.Select(&indexColumns, strSql, t.TableName); err != nil {
-			log.Panic(SqlError{strSql, t.TableName, err})
-		}
+		t.Comment = safe.String(MustGetSqlFun(t.Db, fmt.Sprintf(`select table_comment from information_schema.tables
+				where upper(table_schema)='%s' and upper(table_name)='%s'`, schema, strings.ToUpper(t.TableName)), nil))
 	case "sqlite3":
-		strSql := fmt.Sprintf(`PRAGMA table_info(%s)`, t.TableName)
+		//table_xinfo才包含计算字段，计算字段的表达式只能从建表语句中获取
+		strSql := fmt.Sprintf(`PRAGMA table_xinfo(%s)`, t.quote(t.TableName))
 		result, _, err := QueryRecord(t.Db, strSql, nil)
 		if err != nil {
 			log.Panic(SqlError{strSql, nil, err})
 		}
+		strSql = fmt.Sprintf("select sql from sqlite_master where type='table' and upper(name)=upper('%s')", t.TableName)
+		vSql, err := GetSqlFun(t.Db, strSql, nil)
+		if err != nil {
+			log.Panic(err)
+		}
+		createSql := safe.String(vSql)
+		multiPk := false
 		for _, row := range result {
 			c := &DBTableColumn{
 				Name: safe.String(row["NAME"]),
 			}
 			c.Type, c.MaxLength = sqliteType(safe.String(row["TYPE"]))
+			if c.Type == "DEC" {
+				c.Precision, c.Scale = parseTypePrecision(safe.String(row["TYPE"]))
+			}
 			c.TrueType = safe.String(row["TYPE"])
 			c.Null = safe.Int(row["NOTNULL"]) != 1
+			c.Default = safe.String(row["DFLT_VALUE"])
+			//hidden为2、3的是计算字段，1是虚拟表的隐藏字段
+			switch safe.Int(row["HIDDEN"]) {
+			case 1:
+				continue
+			case 2, 3:
+				c.Generated = sqliteGenerated(createSql, c.Name)
+			}
+			//INTEGER类型的单字段主键是rowid的别名，即自增字段
+			if safe.Int(row["PK"]) > 0 && strings.ToUpper(c.TrueType) == "INTEGER" {
+				c.Identity = true
+				c.ImplicitIdentity = true
+			}
+			if safe.Int(row["PK"]) > 1 {
+				multiPk = true
+			}
 			columns = append(columns, c)
 		}
-		strSql = fmt.Sprintf("PRAGMA index_list(%s)", t.TableName)
-		result, _, err = QueryRecord(t.Db, strSql, nil)
-		if err != nil {
-			log.Panic(SqlError{strSql, t.TableName, err})
-		}
-		for _, row := range result {
-			indexName := safe.String(row["NAME"])
-			//每个索引再去找定义
-			strSql = fmt.Sprintf("PRAGMA index_info(%s)", indexName)
-			indexColumnList, _, err := QueryRecord(t.Db, strSql, nil)
-			if err != nil {
-				log.Panic(SqlError{strSql, nil, err})
-			}
-			//只找出一个字段的索引,并且不是主键索引
-			if len(indexColumnList) == 1 && (len(t.PrimaryKeys()) > 1 ||
-				safe.String(indexColumnList[0]["NAME"]) != t.PrimaryKeys()[0]) {
-				indexColumns = append(indexColumns, &columnIndex{
-					"", indexName, safe.String(indexColumnList[0]["NAME"])})
+		//复合主键不是rowid
+		if multiPk {
+			for _, c := range columns {
+				c.Identity = false
+				c.ImplicitIdentity = false
 			}
 		}
 	default:
 		log.Panic(fmt.Errorf("not impl FetchColumns"))
 	}
-	//注意indexColumns中可能含有非表字段的名称，例如oracle中的function index
-	indexColumnsMap := map[string]*columnIndex{}
-	for _, s := range indexColumns {
-		indexColumnsMap[strings.ToUpper(s.ColumnName)] = s
-	}
-	keyColumnsMap := map[string]bool{}
-	for _, s := range t.PrimaryKeys() {
-		keyColumnsMap[strings.ToUpper(s)] = true
-	}
+	columnsMap := map[string]*DBTableColumn{}
 	for _, v := range columns {
-		v.Name = strings.ToUpper(v.Name)
-		//对于主键，统一不赋予索引标识
-		//if _, ok := keyColumnsMap[v.Name]; ok {
-		//	continue
-		//}
-		//组合主键，有时需要单字段索引
-
-		if s, ok := indexColumnsMap[v.Name]; ok {
-			v.Index = true
-			v.IndexName = s.IndexName
-			if len(t.Schema) > 0 || //如果是其他schema的表，则必定带上schema
-				strings.ToUpper(s.Owner) != schema { //如果index不和表在同一个schema中，也带上schema
-				v.IndexName = s.Owner + "." + v.IndexName
+		v.Name = FetchedName(t.Db.DriverName(), v.Name)
+		columnsMap[v.Name] = v
+	}
+	//单字段的普通索引用字段的Index标识，其他的索引，包括唯一索引、多字段索引、表达式索引以及部分索引，放在Indexes中
+	//主键的索引不获取
+	t.Indexes = nil
+	for _, v := range t.fetchIndexes(schema) {
+		name := v.index.Name
+		if len(t.Schema) > 0 || //如果是其他schema的表，则必定带上schema
+			strings.ToUpper(v.owner) != schema { //如果index不和表在同一个schema中，也带上schema
+			name = v.owner + "." + name
+		}
+		if v.index.single() {
+			if c, ok := columnsMap[v.index.Columns[0]]; ok && !c.Index {
+				c.Index = true
+				c.IndexName = name
+				continue
 			}
 		}
+		v.index.Name = name
+		t.Indexes = append(t.Indexes, v.index)
 	}
-	//保存获取信息时的数据库驱动名称
+	//保存获取信息时的数据库驱动名称，并识别自定义类型
 	for i, _ := range columns {
 		columns[i].FetchDriver = t.Db.DriverName()
+		columns[i].Default = normalizeDefault(columns[i].Default)
+		detectColumnType(t.Db.DriverName(), columns[i])
 	}
 	t.columns = columns
 	t.refreshColumnsMap()
 	t.columnsNames = nil
-}
-func (t *DBTable) refreshColumnsMap() {
-	t.columnsMap = map[string]*DBTableColumn{}
-	for _, col := range t.columns {
-		t.columnsMap[col.Name] = col
-	}
+	t.fetchChecks(schema)
+	t.fetchForeignKeys(schema)
 }
 
-//克隆一个table，复制结构定义
-func (t *DBTable) Clone() *DBTable {
-	result := NewTable(t.Db, t.Name())
-	cols := []*DBTableColumn{}
-	for _, v := range t.AllField() {
-		cols = append(cols, v.Clone())
-	}
-	result.Define(cols, t.PrimaryKeys())
-	return result
-}
-func (t *DBTable) AllField() []*DBTableColumn {
-	if t.columns == nil {
-		t.FetchColumns()
-	}
-	return t.columns
-}
-func sqliteType(typeName string) (string, int) {
-	/*
-		<1> 如果声明类型包含”INT”字符串，那么这个列被赋予INTEGER近似
-		<2> 如果这个列的声明类型包含”CHAR”，”CLOB”，或者”TEXT”中的任意一个，那么这个列就有了TEXT近似。注意类型VARCHAR包含了”CHAR”字符串，那么也就被赋予了TEXT近似
-		<3> 如果列的声明类型中包含了字符串”BLOB”或者没有为其声明类型，这个列被赋予NONE近似
-		<4> 其他的情况，列被赋予NUMERIC近似
-	*/
-	typeName = strings.ToUpper(typeName)
-	if strings.Contains(typeName, "INT") {
-		return "INT", 0
-	}
-	if strings.Contains(typeName, "CHAR") ||
-		strings.Contains(typeName, "CLOB") ||
-		strings.Contains(typeName, "TEXT") {
-		length := "-1"
-		if ts := strings.Split(typeName, "("); len(ts) > 1 {
-			length = ts[1]
-			length = length[:len(length)-1]
+//获取表的外键，引用表和本表不在同一schema的，引用表带上schema
+func (t *DBTable) fetchForeignKeys(schema string) {
+	t.ForeignKeys = nil
+	split := func(v interface{}) []string {
+		rev := []string{}
+		for _, one := range strings.Split(safe.String(v), ",") {
+			if one = strings.TrimSpace(one); len(one) > 0 {
+				rev = append(rev, FetchedName(t.Db.DriverName(), one))
+			}
 		}
-		i, _ := strconv.ParseInt(length, 10, 64)
-		return "STR", int(i)
-	}
-	if strings.Contains(typeName, "BLOB") || strings.Contains(typeName, "BYTEA") ||
-		len(typeName) == 0 {
-		return "BYTEA", 0
-	}
-	if strings.Contains(typeName, "DATE") || strings.Contains(typeName, "TIME") {
-		return "DATE", 0
-	}
-	return "FLOAT", 0
-}
-func (t *DBTable) Field(name string) *DBTableColumn {
-	if len(t.columnsMap) == 0 {
-		t.FetchColumns()
+		return rev
 	}
-	if col, ok := t.columnsMap[name]; ok {
-		return col
-	} else {
-		return nil
+	refTable := func(owner, name string) string {
+		if len(owner) > 0 && (len(t.Schema) > 0 || strings.ToUpper(owner) != schema) {
+			return FetchedName(t.Db.DriverName(), owner) + "." + FetchedName(t.Db.DriverName(), name)
+		}
+		return FetchedName(t.Db.DriverName(), name)
 	}
-}
-
-//采用脚本的方式定义表，如下：
-//  a str(3) not null
-//  b int
-//  c date not null index
-//  primary key(a,c)
+	switch t.Db.DriverName() {
+	case "postgres":
+		strSql := fmt.Sprintf(`select c.conname as "NAME",
+					array_to_string(array(select a.attname from unnest(c.conkey) with ordinality k(n,i)
+						join pg_attribute a on a.attrelid=c.conrelid and a.attnum=k.n order by k.i),',') as "COLUMNS",
+					rn.nspname as "REFOWNER",
+					r.relname as "REFTABLE",
+					array_to_string(array(select a.attname from unnest(c.confkey) with ordinality k(n,i)
+						join pg_attribute a on a.attrelid=c.confrelid and a.attnum=k.n order by k.i),',') as "REFCOLUMNS",
+					(case c.confdeltype when 'c' then 'CASCADE' when 'n' then 'SET NULL'
+						when 'd' then 'SET DEFAULT' when 'r' then 'RESTRICT' else '' end) as "DELRULE",
+					(case c.confupdtype when 'c' then 'CASCADE' when 'n' then 'SET NULL'
+						when 'd' then 'SET DEFAULT' when 'r' then 'RESTRICT' else '' end) as "UPDRULE"
+				from pg_constraint c
+					join pg_class t on t.oid=c.conrelid
+					join pg_namespace tn on tn.oid=t.relnamespace
+					join pg_class r on r.oid=c.confrelid
+					join pg_namespace rn on rn.oid=r.relnamespace
+				where c.contype='f' and tn.nspname='%s' and t.relname='%s'`, storedName("postgres", schema), storedName("postgres", t.TableName))
+		result, _, err := QueryRecord(t.Db, strSql, nil)
+		if err != nil {
+			log.Panic(SqlError{strSql, nil, err})
+		}
+		for _, row := range result {
+			t.ForeignKeys = append(t.ForeignKeys, &DBForeignKey{
+				Name:       safe.String(row["NAME"]),
+				Columns:    split(row["COLUMNS"]),
+				RefTable:   refTable(safe.String(row["REFOWNER"]), safe.String(row["REFTABLE"])),
+				RefColumns: split(row["REFCOLUMNS"]),
+				OnDelete:   safe.String(row["DELRULE"]),
+				OnUpdate:   safe.String(row["UPDRULE"]),
+			})
+		}
+	case "oci8":
+		strSql := fmt.Sprintf(`select c.constraint_name as "NAME",c.delete_rule as "DELRULE",
+					r.owner as "REFOWNER",r.table_name as "REFTABLE",
+					(select listagg(column_name,',') within group (order by position) from all_cons_columns
+						where owner=c.owner and constraint_name=c.constraint_name) as "COLUMNS",
+					(select listagg(column_name,',') within group (order by position) from all_cons_columns
+						where owner=r.owner and constraint_name=r.constraint_name) as "REFCOLUMNS"
+				from all_constraints c
+					join all_constraints r on r.owner=c.r_owner and r.constraint_name=c.r_constraint_name
+				where c.owner='%s' and c.table_name='%s' and c.constraint_type='R'`, schema, t.TableName)
+		result, _, err := QueryRecord(t.Db, strSql, nil)
+		if err != nil {
+			log.Panic(SqlError{strSql, nil, err})
+		}
+		for _, row := range result {
+			t.ForeignKeys = append(t.ForeignKeys, &DBForeignKey{
+				Name:       safe.String(row["NAME"]),
+				Columns:    split(row["COLUMNS"]),
+				RefTable:   refTable(safe.String(row["REFOWNER"]), safe.String(row["REFTABLE"])),
+				RefColumns: split(row["REFCOLUMNS"]),
+				OnDelete:   safe.String(row["DELRULE"]),
+			})
+		}
+	case "mysql":
+		strSql := fmt.Sprintf(`select k.constraint_name as NAME,
+					group_concat(k.column_name order by k.ordinal_position) as COLUMNS,
+					max(k.referenced_table_schema) as REFOWNER,
+					max(k.referenced_table_name) as REFTABLE,
+					group_concat(k.referenced_column_name order by k.ordinal_position) as REFCOLUMNS,
+					max(r.delete_rule) as DELRULE,
+					max(r.update_rule) as UPDRULE
+				from information_schema.key_column_usage k
+					join information_schema.referential_constraints r
+					on r.constraint_schema=k.constraint_schema and r.constraint_name=k.constraint_name
+				where upper(k.table_schema)='%s' and upper(k.table_name)='%s' and k.referenced_table_name is not null
+				group by k.constraint_name`, schema, strings.ToUpper(t.TableName))
+		result, _, err := QueryRecord(t.Db, strSql, nil)
+		if err != nil {
+			log.Panic(SqlError{strSql, nil, err})
+		}
+		for _, row := range result {
+			t.ForeignKeys = append(t.ForeignKeys, &DBForeignKey{
+				Name:       safe.String(row["NAME"]),
+				Columns:    split(row["COLUMNS"]),
+				RefTable:   refTable(safe.String(row["REFOWNER"]), safe.String(row["REFTABLE"])),
+				RefColumns: split(row["REFCOLUMNS"]),
+				OnDelete:   safe.String(row["DELRULE"]),
+				OnUpdate:   safe.String(row["UPDRULE"]),
+			})
+		}
+	case "sqlite3":
+		//sqlite3的外键没有名称，每个字段一行，按id合并，引用主键时to为空
+		strSql := fmt.Sprintf("PRAGMA foreign_key_list(%s)", t.quote(t.TableName))
+		result, _, err := QueryRecord(t.Db, strSql, nil)
+		if err != nil {
+			log.Panic(SqlError{strSql, nil, err})
+		}
+		fks := map[int64]*DBForeignKey{}
+		for _, row := range result {
+			fk, ok := fks[safe.Int(row["ID"])]
+			if !ok {
+				fk = &DBForeignKey{
+					RefTable: FetchedName(t.Db.DriverName(), safe.String(row["TABLE"])),
+					OnDelete: safe.String(row["ON_DELETE"]),
+					OnUpdate: safe.String(row["ON_UPDATE"]),
+				}
+				fks[safe.Int(row["ID"])] = fk
+				t.ForeignKeys = append(t.ForeignKeys, fk)
+			}
+			fk.Columns = append(fk.Columns, FetchedName(t.Db.DriverName(), safe.String(row["FROM"])))
+			if to := safe.String(row["TO"]); len(to) > 0 {
+				fk.RefColumns = append(fk.RefColumns, FetchedName(t.Db.DriverName(), to))
+			}
+		}
+	default:
+		log.Panic(fmt.Errorf("not impl fetchForeignKeys"))
+	}
+}
+
+//获取表的check约束，名称符合字段枚举约束的，解析出值列表放入字段中
+//oracle中not null以及系统命名的约束都不获取，mysql 8.0.16以下没有check约束，出错则忽略
+func (t *DBTable) fetchChecks(schema string) {
+	checks := []*DBCheck{}
+	switch t.Db.DriverName() {
+	case "postgres":
+		strSql := fmt.Sprintf(`select c.conname as "NAME",pg_get_constraintdef(c.oid) as "EXPRESS"
+				from pg_constraint c
+					join pg_class t on t.oid=c.conrelid
+					join pg_namespace n on n.oid=t.relnamespace
+				where c.contype='c' and n.nspname='%s' and t.relname='%s'`, storedName("postgres", schema), storedName("postgres", t.TableName))
+		result, _, err := QueryRecord(t.Db, strSql, nil)
+		if err != nil {
+			log.Panic(SqlError{strSql, nil, err})
+		}
+		for _, row := range result {
+			express := strings.TrimSpace(safe.String(row["EXPRESS"]))
+			express = strings.TrimSuffix(strings.TrimPrefix(express, "CHECK "), " NOT VALID")
+			checks = append(checks, &DBCheck{safe.String(row["NAME"]), express})
+		}
+	case "oci8":
+		strSql := fmt.Sprintf(`select constraint_name as "NAME",search_condition as "EXPRESS"
+				from all_constraints
+				where owner='%s' and table_name='%s' and constraint_type='C' and generated='USER NAME'`, schema, t.TableName)
+		result, _, err := QueryRecord(t.Db, strSql, nil)
+		if err != nil {
+			log.Panic(SqlError{strSql, nil, err})
+		}
+		for _, row := range result {
+			checks = append(checks, &DBCheck{safe.String(row["NAME"]), safe.String(row["EXPRESS"])})
+		}
+	case "mysql":
+		strSql := fmt.Sprintf(`select tc.constraint_name as NAME,cc.check_clause as EXPRESS
+				from information_schema.table_constraints tc
+					join information_schema.check_constraints cc
+					on cc.constraint_schema=tc.constraint_schema and cc.constraint_name=tc.constraint_name
+				where tc.constraint_type='CHECK' and upper(tc.table_schema)='%s' and upper(tc.table_name)='%s'`,
+			schema, strings.ToUpper(t.TableName))
+		if result, _, err := QueryRecord(t.Db, strSql, nil); err == nil {
+			for _, row := range result {
+				checks = append(checks, &DBCheck{safe.String(row["NAME"]), safe.String(row["EXPRESS"])})
+			}
+		}
+	case "sqlite3":
+		//sqlite3只能从建表语句中解析
+		strSql := fmt.Sprintf("select sql from sqlite_master where type='table' and upper(name)=upper('%s')", t.TableName)
+		result, _, err := QueryRecord(t.Db, strSql, nil)
+		if err != nil {
+			log.Panic(SqlError{strSql, nil, err})
+		}
+		if len(result) > 0 {
+			src := safe.String(result[0]["SQL"])
+			for _, idx := range sqliteCheckReg.FindAllStringSubmatchIndex(src, -1) {
+				if express, _, err := scriptGroup(src[idx[1]-1:]); err == nil {
+					checks = append(checks, &DBCheck{src[idx[2]:idx[3]], strings.TrimSpace(express[1 : len(express)-1])})
+				}
+			}
+		}
+	default:
+		log.Panic(fmt.Errorf("not impl fetchChecks"))
+	}
+	t.Checks = nil
+	for _, v := range t.columns {
+		v.Enum = nil
+	}
+	for _, v := range checks {
+		//去掉外层的括号
+		for len(v.Express) > 1 && v.Express[0] == '(' && wrappedExpress(v.Express) {
+			v.Express = strings.TrimSpace(v.Express[1 : len(v.Express)-1])
+		}
+		isEnum := false
+		for _, c := range t.columns {
+			if strings.EqualFold(v.Name, t.enumCheckName(c.Name)) {
+				c.Enum = parseEnumValues(v.Express, c.Name)
+				isEnum = true
+				break
+			}
+		}
+		if !isEnum {
+			t.Checks = append(t.Checks, v)
+		}
+	}
+}
+
+//获取到的索引，owner是索引所在的schema
+type fetchedIndex struct {
+	owner string
+	index *DBIndex
+}
+
+//获取表的全部索引，主键和唯一约束的索引除外，这些索引随约束删除，不能单独删除
+func (t *DBTable) fetchIndexes(schema string) []*fetchedIndex {
+	rev := []*fetchedIndex{}
+	//oracle和mysql每个字段一行，需要按索引合并
+	appendColumn := func(owner, name string, unique bool, column string, express bool) {
+		var idx *DBIndex
+		if n := len(rev); n > 0 && rev[n-1].owner == owner && rev[n-1].index.Name == name {
+			idx = rev[n-1].index
+		} else {
+			idx = &DBIndex{Name: name, Unique: unique}
+			rev = append(rev, &fetchedIndex{owner, idx})
+		}
+		//有一个是表达式，则整个索引都作为表达式
+		if express && len(idx.Express) == 0 {
+			idx.Express = strings.Join(idx.Columns, ", ")
+			idx.Columns = nil
+		}
+		if len(idx.Express) > 0 || express {
+			if len(idx.Express) > 0 {
+				idx.Express += ", "
+			}
+			idx.Express += column
+		} else {
+			idx.Columns = append(idx.Columns, FetchedName(t.Db.DriverName(), column))
+		}
+	}
+	switch t.Db.DriverName() {
+	case "postgres":
+		strSql := fmt.Sprintf(`select
+					(select nspname from pg_namespace where oid=i.relnamespace) as "OWNER",
+					i.relname as "NAME",
+					(case when ix.indisunique then 1 else 0 end) as "ISUNIQUE",
+					(case when 0 = any(ix.indkey) then 1 else 0 end) as "ISEXPRESS",
+					pg_get_indexdef(ix.indexrelid) as "DEFINE",
+					coalesce(pg_get_expr(ix.indpred,ix.indrelid),'') as "WHERE",
+					array_to_string(array(select a.attname from pg_attribute a
+						where a.attrelid=ix.indrelid and a.attnum = any(ix.indkey)
+						order by array_position(ix.indkey::int2[],a.attnum)),',') as "COLUMNS"
+				from pg_index ix
+					join pg_class i on i.oid=ix.indexrelid
+					join pg_class t on t.oid=ix.indrelid
+					join pg_namespace tn on tn.oid=t.relnamespace
+				where not ix.indisprimary and tn.nspname='%s' and t.relname='%s' and
+					not exists(select 1 from pg_constraint c where c.conrelid=ix.indrelid and
+						c.conindid=ix.indexrelid and c.contype in ('p','u','x'))
+				order by i.relname`, storedName("postgres", schema), storedName("postgres", t.TableName))
+		result, _, err := QueryRecord(t.Db, strSql, nil)
+		if err != nil {
+			log.Panic(SqlError{strSql, nil, err})
+		}
+		for _, row := range result {
+			idx := &DBIndex{
+				Name:   safe.String(row["NAME"]),
+				Unique: safe.Int(row["ISUNIQUE"]) == 1,
+				Where:  safe.String(row["WHERE"]),
+			}
+			if safe.Int(row["ISEXPRESS"]) == 1 {
+				idx.Express, _ = parseIndexDefine(safe.String(row["DEFINE"]))
+			} else {
+				for _, col := range strings.Split(safe.String(row["COLUMNS"]), ",") {
+					idx.Columns = append(idx.Columns, FetchedName(t.Db.DriverName(), col))
+				}
+			}
+			rev = append(rev, &fetchedIndex{safe.String(row["OWNER"]), idx})
+		}
+	case "oci8":
+		//函数索引的表达式是long类型，单独获取
+		strSql := fmt.Sprintf(`select index_owner as "OWNER",index_name as "NAME",
+					column_position as "POSITION",column_expression as "EXPRESS"
+				from all_ind_expressions
+				where table_owner='%s' and table_name='%s'`, schema, t.TableName)
+		result, _, err := QueryRecord(t.Db, strSql, nil)
+		if err != nil {
+			log.Panic(SqlError{strSql, nil, err})
+		}
+		expresses := map[string]string{}
+		for _, row := range result {
+			expresses[fmt.Sprintf("%s.%s.%d", row["OWNER"], row["NAME"], safe.Int(row["POSITION"]))] =
+				strings.TrimSpace(safe.String(row["EXPRESS"]))
+		}
+		strSql = fmt.Sprintf(`select i.owner as "OWNER",i.index_name as "NAME",
+					(case when i.uniqueness='UNIQUE' then 1 else 0 end) as "ISUNIQUE",
+					c.column_name as "COLUMNNAME",c.column_position as "POSITION"
+				from all_indexes i
+					join all_ind_columns c on c.index_owner=i.owner and c.index_name=i.index_name
+				where i.table_owner='%s' and i.table_name='%s' and i.index_type<>'LOB' and
+					not exists(select 1 from all_constraints k where k.owner=i.table_owner and
+						k.table_name=i.table_name and k.index_name=i.index_name and k.constraint_type in ('P','U'))
+				order by i.owner,i.index_name,c.column_position`, schema, t.TableName)
+		if result, _, err = QueryRecord(t.Db, strSql, nil); err != nil {
+			log.Panic(SqlError{strSql, nil, err})
+		}
+		for _, row := range result {
+			owner, name := safe.String(row["OWNER"]), safe.String(row["NAME"])
+			if express, ok := expresses[fmt.Sprintf("%s.%s.%d", owner, name, safe.Int(row["POSITION"]))]; ok {
+				appendColumn(owner, name, safe.Int(row["ISUNIQUE"]) == 1, express, true)
+			} else {
+				appendColumn(owner, name, safe.Int(row["ISUNIQUE"]) == 1, safe.String(row["COLUMNNAME"]), false)
+			}
+		}
+	case "mysql":
+		//函数索引的expression是8.0.13才有的，出错则不获取表达式
+		strSql := `select index_schema as OWNER,index_name as NAME,
+					(case when non_unique=0 then 1 else 0 end) as ISUNIQUE,
+					ifnull(column_name,'') as COLUMNNAME,%s as EXPRESS
+				from information_schema.statistics
+				where upper(table_schema)='%s' and upper(table_name)='%s' and index_name<>'PRIMARY'
+				order by index_name,seq_in_index`
+		result, _, err := QueryRecord(t.Db, fmt.Sprintf(strSql, "ifnull(expression,'')", schema, strings.ToUpper(t.TableName)), nil)
+		if err != nil {
+			strSql = fmt.Sprintf(strSql, "''", schema, strings.ToUpper(t.TableName))
+			if result, _, err = QueryRecord(t.Db, strSql, nil); err != nil {
+				log.Panic(SqlError{strSql, nil, err})
+			}
+		}
+		for _, row := range result {
+			owner, name := safe.String(row["OWNER"]), safe.String(row["NAME"])
+			if express := safe.String(row["EXPRESS"]); len(express) > 0 {
+				appendColumn(owner, name, safe.Int(row["ISUNIQUE"]) == 1, express, true)
+			} else {
+				appendColumn(owner, name, safe.Int(row["ISUNIQUE"]) == 1, safe.String(row["COLUMNNAME"]), false)
+			}
+		}
+	case "sqlite3":
+		strSql := fmt.Sprintf("PRAGMA index_list(%s)", t.quote(t.TableName))
+		result, _, err := QueryRecord(t.Db, strSql, nil)
+		if err != nil {
+			log.Panic(SqlError{strSql, t.TableName, err})
+		}
+		for _, row := range result {
+			//origin是pk的是主键索引，u的是唯一约束的索引
+			if origin := safe.String(row["ORIGIN"]); origin == "pk" || origin == "u" {
+				continue
+			}
+			idx := &DBIndex{
+				Name:   safe.String(row["NAME"]),
+				Unique: safe.Int(row["UNIQUE"]) == 1,
+			}
+			//每个索引再去找定义，字段名为空的是表达式
+			strSql = fmt.Sprintf("PRAGMA index_info(%s)", t.quote(idx.Name))
+			indexColumnList, _, err := QueryRecord(t.Db, strSql, nil)
+			if err != nil {
+				log.Panic(SqlError{strSql, nil, err})
+			}
+			express := safe.Int(row["PARTIAL"]) == 1
+			for _, col := range indexColumnList {
+				if col["NAME"] == nil {
+					express = true
+				}
+				idx.Columns = append(idx.Columns, FetchedName(t.Db.DriverName(), safe.String(col["NAME"])))
+			}
+			//表达式和部分索引需要从建索引的语句中解析
+			if express {
+				strSql = fmt.Sprintf("select sql from sqlite_master where type='index' and name='%s'", idx.Name)
+				define := safe.String(MustGetSqlFun(t.Db, strSql, nil))
+				ex, where := parseIndexDefine(define)
+				idx.Where = where
+				for _, col := range indexColumnList {
+					if col["NAME"] == nil {
+						idx.Columns = nil
+						idx.Express = ex
+						break
+					}
+				}
+			}
+			rev = append(rev, &fetchedIndex{"", idx})
+		}
+	default:
+		log.Panic(fmt.Errorf("not impl fetchIndexes"))
+	}
+	return rev
+}
+
+var (
+	indexOnReg    = regexp.MustCompile(`(?is)\son\s+[^(]+?(\s+using\s+\w+\s*)?\(`)
+	indexWhereReg = regexp.MustCompile(`(?is)^\s*where\s+(.*)$`)
+)
+
+//从建索引的语句中解析出索引的表达式以及部分索引的条件
+func parseIndexDefine(define string) (express, where string) {
+	idx := indexOnReg.FindStringIndex(define)
+	if idx == nil {
+		return
+	}
+	group, rest, err := scriptGroup(define[idx[1]-1:])
+	if err != nil {
+		return
+	}
+	express = strings.TrimSpace(group[1 : len(group)-1])
+	if list := indexWhereReg.FindStringSubmatch(rest); len(list) > 0 {
+		where = strings.TrimSpace(list[1])
+	}
+	return
+}
+
+//从sqlite3的建表语句中获取计算字段的表达式
+func sqliteGenerated(createSql, colName string) string {
+	reg := regexp.MustCompile(`(?is)(^|[(,\s])["\x60]?` + regexp.QuoteMeta(colName) + `["\x60]?\s[^,]*?\bas\s*\(`)
+	idx := reg.FindStringIndex(createSql)
+	if idx == nil {
+		return ""
+	}
+	express, _, err := scriptGroup(createSql[idx[1]-1:])
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(express[1 : len(express)-1])
+}
+
+//oracle布尔字段的check约束，例如"A" IN (0,1)
+var oracleBoolCheckReg = regexp.MustCompile(`(?is)^\s*"?[^"\s]+"?\s+in\s*\(\s*0\s*,\s*1\s*\)\s*$`)
+
+var sqliteCheckReg = regexp.MustCompile(`(?i)constraint\s+["\x60]?([\p{Han}_a-zA-Z0-9]+)["\x60]?\s+check\s*\(`)
+
+func (t *DBTable) refreshColumnsMap() {
+	t.columnsMap = map[string]*DBTableColumn{}
+	for _, col := range t.columns {
+		t.columnsMap[col.Name] = col
+	}
+}
+
+//克隆一个table，复制结构定义
+func (t *DBTable) Clone() *DBTable {
+	result := tableOf(t.Db, t.Name())
+	cols := []*DBTableColumn{}
+	for _, v := range t.AllField() {
+		cols = append(cols, v.Clone())
+	}
+	result.Define(cols, t.PrimaryKeys())
+	result.Comment = t.Comment
+	for _, v := range t.Checks {
+		result.Checks = append(result.Checks, &DBCheck{v.Name, v.Express})
+	}
+	for _, v := range t.Indexes {
+		result.Indexes = append(result.Indexes, &DBIndex{v.Name, append([]string{}, v.Columns...), v.Unique, v.Express, v.Where})
+	}
+	for _, v := range t.ForeignKeys {
+		result.ForeignKeys = append(result.ForeignKeys, &DBForeignKey{v.Name, append([]string{}, v.Columns...),
+			v.RefTable, append([]string{}, v.RefColumns...), v.OnDelete, v.OnUpdate})
+	}
+	if t.Partition != nil {
+		result.Partition = t.Partition.clone()
+	}
+	return result
+}
+func (t *DBTable) AllField() []*DBTableColumn {
+	if t.columns == nil {
+		t.FetchColumns()
+	}
+	return t.columns
+}
+func sqliteType(typeName string) (string, int) {
+	/*
+		<1> 如果声明类型包含”INT”字符串，那么这个列被赋予INTEGER近似
+		<2> 如果这个列的声明类型包含”CHAR”，”CLOB”，或者”TEXT”中的任意一个，那么这个列就有了TEXT近似。注意类型VARCHAR包含了”CHAR”字符串，那么也就被赋予了TEXT近似
+		<3> 如果列的声明类型中包含了字符串”BLOB”或者没有为其声明类型，这个列被赋予NONE近似
+		<4> 其他的情况，列被赋予NUMERIC近似
+	*/
+	typeName = strings.ToUpper(typeName)
+	if strings.Contains(typeName, "JSON") {
+		return "JSON", 0
+	}
+	//INTEGER(1)、TINYINT(1)以及BOOLEAN都作为布尔
+	if strings.Contains(typeName, "BOOL") ||
+		strings.Contains(typeName, "INT") && strings.HasSuffix(strings.Replace(typeName, " ", "", -1), "(1)") {
+		return "BOOL", 0
+	}
+	if strings.Contains(typeName, "INT") {
+		return "INT", 0
+	}
+	if strings.Contains(typeName, "CHAR") ||
+		strings.Contains(typeName, "CLOB") ||
+		strings.Contains(typeName, "TEXT") {
+		length := "-1"
+		if ts := strings.Split(typeName, "("); len(ts) > 1 {
+			length = ts[1]
+			length = length[:len(length)-1]
+		}
+		i, _ := strconv.ParseInt(length, 10, 64)
+		return "STR", int(i)
+	}
+	if strings.Contains(typeName, "BLOB") || strings.Contains(typeName, "BYTEA") ||
+		len(typeName) == 0 {
+		return "BYTEA", 0
+	}
+	if strings.Contains(typeName, "TIMESTAMPTZ") || strings.Contains(typeName, "TIME ZONE") {
+		return "TIMESTAMPTZ", 0
+	}
+	if strings.Contains(typeName, "TIMESTAMP") || strings.Contains(typeName, "DATETIME") {
+		return "TIMESTAMP", 0
+	}
+	if strings.Contains(typeName, "DATE") {
+		return "DATE", 0
+	}
+	if strings.Contains(typeName, "TIME") {
+		return "TIMESTAMP", 0
+	}
+	if strings.Contains(typeName, "DEC") || strings.Contains(typeName, "NUMERIC") {
+		return "DEC", 0
+	}
+	return "FLOAT", 0
+}
+
+//从NUMERIC(18,2)、dec(18,2)这样的类型名称中取出精度
+func parseTypePrecision(typeName string) (precision, scale int) {
+	ts := strings.Split(typeName, "(")
+	if len(ts) < 2 {
+		return
+	}
+	ps := strings.Split(strings.TrimSuffix(strings.TrimSpace(ts[1]), ")"), ",")
+	if i, err := strconv.ParseInt(strings.TrimSpace(ps[0]), 10, 64); err == nil {
+		precision = int(i)
+	}
+	if len(ps) > 1 {
+		if i, err := strconv.ParseInt(strings.TrimSpace(ps[1]), 10, 64); err == nil {
+			scale = int(i)
+		}
+	}
+	return
+}
+func (t *DBTable) Field(name string) *DBTableColumn {
+	if len(t.columnsMap) == 0 {
+		t.FetchColumns()
+	}
+	if col, ok := t.columnsMap[name]; ok {
+		return col
+	} else {
+		return nil
+	}
+}
+
+//采用脚本的方式定义表，如下：
+//  a str(3) not null
+//  b int default 0
+//  c date not null index
+//  d dec(18,2)
+//  e bool not null default true
+//  f timestamptz default current_timestamp
+//  g json
+//  h str(10) default 'abc'
+//  i int identity comment '序号'
+//  j str -- 备注
+//  primary key(a,c)
+//  k str(1) enum('a','b') default 'a'
+//  l dec(18,2) as (b * d)
+//  constraint ck_b check (b > 0)
+//  index(b,c)
+//  unique index uk_h(h) where (h is not null)
+//  index(lower(h))
+//  foreign key(b) references t2(id) on delete cascade
+//  m dateonly
+//  comment '表的说明'
+//  partition by range(c)
+//  partition p2020 values ('2021-01-01')
+//  partition pmax values (maxvalue)
+//date是带时间的日期，和原有的定义一致，dateonly是不含时间的日期，timestamp带小数秒，timestamptz带时区
+//default后面是sql表达式，含有空格的表达式需要用括号括起来，identity表示自增字段，同时也是not null
+//字段的说明用comment '...'或者行尾的-- ...，单独一行的comment '...'是表的说明
+//enum(...)是字段允许的值，constraint ... check (...)是表的check约束，as (...)是计算字段的表达式
+//using (...)是字段类型变化时原有数据的转换表达式，只在调整结构时使用
+//[unique] index [名称](...) [where ...]是索引定义，名称可以带schema，括号中不全是字段名的是表达式索引，unique(...)是唯一索引的简写
+//单字段的普通索引等同于字段后面的index
+//[constraint 名称] foreign key(...) references 表[(...)] [on delete ...] [on update ...]是外键，引用的字段为空则是引用表的主键
+//partition by range|list|hash(...) [数量]是表分区，后面每行partition 名称 [values (...)]是一个分区，哈希分区可以只写数量
 func (t *DBTable) DefineScript(src string) {
-	lineReg, err := regexp.Compile(`(?i)([\p{Han}_a-zA-Z0-9]+)(\s+bytea|\s+date|\s+float|\s+int|\s+str\([0-9]+\)|\s+str|)(\s+null|\s+not null|)(\s+index|)`)
+	//数据类型包括自定义注册的类型
+	lineReg, err := regexp.Compile(`(?i)^([\p{Han}_a-zA-Z0-9]+)(` + columnTypeRegexp() + `|)`)
 	if err != nil {
 		log.Panic(err)
 	}
 	pks := []string{}
 	columns := []*DBTableColumn{}
+	checks := []*DBCheck{}
+	indexes := []*DBIndex{}
+	foreignKeys := []*DBForeignKey{}
+	var partition *DBPartition
 	var prevColumn *DBTableColumn
 	for i, line := range strings.Split(strings.Replace(src, "\r\n", "\n", -1), "\n") {
 		//这里全部转换成小写，后面的字段变更判断就需要增加大小写忽略的逻辑
@@ -1662,73 +3204,491 @@ func (t *DBTable) DefineScript(src string) {
 			for _, v := range strings.Split(line[12:len(line)-1], ",") {
 				pks = append(pks, strings.TrimSpace(v))
 			}
+		} else if tableCommentReg.MatchString(line) {
+			//表的说明
+			comment, rest, err := scriptString(line[len(optionCommentReg.FindString(line)):])
+			if err != nil || len(strings.TrimSpace(rest)) > 0 {
+				log.Panic(fmt.Errorf("line %d:%s ,error comment", i, line))
+			}
+			t.Comment = comment
+		} else if list := tableFKReg.FindStringSubmatch(line); len(list) > 0 {
+			//外键
+			fk, err := parseScriptForeignKey(list)
+			if err != nil {
+				log.Panic(fmt.Errorf("line %d:%s ,error foreign key %s", i, line, err))
+			}
+			foreignKeys = append(foreignKeys, fk)
+		} else if list := tableCheckReg.FindStringSubmatch(line); len(list) > 0 {
+			//check约束
+			express, rest, err := scriptGroup(list[2])
+			if err != nil || len(strings.TrimSpace(rest)) > 0 {
+				log.Panic(fmt.Errorf("line %d:%s ,error check", i, line))
+			}
+			checks = append(checks, &DBCheck{list[1], strings.TrimSpace(express[1 : len(express)-1])})
+		} else if list := scriptPartByReg.FindStringSubmatch(line); len(list) > 0 {
+			//分区方式
+			partition = parseScriptPartition(list)
+		} else if list := scriptPartReg.FindStringSubmatch(line); len(list) > 0 && partition != nil {
+			//分区，在分区方式之后才是分区，否则是名为partition的字段
+			part := &DBPartitionPart{Name: list[1]}
+			if len(list[2]) > 0 {
+				part.Values = parsePartitionValues(list[2])
+			}
+			partition.Parts = append(partition.Parts, part)
+		} else if list := tableIndexReg.FindStringSubmatch(line); len(list) > 0 {
+			//索引
+			idx, err := parseScriptIndex(list, line[len(list[0])-1:])
+			if err != nil {
+				log.Panic(fmt.Errorf("line %d:%s ,error index %s", i, line, err))
+			}
+			indexes = append(indexes, idx)
 		} else {
 			lineList := lineReg.FindStringSubmatch(line)
-			if len(lineList) == 0 {
-				log.Panic(fmt.Errorf("line %d:%s error", i, line))
-			}
-			//第一个是整行，需要去除
-			lineList = lineList[1:]
-			if len(lineList) == 0 {
+			if len(lineList) < 3 {
 				log.Panic(fmt.Errorf("line %d:%s error", i, line))
 			}
-			colName := lineList[0]
-			if len(strings.TrimSpace(lineList[1])) == 0 {
+			colName := lineList[1]
+			var col *DBTableColumn
+			if len(strings.TrimSpace(lineList[2])) == 0 {
 				//如果只有列名，则自动从上一个字段取出数据类型等定义
 				if prevColumn == nil {
 					log.Panic(fmt.Errorf("line %d:%s not data type", i, line))
+				}
+				col = prevColumn.Clone()
+				col.Name = colName
+			} else {
+				dataType := strings.ToLower(strings.TrimSpace(lineList[2]))
+				var maxLength int64 = -1
+				var precision, scale int
+				//括号中的参数，dec是精度，其他类型是长度
+				if ts := strings.SplitN(dataType, "(", 2); len(ts) > 1 {
+					p, s := parseTypePrecision(dataType)
+					dataType = strings.ToUpper(strings.TrimSpace(ts[0]))
+					if dataType == "DEC" || strings.Contains(ts[1], ",") {
+						precision, scale = p, s
+					} else {
+						maxLength = int64(p)
+					}
 				} else {
-					col := prevColumn.Clone()
-					col.Name = colName
-					columns = append(columns, col)
-					prevColumn = col
-					continue
+					dataType = strings.ToUpper(dataType)
 				}
-			}
-			dataType := strings.ToLower(strings.TrimSpace(lineList[1]))
-			notNull := false
-			index := false
-			var maxLength int64 = -1
-			if len(lineList) > 2 {
-				switch str := strings.ToLower(strings.TrimSpace(lineList[2])); str {
-				case "not null":
-					notNull = true
-				case "null":
-					notNull = false
-				case "":
-				default:
-					log.Panic(fmt.Errorf("line %d:%s ,error define %s", i, line, str))
+				col = &DBTableColumn{
+					Name:      colName,
+					Type:      dataType,
+					MaxLength: int(maxLength),
+					Precision: precision,
+					Scale:     scale,
+					Null:      true,
 				}
 			}
-			if len(lineList) > 3 {
-				switch str := strings.ToLower(strings.TrimSpace(lineList[3])); str {
-				case "index":
-					index = true
-				case "":
+			if err := parseColumnOptions(col, line[len(lineList[0]):]); err != nil {
+				log.Panic(fmt.Errorf("line %d:%s ,error define %s", i, line, err))
+			}
+			columns = append(columns, col)
+			prevColumn = col
+		}
+	}
+	t.Define(columns, pks)
+	t.Checks = checks
+	t.ForeignKeys = foreignKeys
+	if partition != nil {
+		t.Partition = partition
+	}
+	//单字段的普通索引用字段的Index标识
+	t.Indexes = nil
+	for _, v := range indexes {
+		if v.single() && t.Field(v.Columns[0]) != nil {
+			t.Field(v.Columns[0]).Index = true
+			continue
+		}
+		t.Indexes = append(t.Indexes, v)
+	}
+}
+
+//Script 生成DefineScript格式的表定义，是DefineScript的逆过程，一般用于已有的表，
+//需要先FetchColumns，有分区的表还需要FetchPartition，用DefineScript解析生成的脚本可以得到相同的定义
+//字段名不合规或者是未注册的数据类型返回错误，说明中的换行会被替换成空格
+func (t *DBTable) Script() (string, error) {
+	types := map[string]bool{}
+	for _, v := range ColumnTypeNames() {
+		types[v] = true
+	}
+	lines := []string{}
+	for _, col := range t.AllField() {
+		if !columnNameReg.MatchString(col.Name) {
+			return "", fmt.Errorf("column %s name not support", col.Name)
+		}
+		if !types[col.Type] {
+			return "", fmt.Errorf("column %s type %s not support", col.Name, col.Type)
+		}
+		line := col.Name + " " + col.scriptType()
+		switch {
+		case col.Identity:
+			line += " identity"
+		case len(col.Generated) > 0:
+			line += " as (" + col.Generated + ")"
+		case len(normalizeDefault(col.Default)) > 0:
+			//含有空格的表达式需要括起来
+			if express, rest, err := scriptExpress(col.Default); err == nil && express == col.Default && len(rest) == 0 {
+				line += " default " + col.Default
+			} else {
+				line += " default (" + col.Default + ")"
+			}
+		}
+		if !col.Null && !col.Identity {
+			line += " not null"
+		}
+		if len(col.Enum) > 0 {
+			list := []string{}
+			for _, v := range col.Enum {
+				switch col.GoType() {
+				case TypeInt, TypeFloat, TypeDecimal:
+					list = append(list, v)
 				default:
-					log.Panic(fmt.Errorf("line %d:%s ,error define %s", i, line, str))
+					list = append(list, scriptQuote(v))
 				}
 			}
-			if strings.HasPrefix(dataType, "str(") {
-				maxLength, err = strconv.ParseInt(dataType[4:len(dataType)-1], 10, 64)
-				if err != nil {
-					log.Panic(err)
-				}
-				dataType = "STR"
-			} else {
-				dataType = strings.ToUpper(dataType)
+			line += " enum(" + strings.Join(list, ",") + ")"
+		}
+		if col.Index {
+			line += " index"
+		}
+		if len(col.Comment) > 0 {
+			line += " comment " + scriptQuote(col.Comment)
+		}
+		lines = append(lines, line)
+	}
+	for _, v := range t.Checks {
+		lines = append(lines, fmt.Sprintf("constraint %s check (%s)", v.Name, v.Express))
+	}
+	for _, v := range t.Indexes {
+		lines = append(lines, v.script())
+	}
+	for _, v := range t.ForeignKeys {
+		line := ""
+		if len(v.Name) > 0 {
+			line = "constraint " + v.Name + " "
+		}
+		line += fmt.Sprintf("foreign key(%s) references %s", strings.Join(v.Columns, ","), v.RefTable)
+		if len(v.RefColumns) > 0 {
+			line += "(" + strings.Join(v.RefColumns, ",") + ")"
+		}
+		if len(v.OnDelete) > 0 {
+			line += " on delete " + strings.ToLower(v.OnDelete)
+		}
+		if len(v.OnUpdate) > 0 {
+			line += " on update " + strings.ToLower(v.OnUpdate)
+		}
+		lines = append(lines, line)
+	}
+	if pks := t.PrimaryKeys(); len(pks) > 0 {
+		lines = append(lines, "primary key("+strings.Join(pks, ",")+")")
+	}
+	if t.Partition != nil {
+		lines = append(lines, t.Partition.script()...)
+	}
+	if len(t.Comment) > 0 {
+		lines = append(lines, "comment "+scriptQuote(t.Comment))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+//DefineScript中的数据类型，带上长度或者精度
+func (c *DBTableColumn) scriptType() string {
+	switch {
+	case c.Type == "DEC":
+		if c.Precision > 0 {
+			return fmt.Sprintf("dec(%d,%d)", c.Precision, c.Scale)
+		}
+	case c.MaxLength > 0 && columnTypeHasLength(c.Type):
+		return fmt.Sprintf("%s(%d)", strings.ToLower(c.Type), c.MaxLength)
+	}
+	return strings.ToLower(c.Type)
+}
+
+//DefineScript中的索引定义
+func (i *DBIndex) script() string {
+	rev := "index"
+	if i.Unique {
+		rev = "unique index"
+	}
+	if len(i.Name) > 0 {
+		rev += " " + i.Name
+	}
+	if len(i.Express) > 0 {
+		rev += "(" + i.Express + ")"
+	} else {
+		rev += "(" + strings.Join(i.Columns, ",") + ")"
+	}
+	if len(i.Where) > 0 {
+		rev += " where " + i.Where
+	}
+	return rev
+}
+
+//脚本中的字符串，单引号转义，换行替换成空格
+func scriptQuote(v string) string {
+	v = strings.Replace(strings.Replace(v, "\r\n", " ", -1), "\n", " ", -1)
+	return "'" + strings.Replace(v, "'", "''", -1) + "'"
+}
+
+//解析脚本中的外键定义，list是tableFKReg的匹配结果
+func parseScriptForeignKey(list []string) (*DBForeignKey, error) {
+	fk := &DBForeignKey{
+		Name:       list[1],
+		Columns:    splitExpress(list[2]),
+		RefTable:   strings.ToUpper(list[3]),
+		RefColumns: splitExpress(list[4]),
+	}
+	for rest := strings.TrimSpace(list[5]); len(rest) > 0; rest = strings.TrimSpace(rest) {
+		action := fkActionReg.FindStringSubmatch(rest)
+		if len(action) == 0 {
+			return nil, fmt.Errorf("%s", rest)
+		}
+		if strings.EqualFold(action[1], "delete") {
+			fk.OnDelete = strings.ToUpper(strings.Join(strings.Fields(action[2]), " "))
+		} else {
+			fk.OnUpdate = strings.ToUpper(strings.Join(strings.Fields(action[2]), " "))
+		}
+		rest = rest[len(action[0]):]
+	}
+	return fk, nil
+}
+
+//解析脚本中的索引定义，list是tableIndexReg的匹配结果，src从括号开始
+func parseScriptIndex(list []string, src string) (*DBIndex, error) {
+	group, rest, err := scriptGroup(src)
+	if err != nil {
+		return nil, err
+	}
+	idx := &DBIndex{
+		Name:   strings.TrimSpace(list[2]),
+		Unique: strings.HasPrefix(strings.ToLower(list[1]), "unique"),
+	}
+	if rest = strings.TrimSpace(rest); len(rest) > 0 {
+		where := indexWhereReg.FindStringSubmatch(rest)
+		if len(where) == 0 {
+			return nil, fmt.Errorf("%s", rest)
+		}
+		idx.Where = strings.TrimSpace(where[1])
+	}
+	items := splitExpress(group[1 : len(group)-1])
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%s not columns", group)
+	}
+	for _, v := range items {
+		if !columnNameReg.MatchString(v) {
+			idx.Express = strings.Join(items, ", ")
+			return idx, nil
+		}
+	}
+	idx.Columns = items
+	return idx, nil
+}
+
+//按最外层的逗号分隔表达式，单引号和括号中的逗号不算
+func splitExpress(src string) []string {
+	rev := []string{}
+	depth := 0
+	quote := false
+	start := 0
+	for i, c := range src {
+		switch {
+		case c == '\'':
+			quote = !quote
+		case quote:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			rev = append(rev, strings.TrimSpace(src[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(src[start:]); len(last) > 0 || len(rev) > 0 {
+		rev = append(rev, last)
+	}
+	return rev
+}
+
+var (
+	optionNotNullReg = regexp.MustCompile(`(?i)^not\s+null\b`)
+	optionNullReg    = regexp.MustCompile(`(?i)^null\b`)
+	optionIndexReg   = regexp.MustCompile(`(?i)^index\b`)
+	optionDefaultReg = regexp.MustCompile(`(?i)^default\s+`)
+	optionIdentReg   = regexp.MustCompile(`(?i)^identity\b`)
+	optionCommentReg = regexp.MustCompile(`(?i)^comment\s+`)
+	tableCommentReg  = regexp.MustCompile(`(?i)^comment\s+'`)
+	optionEnumReg    = regexp.MustCompile(`(?i)^enum\s*\(`)
+	optionAsReg      = regexp.MustCompile(`(?i)^(generated\s+always\s+)?as\s*\(`)
+	optionUsingReg   = regexp.MustCompile(`(?i)^using\s*\(`)
+	tableIndexReg    = regexp.MustCompile(`(?i)^(unique\s+index|unique|index)(\s+[\p{Han}_a-zA-Z0-9.]+)?\s*\(`)
+	columnNameReg    = regexp.MustCompile(`^[\p{Han}_a-zA-Z0-9]+$`)
+	tableFKReg       = regexp.MustCompile(`(?i)^(?:constraint\s+([\p{Han}_a-zA-Z0-9]+)\s+)?foreign\s+key\s*\(([^)]*)\)\s*references\s+([\p{Han}_a-zA-Z0-9.]+)\s*(?:\(([^)]*)\))?(.*)$`)
+	fkActionReg      = regexp.MustCompile(`(?i)^on\s+(delete|update)\s+(cascade|set\s+null|set\s+default|restrict|no\s+action)\b`)
+	tableCheckReg    = regexp.MustCompile(`(?i)^constraint\s+([\p{Han}_a-zA-Z0-9]+)\s+check\s*(\(.*)$`)
+)
+
+//处理DefineScript中字段类型后面的选项，顺序不限
+func parseColumnOptions(col *DBTableColumn, src string) error {
+	for src = strings.TrimSpace(src); len(src) > 0; src = strings.TrimSpace(src) {
+		switch {
+		case optionNotNullReg.MatchString(src):
+			col.Null = false
+			src = src[len(optionNotNullReg.FindString(src)):]
+		case optionNullReg.MatchString(src):
+			col.Null = true
+			src = src[len(optionNullReg.FindString(src)):]
+		case optionIndexReg.MatchString(src):
+			col.Index = true
+			src = src[len(optionIndexReg.FindString(src)):]
+		case optionIdentReg.MatchString(src):
+			col.Identity = true
+			col.Null = false
+			src = src[len(optionIdentReg.FindString(src)):]
+		case strings.HasPrefix(src, "--"):
+			col.Comment = strings.TrimSpace(src[2:])
+			src = ""
+		case optionCommentReg.MatchString(src):
+			comment, rest, err := scriptString(src[len(optionCommentReg.FindString(src)):])
+			if err != nil {
+				return err
+			}
+			col.Comment = comment
+			src = rest
+		case optionAsReg.MatchString(src):
+			express, rest, err := scriptGroup(src[len(optionAsReg.FindString(src))-1:])
+			if err != nil {
+				return err
+			}
+			col.Generated = strings.TrimSpace(express[1 : len(express)-1])
+			src = rest
+		case optionUsingReg.MatchString(src):
+			express, rest, err := scriptGroup(src[len(optionUsingReg.FindString(src))-1:])
+			if err != nil {
+				return err
+			}
+			col.Convert = strings.TrimSpace(express[1 : len(express)-1])
+			src = rest
+		case optionEnumReg.MatchString(src):
+			express, rest, err := scriptGroup(src[len(optionEnumReg.FindString(src))-1:])
+			if err != nil {
+				return err
+			}
+			list, err := scriptList(express[1 : len(express)-1])
+			if err != nil {
+				return err
 			}
-			prevColumn = &DBTableColumn{
-				Name:      colName,
-				Type:      dataType,
-				MaxLength: int(maxLength),
-				Null:      !notNull,
-				Index:     index,
+			col.Enum = list
+			src = rest
+		case optionDefaultReg.MatchString(src):
+			express, rest, err := scriptExpress(src[len(optionDefaultReg.FindString(src)):])
+			if err != nil {
+				return err
 			}
-			columns = append(columns, prevColumn)
+			col.Default = express
+			src = rest
+		default:
+			return fmt.Errorf("%s", src)
 		}
 	}
-	t.Define(columns, pks)
+	return nil
+}
+
+//分解脚本中逗号分隔的值列表，字符串需要用单引号括起来
+func scriptList(src string) ([]string, error) {
+	rev := []string{}
+	for src = strings.TrimSpace(src); len(src) > 0; {
+		var v string
+		if strings.HasPrefix(src, "'") {
+			str, rest, err := scriptString(src)
+			if err != nil {
+				return nil, err
+			}
+			v, src = str, rest
+		} else if i := strings.Index(src, ","); i >= 0 {
+			v, src = strings.TrimSpace(src[:i]), src[i:]
+		} else {
+			v, src = strings.TrimSpace(src), ""
+		}
+		rev = append(rev, v)
+		src = strings.TrimSpace(src)
+		if strings.HasPrefix(src, ",") {
+			src = strings.TrimSpace(src[1:])
+		} else if len(src) > 0 {
+			return nil, fmt.Errorf("%s error list", src)
+		}
+	}
+	return rev, nil
+}
+
+//从脚本中取出一个单引号括起来的字符串，两个单引号表示一个单引号
+func scriptString(src string) (str, rest string, err error) {
+	if !strings.HasPrefix(src, "'") {
+		return "", "", fmt.Errorf("%s not a string", src)
+	}
+	for i := 1; i < len(src); i++ {
+		if src[i] != '\'' {
+			continue
+		}
+		if i+1 < len(src) && src[i+1] == '\'' {
+			i++
+			continue
+		}
+		return strings.Replace(src[1:i], "''", "'", -1), src[i+1:], nil
+	}
+	return "", "", fmt.Errorf("%s is incomplete", src)
+}
+
+//从脚本中取出一个括号括起来的部分，到匹配的右括号结束，返回的部分含括号
+func scriptGroup(src string) (group, rest string, err error) {
+	if !strings.HasPrefix(src, "(") {
+		return "", "", fmt.Errorf("%s not start with (", src)
+	}
+	depth := 0
+	quote := false
+	for i, c := range src {
+		switch {
+		case c == '\'':
+			quote = !quote
+		case quote:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return src[:i+1], src[i+1:], nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("%s is incomplete", src)
+}
+
+//从脚本中取出一个sql表达式，到空白处结束，单引号和括号中的空白不算
+func scriptExpress(src string) (express, rest string, err error) {
+	depth := 0
+	quote := false
+	for i, c := range src {
+		switch {
+		case c == '\'':
+			quote = !quote
+		case quote:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth < 0 {
+				return "", "", fmt.Errorf("%s unbalanced parentheses", src)
+			}
+		case depth == 0 && unicode.IsSpace(c):
+			return src[:i], src[i:], nil
+		}
+	}
+	if quote || depth != 0 {
+		return "", "", fmt.Errorf("%s is incomplete", src)
+	}
+	return src, "", nil
 }
 
 //手工赋值
@@ -1774,9 +3734,13 @@ func (t *DBTable) Merge(tabName string, skipUpdateColumns ...string) error {
 	pkMap := map[string]bool{}
 	for _, v := range t.PrimaryKeys() {
 		pkMap[v] = true
-		join = append(join, fmt.Sprintf("dest.%s = src.%s", v, v))
+		join = append(join, fmt.Sprintf("dest.%[1]s = src.%[1]s", t.quote(v)))
 	}
 	for _, field := range t.AllField() {
+		//计算字段不能写入
+		if len(field.Generated) > 0 {
+			continue
+		}
 		//非主键的才更新
 		if _, ok := pkMap[field.Name]; !ok {
 			bfound := false
@@ -1789,11 +3753,11 @@ func (t *DBTable) Merge(tabName string, skipUpdateColumns ...string) error {
 			}
 			//只有不是跳过的，才update
 			if !bfound {
-				updateSet = append(updateSet, fmt.Sprintf("dest.%s = src.%s", field.Name, field.Name))
+				updateSet = append(updateSet, fmt.Sprintf("dest.%[1]s = src.%[1]s", t.quote(field.Name)))
 			}
 		}
-		insertColumns = append(insertColumns, fmt.Sprintf("dest.%s", field.Name))
-		insertValues = append(insertValues, fmt.Sprintf("src.%s", field.Name))
+		insertColumns = append(insertColumns, fmt.Sprintf("dest.%s", t.quote(field.Name)))
+		insertValues = append(insertValues, fmt.Sprintf("src.%s", t.quote(field.Name)))
 	}
 	switch t.Db.DriverName() {
 	case "oci8":
@@ -1806,7 +3770,7 @@ WHEN MATCHED THEN UPDATE SET
 WHEN NOT MATCHED THEN INSERT
 	(%s)
 	values
-	(%s)`, t.Name(), tabName,
+	(%s)`, t.quotedName(), QuoteName(t.Db.DriverName(), tabName),
 			strings.Join(join, " and "),
 			strings.Join(updateSet, ",\n"),
 			strings.Join(insertColumns, ","),
@@ -1820,8 +3784,86 @@ WHEN NOT MATCHED THEN INSERT
 	return nil
 }
 
-//更新一个表的结构至数据库中，会自动处理表改名、字段改名以及字段修改、索引修改等操作
+//更新多个表的结构，按外键的引用关系排序，被引用的表先更新，不在其中的表不参与排序
+func UpdateSchemas(tables ...*DBTable) error {
+	sorted, err := sortTablesByReference(tables)
+	if err != nil {
+		return err
+	}
+	for _, v := range sorted {
+		if err := v.UpdateSchema(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//按外键的引用关系排序，引用自身的不算，循环引用返回错误
+func sortTablesByReference(tables []*DBTable) ([]*DBTable, error) {
+	byName := map[string]*DBTable{}
+	for _, v := range tables {
+		byName[strings.ToUpper(v.Name())] = v
+	}
+	rev := []*DBTable{}
+	//0未处理，1处理中，2已处理
+	state := map[*DBTable]int{}
+	var visit func(tab *DBTable, path []string) error
+	visit = func(tab *DBTable, path []string) error {
+		switch state[tab] {
+		case 1:
+			return fmt.Errorf("foreign key cycle:%s", strings.Join(append(path, tab.Name()), "->"))
+		case 2:
+			return nil
+		}
+		state[tab] = 1
+		for _, fk := range tab.ForeignKeys {
+			ref, ok := byName[strings.ToUpper(fk.RefTable)]
+			if !ok || ref == tab {
+				continue
+			}
+			if err := visit(ref, append(path, tab.Name())); err != nil {
+				return err
+			}
+		}
+		state[tab] = 2
+		rev = append(rev, tab)
+		return nil
+	}
+	for _, v := range tables {
+		if err := visit(v, nil); err != nil {
+			return nil, err
+		}
+	}
+	return rev, nil
+}
+
+//UpdateSchema 更新一个表的结构至数据库中，会自动处理表改名、字段改名以及字段修改、索引修改等操作，
+//按默认策略拒绝删除字段、修改主键等破坏性的调整
 func (t *DBTable) UpdateSchema() error {
+	return t.UpdateSchemaPolicy(nil)
+}
+
+//UpdateSchemaPolicy 按指定的策略调整表结构
+func (t *DBTable) UpdateSchemaPolicy(policy *SchemaPolicy) error {
+	sch, err := t.schema()
+	if err != nil {
+		return err
+	}
+	sch.Policy = policy
+	return sch.Update()
+}
+
+//生成更新表结构的计划，不执行，可以导出脚本审核后再Apply
+func (t *DBTable) PlanSchema() (*SchemaPlan, error) {
+	sch, err := t.schema()
+	if err != nil {
+		return nil, err
+	}
+	return sch.Plan()
+}
+
+//根据曾用名或者本来的名称获取数据库中的旧表，和本表组成结构调整
+func (t *DBTable) schema() (*TableSchema, error) {
 	sch := &TableSchema{
 		NewTable: t,
 	}
@@ -1832,7 +3874,7 @@ func (t *DBTable) UpdateSchema() error {
 		}
 		for _, v := range t.FormerName {
 			if _, ok := uname[v]; ok {
-				return fmt.Errorf("FormerName:%s dup", v)
+				return nil, fmt.Errorf("FormerName:%s dup", v)
 			}
 		}
 		//并根据曾用名去获取之前的表结构
@@ -1848,12 +3890,12 @@ func (t *DBTable) UpdateSchema() error {
 	if sch.OldTable == nil {
 		b, err := TableExists(t.Db, t.Name())
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if b {
-			sch.OldTable = NewTable(t.Db, t.Name())
+			sch.OldTable = tableOf(t.Db, t.Name())
 			sch.OldTable.FetchColumns()
 		}
 	}
-	return sch.Update()
+	return sch, nil
 }
@@ -0,0 +1,172 @@
+package dbx
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// DefineScript生成的定义，Script后再解析，应该得到相同的定义
+func TestDefineScriptRoundTrip(t *testing.T) {
+	src := `a str(3) not null
+b int default 0
+c date not null index
+d dec(18,2)
+e bool not null default true
+f timestamptz default (now() at time zone 'utc')
+g json
+h str(10) default 'a''bc'
+i int identity comment '序号''s'
+j str -- 备注
+k str(1) enum('a','b''') default 'a'
+l dec(18,2) as (b * d)
+m dateonly
+n int enum(1,2)
+constraint ck_b check (b > 0)
+index(b,c)
+unique index uk_h(h) where (h is not null)
+index s.ix_h(lower(h))
+constraint fk_b foreign key(b,n) references s.t2(id,x) on delete cascade on update set null
+primary key(a,c)
+partition by range(c)
+partition p2020 values ('2021-01-01')
+partition pmax values (maxvalue)
+comment '表的说明'`
+	t1 := &DBTable{TableName: "T"}
+	t1.DefineScript(src)
+	out, err := t1.Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t2 := &DBTable{TableName: "T"}
+	t2.DefineScript(out)
+	if out2, err := t2.Script(); err != nil || out2 != out {
+		t.Fatalf("script changed:\n%s\n%s", out, out2)
+	}
+	for i, col := range t1.AllField() {
+		if !reflect.DeepEqual(col, t2.AllField()[i]) {
+			t.Errorf("column %s:\n%+v\n%+v", col.Name, col, t2.AllField()[i])
+		}
+	}
+	if !reflect.DeepEqual(t1.Checks, t2.Checks) {
+		t.Error("checks")
+	}
+	if !reflect.DeepEqual(t1.Indexes, t2.Indexes) {
+		t.Error("indexes")
+	}
+	if !reflect.DeepEqual(t1.ForeignKeys, t2.ForeignKeys) {
+		t.Error("foreign keys")
+	}
+	if !reflect.DeepEqual(t1.Partition, t2.Partition) {
+		t.Error("partition")
+	}
+	if !reflect.DeepEqual(t1.PrimaryKeys(), t2.PrimaryKeys()) || t1.Comment != t2.Comment {
+		t.Error("table")
+	}
+}
+
+func TestParseIndexDefine(t *testing.T) {
+	cases := []struct{ define, express, where string }{
+		{"CREATE INDEX ix ON public.t USING btree (lower((name)::text))", "lower((name)::text)", ""},
+		{"CREATE UNIQUE INDEX uk ON t (a, (b + 1)) WHERE (a > 0)", "a, (b + 1)", "(a > 0)"},
+		{"CREATE INDEX ix on t(substr(name,1,2)) where name is not null", "substr(name,1,2)", "name is not null"},
+		{"CREATE INDEX ix", "", ""},
+	}
+	for _, c := range cases {
+		express, where := parseIndexDefine(c.define)
+		if express != c.express || where != c.where {
+			t.Errorf("%s: got %q %q", c.define, express, where)
+		}
+	}
+}
+
+func TestSplitExpress(t *testing.T) {
+	cases := []struct {
+		src  string
+		want []string
+	}{
+		{"a, b", []string{"a", "b"}},
+		{"substr(a,1,2), 'x,y', b", []string{"substr(a,1,2)", "'x,y'", "b"}},
+		{"", []string{}},
+		{"a,", []string{"a", ""}},
+	}
+	for _, c := range cases {
+		if got := splitExpress(c.src); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: got %q", c.src, got)
+		}
+	}
+}
+
+func TestParseScriptForeignKey(t *testing.T) {
+	list := tableFKReg.FindStringSubmatch("constraint fk_a foreign key(a, b) references s.t2 on delete set  null on update cascade")
+	if len(list) == 0 {
+		t.Fatal("not match")
+	}
+	fk, err := parseScriptForeignKey(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &DBForeignKey{
+		Name:       "fk_a",
+		Columns:    []string{"a", "b"},
+		RefTable:   "S.T2",
+		RefColumns: []string{},
+		OnDelete:   "SET NULL",
+		OnUpdate:   "CASCADE",
+	}
+	if !reflect.DeepEqual(fk, want) {
+		t.Errorf("got %+v", fk)
+	}
+	list = tableFKReg.FindStringSubmatch("foreign key(a) references t2(id) on insert cascade")
+	if _, err := parseScriptForeignKey(list); err == nil {
+		t.Error("invalid action must fail")
+	}
+}
+
+func TestKeyName(t *testing.T) {
+	tab := &DBTable{TableName: "T", columns: []*DBTableColumn{{Name: "ID"}, {Name: "OrderNo"}}}
+	tab.refreshColumnsMap()
+	cases := []struct{ key, want string }{
+		{"id", "ID"},
+		{"ID", "ID"},
+		{"OrderNo", "OrderNo"},
+		{"ORDERNO", "OrderNo"},
+		{"total", "TOTAL"},
+	}
+	for _, c := range cases {
+		if got := tab.keyName(c.key); got != c.want {
+			t.Errorf("%s: got %s want %s", c.key, got, c.want)
+		}
+	}
+}
+
+func TestOracleBoolCheck(t *testing.T) {
+	cases := map[string]bool{
+		"A IN (0,1)":           true,
+		`"IS_OK" in ( 0 , 1 )`: true,
+		`"A" IS NOT NULL`:      false,
+		"A IN (0,1,2)":         false,
+		"A > 0 AND A IN (0,1)": false,
+	}
+	for express, want := range cases {
+		if got := oracleBoolCheckReg.MatchString(express); got != want {
+			t.Errorf("%s: got %v", express, got)
+		}
+	}
+}
+
+//postgres中获取的timestamp without time zone是DATE，ToJson的格式和原来一致
+func TestDatetimeToJson(t *testing.T) {
+	v := time.Date(2026, 3, 5, 10, 20, 30, 123000000, time.UTC)
+	cases := map[string]string{
+		"DATE":      "2026-03-05T10:20:30Z",
+		"TIMESTAMP": "2026-03-05T10:20:30.123Z",
+		"DATEONLY":  "2026-03-05",
+	}
+	for typ, want := range cases {
+		got, err := (&DBTableColumn{Name: "D", Type: typ}).ToJson(v)
+		if err != nil || got != want {
+			t.Errorf("%s: got %v %v", typ, got, err)
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package dbx
 
 import (
+	"dbweb/lib/safe"
 	"fmt"
 	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -13,10 +17,29 @@ import (
 //2.主键更改
 //3.字段改名
 //4.字段调整
-//5.单字段的索引调整
+//5.索引调整，单字段的普通索引随字段处理，其他的索引按定义比较，不同的删除后重建
+//6.字段默认值调整
+//7.自增字段调整
+//8.表和字段的说明调整
+//9.check约束以及字段枚举值约束的调整
+//10.计算字段的调整，计算表达式不能修改，需要删除后重建
+//11.外键的调整，被引用的表需要先存在，多个表用UpdateSchemas按引用关系排序
+//12.字段类型变化时转换原有数据，postgres用using，oracle和mysql用临时字段转换后替换，
+//   用数据库的转换规则检查原有数据，包括指定的转换表达式，不能转换的行数和部分行记录在计划中，计划不能执行
+//13.sqlite3除了新增字段、索引以及表改名，其他的调整都用重建表的方式完成
+//14.表分区只在建表时生成，已有表的分区用AddPartition、DropPartition调整
+//15.postgres、sqlite3的计划在事务中执行，出错时回滚，oracle、mysql出错时执行补偿语句撤销已执行的调整，
+//   要删除或重建的字段先按主键备份到临时表，撤销时从中恢复数据，成功后删除备份
+//16.每个语句按是否丢失数据分类，Update默认拒绝删除字段、修改主键等破坏性的调整，需要Policy允许，
+//   指定Policy时还拒绝缩短长度等缩小范围的调整，除非Policy允许
+//   Policy可以要求删除字段前将数据归档
+//调整的语句先由Plan生成计划，不执行，Update则是生成计划后直接执行
 type TableSchema struct {
 	OldTable *DBTable
 	NewTable *DBTable
+	Policy   *SchemaPolicy //为空则只拒绝破坏性的调整
+	plan     *SchemaPlan
+	backup   map[string]bool //已经备份的旧字段，字段名是大写
 }
 
 //检查新表的字段定义是否合法：
@@ -43,64 +66,84 @@ func (t *TableSchema) CheckTableColumns(tab *DBTable) error {
 	}
 	return nil
 }
+
+//Update 生成调整计划并执行，计划中有破坏性的语句，Policy不允许时返回错误，不执行任何语句
 func (t *TableSchema) Update() error {
+	plan, err := t.Plan()
+	if err != nil {
+		return err
+	}
+	if err := t.Policy.check(plan); err != nil {
+		return err
+	}
+	return plan.Apply(t.NewTable.Db)
+}
+
+//Plan 生成调整计划，只查询数据库中的当前结构，不执行任何调整语句
+func (t *TableSchema) Plan() (*SchemaPlan, error) {
+	t.plan = &SchemaPlan{
+		Driver: t.NewTable.Db.DriverName(),
+		Table:  t.NewTable.Name(),
+		//DDL可以回滚的数据库
+		Transaction: t.NewTable.Db.DriverName() == "postgres" || t.NewTable.Db.DriverName() == "sqlite3",
+	}
+	t.backup = nil
+	if err := t.build(); err != nil {
+		return nil, err
+	}
+	return t.plan, nil
+}
+
+//加入一个计划语句，返回的语句可以再加上补偿语句
+func (t *TableSchema) step(strSql, reason string) *SchemaStep {
+	return t.plan.add(strSql, reason)
+}
+
+func (t *TableSchema) build() error {
 	//如果没有旧表，则是新增表
 	if t.OldTable == nil {
-		cols := []string{}
-		for _, v := range t.NewTable.AllField() {
-			cols = append(cols, v.DBDefine(t.NewTable.Db.DriverName()))
-		}
-		var strSql string
-		if len(t.NewTable.PrimaryKeys()) > 0 {
-			strSql = fmt.Sprintf(
-				"CREATE TABLE %s(\n%s,\nCONSTRAINT %s_pkey PRIMARY KEY(%s)\n)",
-				t.NewTable.Name(), strings.Join(cols, ",\n"), t.NewTable.TableName, strings.Join(t.NewTable.PrimaryKeys(), ","))
-		} else {
-			strSql = fmt.Sprintf(
-				"CREATE TABLE %s(\n%s\n)",
-				t.NewTable.Name(), strings.Join(cols, ",\n"))
+		strSql, err := t.createTableSql(t.NewTable.Name())
+		if err != nil {
+			return err
 		}
-		if _, err := t.NewTable.Db.Exec(strSql); err != nil {
-			return SqlError{strSql, nil, err}
+		t.step(strSql, fmt.Sprintf("create table %s", t.NewTable.Name())).
+			undo(fmt.Sprintf("drop table %s", t.NewTable.quotedName()))
+		//postgres的分区是单独的表
+		if t.NewTable.Partition != nil && t.NewTable.Db.DriverName() == "postgres" {
+			for _, v := range t.NewTable.pgPartitionsSql() {
+				t.step(v, "create partition")
+			}
 		}
-		log.Println(strSql)
-		//最后处理索引
+		//说明
+		if len(t.NewTable.Comment) > 0 {
+			if err := t.setTableComment(); err != nil {
+				return err
+			}
+		}
+		//mysql的字段说明已经在字段定义中
 		for _, col := range t.NewTable.AllField() {
-			if col.Index {
-				if err := CreateColumnIndex(t.NewTable.Db, t.NewTable.Name(), col.Name); err != nil {
+			if len(col.Comment) > 0 && t.NewTable.Db.DriverName() != "mysql" {
+				if err := t.setColumnComment(col, ""); err != nil {
 					return err
 				}
 			}
 		}
-		return nil
+		//最后处理索引
+		return t.createIndexes()
 	} else {
 		if err := t.CheckTableColumns(t.NewTable); err != nil {
 			return err
 		}
-		//处理表更名,处理过后，所有后续操作都在新表名上进行
-		if t.OldTable.Name() != t.NewTable.Name() {
-			if err := TableRename(t.NewTable.Db, t.OldTable.Name(), t.NewTable.Name()); err != nil {
-				return err
-			}
-		}
-		pkChanged := false
-		//如果主键变更，则需要先除去主键
-		if !reflect.DeepEqual(t.OldTable.PrimaryKeys(), t.NewTable.PrimaryKeys()) {
-			log.WithFields(log.Fields{
-				"table": t.OldTable.TableName,
-				"oldpk": t.OldTable.PrimaryKeys(),
-				"newpk": t.NewTable.PrimaryKeys(),
-			}).Info("pk change")
-			if err := DropTablePrimaryKey(t.NewTable.Db, t.NewTable.Name()); err != nil {
-				return err
-			}
-			pkChanged = true
-		}
-		//逐个处理字段，每处理一个字段，旧表字段就标上标记，最后删除没有标记的字段
+		//先找出新字段对应的旧字段，每找到一个字段，旧表字段就标上标记，最后删除没有标记的字段
 		oldColumnProcesses := map[string]bool{}
 		for _, v := range t.OldTable.Columns() {
 			oldColumnProcesses[v] = false
 		}
+		oldColumns := []*DBTableColumn{}
+		//需要重建以转换数据的字段，以及普通字段改成计算字段的，字段上的索引和主键会随字段删除，需要重建
+		rebuildColumns := map[string]bool{}
+		//普通字段改成计算字段，原有数据会丢失，和删除的字段一样归档
+		archived := []*DBTableColumn{}
 		for _, col := range t.NewTable.AllField() {
 			var oldCol *DBTableColumn
 			//如果有曾用名，则用曾用名去旧表中获取旧字段
@@ -120,138 +163,421 @@ func (t *TableSchema) Update() error {
 					oldColumnProcesses[col.Name] = true
 				}
 			}
-			if err := t.processColumn(oldCol, col); err != nil {
+			if oldCol != nil && t.needRebuild(oldCol, col) {
+				rebuildColumns[strings.ToUpper(oldCol.Name)] = true
+			}
+			if oldCol != nil && len(oldCol.Generated) == 0 && len(col.Generated) > 0 {
+				rebuildColumns[strings.ToUpper(oldCol.Name)] = true
+				archived = append(archived, oldCol)
+			}
+			oldColumns = append(oldColumns, oldCol)
+		}
+		//sqlite3除了新增字段和索引，其他的调整都需要重建表
+		if t.NewTable.Db.DriverName() == "sqlite3" {
+			rebuild, err := t.sqliteNeedRebuild(oldColumns, oldColumnProcesses)
+			if err != nil {
 				return err
 			}
+			if rebuild {
+				return t.rebuildTable(oldColumns, archived)
+			}
 		}
-		//最后删除没有处理过的旧字段
 		deleteCols := []string{}
+		dropped := map[string]bool{}
 		for k, prc := range oldColumnProcesses {
 			if !prc {
 				deleteCols = append(deleteCols, k)
+				dropped[strings.ToUpper(k)] = true
+			}
+		}
+		sort.Strings(deleteCols)
+		for k := range rebuildColumns {
+			dropped[k] = true
+		}
+		t.backupColumns(dropped)
+		for _, v := range deleteCols {
+			archived = append(archived, t.OldTable.Field(v))
+		}
+		if err := t.archiveColumns(archived); err != nil {
+			return err
+		}
+		//处理表更名,处理过后，所有后续操作都在新表名上进行
+		if t.OldTable.Name() != t.NewTable.Name() {
+			strSql, err := tableRenameSql(t.NewTable.Db, t.OldTable.Name(), t.NewTable.Name())
+			if err != nil {
+				return err
+			}
+			undo, err := tableRenameSql(t.NewTable.Db, t.NewTable.Name(), t.OldTable.Name())
+			if err != nil {
+				return err
+			}
+			t.step(strSql, fmt.Sprintf("rename table %s to %s", t.OldTable.Name(), t.NewTable.Name())).undo(undo)
+		}
+		if t.OldTable.Comment != t.NewTable.Comment {
+			if err := t.setTableComment(); err != nil {
+				return err
+			}
+		}
+		//先删除变化了的约束，以免约束中引用了要删除的字段
+		newChecks := map[string]*DBCheck{}
+		for _, v := range t.NewTable.Checks {
+			newChecks[strings.ToUpper(v.Name)] = v
+		}
+		oldChecks := map[string]*DBCheck{}
+		for _, v := range t.OldTable.Checks {
+			if nv, ok := newChecks[strings.ToUpper(v.Name)]; ok && nv.Eque(v) {
+				oldChecks[strings.ToUpper(v.Name)] = v
+				continue
+			}
+			strSql, err := dropTableCheckSql(t.NewTable.Db, t.NewTable.Name(), v.Name)
+			if err != nil {
+				return err
+			}
+			undo, err := addTableCheckSql(t.NewTable.Db, t.NewTable.Name(), v.Name, v.Express)
+			if err != nil {
+				return err
+			}
+			t.step(strSql, fmt.Sprintf("check %s changed or removed", v.Name)).undo(undo)
+		}
+		//删除不再需要的索引，放在字段处理之前，以免字段删除时索引已经不存在
+		newIndexes := map[string]bool{}
+		for _, v := range t.NewTable.Indexes {
+			newIndexes[v.signature()] = true
+		}
+		oldIndexes := map[string]bool{}
+		for _, v := range t.OldTable.Indexes {
+			if newIndexes[v.signature()] && !indexOnColumns(v, rebuildColumns) {
+				oldIndexes[v.signature()] = true
+				continue
+			}
+			strSql, err := dropColumnIndexSql(t.NewTable.Db, t.NewTable.Name(), v.Name)
+			if err != nil {
+				return err
 			}
+			undo, err := createTableIndexSql(t.NewTable.Db, t.NewTable.Name(), v)
+			if err != nil {
+				return err
+			}
+			t.step(strSql, fmt.Sprintf("index %s changed or removed", v.Name)).undo(undo)
+		}
+		//删除变化了的外键
+		newForeignKeys := map[string]bool{}
+		for _, v := range t.NewTable.ForeignKeys {
+			fk, err := t.resolveForeignKey(t.NewTable, v)
+			if err != nil {
+				return err
+			}
+			newForeignKeys[fk.signature(t.NewTable.Db.DriverName())] = true
 		}
+		oldForeignKeys := map[string]bool{}
+		for _, v := range t.OldTable.ForeignKeys {
+			fk, err := t.resolveForeignKey(t.OldTable, v)
+			if err != nil {
+				return err
+			}
+			if sign := fk.signature(t.NewTable.Db.DriverName()); newForeignKeys[sign] {
+				oldForeignKeys[sign] = true
+				continue
+			}
+			strSql, err := dropTableForeignKeySql(t.NewTable.Db, t.NewTable.Name(), fk.Name)
+			if err != nil {
+				return err
+			}
+			undo, err := addTableForeignKeySql(t.NewTable.Db, t.NewTable.Name(), fk)
+			if err != nil {
+				return err
+			}
+			t.step(strSql, fmt.Sprintf("foreign key %s changed or removed", fk.Name)).undo(undo)
+		}
+		pkChanged := false
+		//如果主键变更，则需要先除去主键
+		if !reflect.DeepEqual(t.OldTable.PrimaryKeys(), t.NewTable.PrimaryKeys()) ||
+			indexOnColumns(&DBIndex{Columns: t.OldTable.PrimaryKeys()}, rebuildColumns) {
+			//表可能刚在计划中改名，主键名称需要用旧表名获取
+			strSql, err := dropTablePrimaryKeySql(t.NewTable.Db, t.OldTable.Name(), t.NewTable.Name())
+			if err != nil {
+				return err
+			}
+			if len(strSql) > 0 {
+				reason := fmt.Sprintf("primary key change from %v to %v", t.OldTable.PrimaryKeys(), t.NewTable.PrimaryKeys())
+				if reflect.DeepEqual(t.OldTable.PrimaryKeys(), t.NewTable.PrimaryKeys()) {
+					reason = "primary key column rebuild"
+				}
+				//撤销时字段已经恢复成旧的名称
+				undo, err := addTablePrimaryKeySql(t.NewTable.Db, t.NewTable.Name(), t.OldTable.PrimaryKeys())
+				if err != nil {
+					return err
+				}
+				step := t.step(strSql, reason).undo(undo)
+				if reflect.DeepEqual(t.OldTable.PrimaryKeys(), t.NewTable.PrimaryKeys()) {
+					step.narrowing()
+				} else {
+					step.destructive()
+				}
+			}
+			pkChanged = true
+		}
+		//逐个处理字段
+		for i, col := range t.NewTable.AllField() {
+			if err := t.processColumn(oldColumns[i], col); err != nil {
+				return err
+			}
+		}
+		//最后删除没有处理过的旧字段
 		if len(deleteCols) > 0 {
-			if err := TableRemoveColumns(t.NewTable.Db, t.NewTable.Name(), deleteCols); err != nil {
+			cols := []*DBTableColumn{}
+			for _, v := range deleteCols {
+				cols = append(cols, t.OldTable.Field(v))
+			}
+			step, err := t.dropColumns(cols, deleteCols, fmt.Sprintf("columns %s not in new define", strings.Join(deleteCols, ",")))
+			if err != nil {
 				return err
 			}
+			step.destructive()
+		}
+		//新增索引
+		for _, v := range t.NewTable.Indexes {
+			if oldIndexes[v.signature()] {
+				continue
+			}
+			if err := t.createIndex(v); err != nil {
+				return err
+			}
+		}
+		//最后新增约束
+		for _, v := range t.NewTable.Checks {
+			if _, ok := oldChecks[strings.ToUpper(v.Name)]; ok {
+				continue
+			}
+			strSql, err := addTableCheckSql(t.NewTable.Db, t.NewTable.Name(), v.Name, v.Express)
+			if err != nil {
+				return err
+			}
+			undo, err := dropTableCheckSql(t.NewTable.Db, t.NewTable.Name(), v.Name)
+			if err != nil {
+				return err
+			}
+			t.step(strSql, fmt.Sprintf("add check %s", v.Name)).undo(undo)
 		}
 		//如果主键变过，则新增主键
 		if pkChanged {
-			if err := AddTablePrimaryKey(t.NewTable.Db, t.NewTable.Name(), t.NewTable.PrimaryKeys()); err != nil {
+			strSql, err := addTablePrimaryKeySql(t.NewTable.Db, t.NewTable.Name(), t.NewTable.PrimaryKeys())
+			if err != nil {
+				return err
+			}
+			t.step(strSql, fmt.Sprintf("add primary key %v", t.NewTable.PrimaryKeys())).
+				undo(addedPrimaryKeyDropSql(t.NewTable.Db, t.NewTable.Name()))
+		}
+		//最后新增外键，引用本表的外键需要主键先建好
+		for _, v := range t.NewTable.ForeignKeys {
+			fk, err := t.resolveForeignKey(t.NewTable, v)
+			if err != nil {
+				return err
+			}
+			if oldForeignKeys[fk.signature(t.NewTable.Db.DriverName())] {
+				continue
+			}
+			strSql, err := addTableForeignKeySql(t.NewTable.Db, t.NewTable.Name(), fk)
+			if err != nil {
+				return err
+			}
+			undo, err := dropTableForeignKeySql(t.NewTable.Db, t.NewTable.Name(), fk.Name)
+			if err != nil {
 				return err
 			}
+			t.step(strSql, fmt.Sprintf("add foreign key %s references %s", fk.Name, fk.RefTable)).undo(undo)
+		}
+		//全部调整完成后删除备份
+		if len(t.backup) > 0 {
+			t.step(fmt.Sprintf("drop table %s", t.backupName()), "drop backup table")
 		}
 	}
 	return nil
 }
 func (t *TableSchema) processColumn(oldCol, newCol *DBTableColumn) error {
 	var strSql string
-	//如果是新增字段
+	//如果是新增字段，带默认值的not null字段，数据库会用默认值填充已有的行
 	if oldCol == nil {
 		switch t.NewTable.Db.DriverName() {
 		case "postgres", "oci8", "mysql", "sqlite3":
-			strSql = fmt.Sprintf("alter table %s add %s", t.NewTable.Name(), newCol.DBDefine(t.NewTable.Db.DriverName()))
+			strSql = fmt.Sprintf("alter table %s add %s", t.NewTable.quotedName(), newCol.DBDefine(t.NewTable.Db.DriverName()))
 		default:
 			log.Panic("not impl " + t.NewTable.Db.DriverName())
 		}
-		if _, err := t.NewTable.Db.Exec(strSql); err != nil {
-			return SqlError{strSql, nil, err}
+		step := t.step(strSql, fmt.Sprintf("add column %s", newCol.Name))
+		//在事务中执行的不需要撤销，sqlite3也不能删除字段
+		if !t.plan.Transaction {
+			undo, err := tableRemoveColumnsSql(t.NewTable.Db, t.NewTable.Name(), []string{newCol.Name})
+			if err != nil {
+				return err
+			}
+			step.undo(undo)
+		}
+		if len(newCol.Comment) > 0 && t.NewTable.Db.DriverName() != "mysql" {
+			if err := t.setColumnComment(newCol, ""); err != nil {
+				return err
+			}
+		}
+		if len(newCol.Enum) > 0 {
+			if err := t.addEnumCheck(newCol); err != nil {
+				return err
+			}
 		}
-		log.Println(strSql)
 		//处理索引
 		if newCol.Index {
-			if err := CreateColumnIndex(t.NewTable.Db, t.NewTable.Name(), newCol.Name); err != nil {
+			if err := t.createColumnIndex(newCol); err != nil {
 				return err
 			}
-			log.Printf("table %s add column index %s\n", t.NewTable.Name(), newCol.Name)
 		}
 		return nil
 	}
+	//计算字段的表达式变化，或者和普通字段互换，都是删除旧字段后新增，
+	//和普通字段互换的，普通字段的数据会丢失
+	if (len(oldCol.Generated) > 0 || len(newCol.Generated) > 0) && !oldCol.GeneratedEque(newCol) {
+		step, err := t.dropColumns([]*DBTableColumn{oldCol}, []string{oldCol.Name},
+			fmt.Sprintf("column %s generated express changed, drop and add", oldCol.Name))
+		if err != nil {
+			return err
+		}
+		if len(oldCol.Generated) == 0 || len(newCol.Generated) == 0 {
+			step.destructive()
+		}
+		return t.processColumn(nil, newCol)
+	}
 	//如果是更名，需要先处理
 	if oldCol.Name != newCol.Name {
+		var undo string
 		switch t.NewTable.Db.DriverName() {
 		case "postgres":
-			strSql = fmt.Sprintf("alter table %s rename %s to %s", t.NewTable.Name(), oldCol.Name, newCol.Name)
-			if _, err := t.NewTable.Db.Exec(strSql); err != nil {
-				return SqlError{strSql, nil, err}
-			}
-			log.Println(strSql)
+			strSql = fmt.Sprintf("alter table %s rename %s to %s", t.NewTable.quotedName(), t.quote(oldCol.Name), t.quote(newCol.Name))
 		case "oci8":
-			strSql = fmt.Sprintf("alter table %s rename column %s to %s", t.NewTable.Name(), oldCol.Name, newCol.Name)
-			if _, err := t.NewTable.Db.Exec(strSql); err != nil {
-				return SqlError{strSql, nil, err}
-			}
-			log.Println(strSql)
+			strSql = fmt.Sprintf("alter table %s rename column %s to %s", t.NewTable.quotedName(), t.quote(oldCol.Name), t.quote(newCol.Name))
+			undo = fmt.Sprintf("alter table %s rename column %s to %s", t.NewTable.quotedName(), t.quote(newCol.Name), t.quote(oldCol.Name))
 		case "mysql":
-			strSql = fmt.Sprintf("alter table %s CHANGE column %s %s", t.NewTable.Name(), oldCol.Name, newCol.DBDefine(t.NewTable.Db.DriverName()))
-			if _, err := t.NewTable.Db.Exec(strSql); err != nil {
-				return SqlError{strSql, nil, err}
-			}
-			log.Println(strSql)
+			strSql = fmt.Sprintf("alter table %s CHANGE column %s %s", t.NewTable.quotedName(), t.quote(oldCol.Name), newCol.DBDefine(t.NewTable.Db.DriverName()))
+			undo = fmt.Sprintf("alter table %s CHANGE column %s %s", t.NewTable.quotedName(), t.quote(newCol.Name), oldCol.DBDefine(t.NewTable.Db.DriverName()))
 		default:
 			log.Panic("not impl " + t.NewTable.Db.DriverName())
 		}
+		step := t.step(strSql, fmt.Sprintf("rename column %s to %s", oldCol.Name, newCol.Name))
+		if len(undo) > 0 {
+			step.undo(undo)
+		}
+		//mysql改名时同时修改了字段定义
+		if t.NewTable.Db.DriverName() == "mysql" && !t.needRebuild(oldCol, newCol) && narrowColumn(oldCol, newCol) {
+			step.narrowing()
+		}
+	}
+	//字段由null改成not null，且有默认值，则先用默认值填充空值
+	if oldCol.Null && !newCol.Null && len(newCol.Default) > 0 {
+		strSql = fmt.Sprintf("update %s set %s=%s where %s is null", t.NewTable.quotedName(), t.quote(newCol.Name),
+			newCol.defaultExpress(t.NewTable.Db.DriverName()), t.quote(newCol.Name))
+		t.step(strSql, fmt.Sprintf("column %s change to not null, fill null with default", newCol.Name))
+	}
+	//类型变化需要转换数据的，用临时字段转换后替换原字段，重建后的字段只有类型和是否为空，
+	//默认值、说明、枚举、索引等都按新增处理
+	rebuild := t.needRebuild(oldCol, newCol)
+	if rebuild {
+		if err := t.rebuildColumn(oldCol, newCol); err != nil {
+			return err
+		}
+		rebuilt := newCol.Clone()
+		rebuilt.Name = oldCol.Name
+		rebuilt.Default = ""
+		rebuilt.Identity = false
+		rebuilt.Comment = ""
+		rebuilt.Enum = nil
+		rebuilt.Index = false
+		rebuilt.IndexName = ""
+		oldCol = rebuilt
 	}
-	//如果字段定义不相等且不是mysql则需要再次修改字段定义
-	if !oldCol.Eque(newCol) && t.NewTable.Db.DriverName() != "mysql" {
+	//如果字段定义不相等则需要再次修改字段定义，mysql改名时已经同时修改了字段定义
+	renamed := oldCol.Name != newCol.Name && t.NewTable.Db.DriverName() == "mysql"
+	if !rebuild && !oldCol.Eque(newCol) && !renamed {
 		switch t.NewTable.Db.DriverName() {
 		case "postgres":
 			//先改类型,如果都有truetype，则直接判断truetype
 			if (oldCol.FetchDriver == newCol.FetchDriver &&
 				len(oldCol.TrueType) > 0 && len(newCol.TrueType) > 0 &&
 				oldCol.TrueType != newCol.TrueType) ||
-				(oldCol.Type != newCol.Type ||
+				(!oldCol.typeEque(newCol) ||
 					(oldCol.Type == "STR" &&
-						oldCol.MaxLength != newCol.MaxLength)) {
-				//去掉最后的notnull
+						oldCol.MaxLength != newCol.MaxLength) ||
+					(oldCol.Type == "DEC" &&
+						(oldCol.Precision != newCol.Precision || oldCol.Scale != newCol.Scale))) {
+				//去掉最后的notnull，类型变化时用using转换原有的数据
 				strSql = fmt.Sprintf(
 					"alter table %s ALTER COLUMN %s type %s",
-					t.NewTable.Name(), newCol.Name, newCol.DBType(t.NewTable.Db.DriverName()))
+					t.NewTable.quotedName(), t.quote(newCol.Name), newCol.DBType(t.NewTable.Db.DriverName()))
+				if !oldCol.typeEque(newCol) || len(newCol.Convert) > 0 {
+					if err := t.checkConvert(oldCol, newCol); err != nil {
+						return err
+					}
+					strSql += " using " + newCol.convertExpress(t.NewTable.Db.DriverName(), oldCol)
+				}
 				//去掉定义中的字段名，因为中间多了个type字样
-				if _, err := t.NewTable.Db.Exec(strSql); err != nil {
-					return SqlError{strSql, nil, err}
+				step := t.step(strSql, fmt.Sprintf("column %s type change from %s to %s",
+					newCol.Name, oldCol.DBType(t.NewTable.Db.DriverName()), newCol.DBType(t.NewTable.Db.DriverName())))
+				if narrowColumn(oldCol, newCol) {
+					step.narrowing()
 				}
-				log.Println(strSql)
 			}
 			//再改not null
 			if oldCol.Null && !newCol.Null {
 				strSql = fmt.Sprintf(
 					"alter table %s alter column %s set not null",
-					t.NewTable.Name(), newCol.Name)
-				if _, err := t.NewTable.Db.Exec(strSql); err != nil {
-					return SqlError{strSql, nil, err}
-				}
-				log.Println(strSql)
+					t.NewTable.quotedName(), t.quote(newCol.Name))
+				t.step(strSql, fmt.Sprintf("column %s change to not null", newCol.Name))
 			}
 			if !oldCol.Null && newCol.Null {
 				strSql = fmt.Sprintf(
 					"alter table %s alter column %s drop not null",
-					t.NewTable.Name(), newCol.Name)
-				if _, err := t.NewTable.Db.Exec(strSql); err != nil {
-					return SqlError{strSql, nil, err}
-				}
-				log.Println(strSql)
+					t.NewTable.quotedName(), t.quote(newCol.Name))
+				t.step(strSql, fmt.Sprintf("column %s change to null", newCol.Name))
 			}
 
 		case "mysql":
-			strSql = fmt.Sprintf("alter table %s MODIFY %s", t.NewTable.Name(), newCol.DBDefine(t.NewTable.Db.DriverName()))
-			if _, err := t.NewTable.Db.Exec(strSql); err != nil {
-				return SqlError{strSql, nil, err}
+			strSql = fmt.Sprintf("alter table %s MODIFY %s", t.NewTable.quotedName(), newCol.DBDefine(t.NewTable.Db.DriverName()))
+			step := t.step(strSql, fmt.Sprintf("column %s define changed", newCol.Name)).
+				undo(fmt.Sprintf("alter table %s MODIFY %s", t.NewTable.quotedName(), oldCol.DBDefine(t.NewTable.Db.DriverName())))
+			if narrowColumn(oldCol, newCol) {
+				step.narrowing()
 			}
-			log.Println(strSql)
 		case "oci8":
+			//类型变化时需要带上check约束，例如改成布尔
+			check := ""
+			if !oldCol.typeEque(newCol) {
+				check = newCol.dbCheck(t.NewTable.Db.DriverName())
+			}
+			//撤销时恢复类型和是否为空，是否为空没有变化的不能再设置，新增的check约束没有名称，不能撤销
+			undo := ""
+			if oldCol.DBType(t.NewTable.Db.DriverName()) != newCol.DBType(t.NewTable.Db.DriverName()) {
+				undo = " " + oldCol.DBType(t.NewTable.Db.DriverName())
+			}
+			if oldCol.Null && !newCol.Null {
+				undo += " NULL"
+			} else if !oldCol.Null && newCol.Null {
+				undo += " NOT NULL"
+			}
 			if oldCol.Null != newCol.Null {
-				strSql = fmt.Sprintf("alter table %s MODIFY %s", t.NewTable.Name(), newCol.DBDefineNull(t.NewTable.Db.DriverName()))
+				strSql = fmt.Sprintf("alter table %s MODIFY %s%s", t.NewTable.quotedName(), newCol.DBDefineNull(t.NewTable.Db.DriverName()), check)
 
+			} else if len(check) > 0 && oldCol.DBType(t.NewTable.Db.DriverName()) == newCol.DBType(t.NewTable.Db.DriverName()) {
+				//数据类型没有变化，只是增加约束，例如clob改成json，lob字段是不能modify的
+				strSql = fmt.Sprintf("alter table %s add%s", t.NewTable.quotedName(), check)
 			} else {
-				strSql = fmt.Sprintf("alter table %s MODIFY %s %s", t.NewTable.Name(), newCol.Name, newCol.DBType(t.NewTable.Db.DriverName()))
+				strSql = fmt.Sprintf("alter table %s MODIFY %s %s%s", t.NewTable.quotedName(), t.quote(newCol.Name), newCol.DBType(t.NewTable.Db.DriverName()), check)
 
 			}
-			if _, err := t.NewTable.Db.Exec(strSql); err != nil {
-				return SqlError{strSql, nil, err}
+			step := t.step(strSql, fmt.Sprintf("column %s define changed", newCol.Name))
+			if narrowColumn(oldCol, newCol) {
+				step.narrowing()
+			}
+			if len(check) > 0 {
+				step.irreversible()
+			} else if len(undo) > 0 {
+				step.undo(fmt.Sprintf("alter table %s MODIFY %s%s", t.NewTable.quotedName(), t.quote(newCol.Name), undo))
 			}
-			log.Println(strSql)
 
 		default:
 			log.WithFields(log.Fields{
@@ -265,20 +591,830 @@ func (t *TableSchema) processColumn(oldCol, newCol *DBTableColumn) error {
 			}).Panic("change column define not impl")
 		}
 	}
+	//先去掉自增，自增字段不能再设置默认值
+	oldDefault := oldCol.defaultExpress(t.NewTable.Db.DriverName())
+	defaultChanged := !oldCol.DefaultEque(newCol)
+	if oldCol.Identity && !newCol.Identity && !oldCol.ImplicitIdentity {
+		if err := t.dropColumnIdentity(oldCol, newCol); err != nil {
+			return err
+		}
+		oldDefault = ""
+		defaultChanged = len(normalizeDefault(newCol.Default)) > 0
+	}
+	//默认值变化，放在类型调整之后，以免旧类型不兼容新的默认值
+	if !oldCol.ImplicitIdentity && !newCol.Identity && defaultChanged {
+		var undo string
+		switch t.NewTable.Db.DriverName() {
+		case "postgres", "mysql", "oci8":
+			strSql = columnDefaultSql(t.NewTable.Db.DriverName(), t.NewTable.Name(), newCol.Name, newCol.defaultExpress(t.NewTable.Db.DriverName()))
+			undo = columnDefaultSql(t.NewTable.Db.DriverName(), t.NewTable.Name(), newCol.Name, oldDefault)
+		default:
+			log.WithFields(log.Fields{
+				"table":      t.OldTable.TableName,
+				"column":     oldCol.Name,
+				"olddefault": oldCol.Default,
+				"newdefault": newCol.Default,
+				"driver":     t.NewTable.Db.DriverName(),
+			}).Panic("change column default not impl")
+		}
+		step := t.step(strSql, fmt.Sprintf("column %s default change from %q to %q", newCol.Name, oldDefault, newCol.Default)).undo(undo)
+		//定义中没有声明默认值的，去掉数据库中已有的默认值，插入时不再自动填充
+		if len(normalizeDefault(newCol.Default)) == 0 {
+			step.narrowing()
+		}
+	}
+	//最后增加自增
+	if !oldCol.Identity && newCol.Identity {
+		if err := t.addColumnIdentity(oldCol, newCol); err != nil {
+			return err
+		}
+	}
+	//枚举值变化，约束名称中有表名和字段名，改名时也需要重建
+	if !reflect.DeepEqual(oldCol.Enum, newCol.Enum) ||
+		t.OldTable.enumCheckName(oldCol.Name) != t.NewTable.enumCheckName(newCol.Name) {
+		if len(oldCol.Enum) > 0 {
+			strSql, err := dropTableCheckSql(t.NewTable.Db, t.NewTable.Name(), t.OldTable.enumCheckName(oldCol.Name))
+			if err != nil {
+				return err
+			}
+			//撤销时字段已经是新名称
+			renamed := oldCol.Clone()
+			renamed.Name = newCol.Name
+			undo, err := addTableCheckSql(t.NewTable.Db, t.NewTable.Name(), t.OldTable.enumCheckName(oldCol.Name), renamed.enumExpress(t.NewTable.Db.DriverName()))
+			if err != nil {
+				return err
+			}
+			t.step(strSql, fmt.Sprintf("column %s enum changed", newCol.Name)).undo(undo)
+		}
+		if len(newCol.Enum) > 0 {
+			if err := t.addEnumCheck(newCol); err != nil {
+				return err
+			}
+		}
+	}
+	if oldCol.Comment != newCol.Comment {
+		if err := t.setColumnComment(newCol, oldCol.Comment); err != nil {
+			return err
+		}
+	}
 	//处理索引,字段更名的操作，oracle、postgres、mysql都是安全的，所以不需处理
 	//ref:http://stackoverflow.com/questions/6732896/does-rename-column-take-care-of-indexes
 	if oldCol.Index && !newCol.Index {
 		//删除索引
-		if err := DropColumnIndex(t.NewTable.Db, t.NewTable.Name(), oldCol.IndexName); err != nil {
+		strSql, err := dropColumnIndexSql(t.NewTable.Db, t.NewTable.Name(), oldCol.IndexName)
+		if err != nil {
+			return err
+		}
+		undo, err := createTableIndexSql(t.NewTable.Db, t.NewTable.Name(), &DBIndex{Name: oldCol.IndexName, Columns: []string{newCol.Name}})
+		if err != nil {
 			return err
 		}
-		log.Printf("drop table %s column %s index %s\n", t.NewTable.Name(), newCol.Name, oldCol.IndexName)
+		t.step(strSql, fmt.Sprintf("drop column %s index %s", newCol.Name, oldCol.IndexName)).undo(undo)
 	} else if !oldCol.Index && newCol.Index {
-		//新增索引
-		if err := CreateColumnIndex(t.NewTable.Db, t.NewTable.Name(), oldCol.Name); err != nil {
+		//新增索引，字段更名已经在前面的语句中完成
+		return t.createColumnIndex(newCol)
+	}
+	return nil
+}
+
+//建表语句，包括字段、check约束、外键、主键以及分区，name是建立的表名
+func (t *TableSchema) createTableSql(name string) (string, error) {
+	cols := []string{}
+	for _, v := range t.NewTable.AllField() {
+		cols = append(cols, v.DBDefine(t.NewTable.Db.DriverName()))
+	}
+	cols = append(cols, t.NewTable.checkDefines()...)
+	for _, v := range t.NewTable.ForeignKeys {
+		fk, err := t.resolveForeignKey(t.NewTable, v)
+		if err != nil {
+			return "", err
+		}
+		cols = append(cols, fk.define(t.NewTable.Db.DriverName()))
+	}
+	var strSql string
+	if len(t.NewTable.PrimaryKeys()) > 0 {
+		//postgres的主键名和不指定名称时数据库生成的一致
+		pkName := objectName("%s_PKEY", t.NewTable.TableName)
+		if t.NewTable.Db.DriverName() == "postgres" {
+			pkName = primaryKeyName(t.NewTable.Db, t.NewTable.TableName)
+		}
+		strSql = fmt.Sprintf(
+			"CREATE TABLE %s(\n%s,\nCONSTRAINT %s PRIMARY KEY(%s)\n)",
+			t.quote(name), strings.Join(cols, ",\n"), t.quote(pkName), strings.Join(quoteNames(t.NewTable.Db.DriverName(), t.NewTable.PrimaryKeys()), ","))
+	} else {
+		strSql = fmt.Sprintf(
+			"CREATE TABLE %s(\n%s\n)",
+			t.quote(name), strings.Join(cols, ",\n"))
+	}
+	if t.NewTable.Partition != nil {
+		clause, err := t.NewTable.partitionClause()
+		if err != nil {
+			return "", err
+		}
+		strSql += " " + clause
+	}
+	return strSql, nil
+}
+
+//新建表的所有索引
+func (t *TableSchema) createIndexes() error {
+	for _, col := range t.NewTable.AllField() {
+		if col.Index {
+			if err := t.createColumnIndex(col); err != nil {
+				return err
+			}
+		}
+	}
+	for _, idx := range t.NewTable.Indexes {
+		if err := t.createIndex(idx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//sqlite3的alter table add column可以新增的字段：不能是自增，not null的要有默认值，默认值只能是常量
+var sqliteConstReg = regexp.MustCompile(`(?i)^('([^']|'')*'|[-+]?[0-9.]+|null|true|false)$`)
+
+//sqlite3只能新增字段、索引以及表改名，其他的调整都需要重建表
+func (t *TableSchema) sqliteNeedRebuild(oldColumns []*DBTableColumn, oldColumnProcesses map[string]bool) (bool, error) {
+	if !reflect.DeepEqual(t.OldTable.PrimaryKeys(), t.NewTable.PrimaryKeys()) {
+		return true, nil
+	}
+	for _, prc := range oldColumnProcesses {
+		if !prc {
+			return true, nil
+		}
+	}
+	for i, col := range t.NewTable.AllField() {
+		old := oldColumns[i]
+		if old == nil {
+			if col.Identity || len(col.Enum) > 0 ||
+				!col.Null && len(col.Default) == 0 ||
+				len(col.Default) > 0 && !sqliteConstReg.MatchString(strings.TrimSpace(col.Default)) {
+				return true, nil
+			}
+			continue
+		}
+		if !old.Eque(col) || !old.DefaultEque(col) || !identityEque(old, col) ||
+			!old.GeneratedEque(col) || !reflect.DeepEqual(old.Enum, col.Enum) {
+			return true, nil
+		}
+	}
+	if len(t.OldTable.Checks) != len(t.NewTable.Checks) {
+		return true, nil
+	}
+	checks := map[string]*DBCheck{}
+	for _, v := range t.OldTable.Checks {
+		checks[strings.ToUpper(v.Name)] = v
+	}
+	for _, v := range t.NewTable.Checks {
+		if old, ok := checks[strings.ToUpper(v.Name)]; !ok || !old.Eque(v) {
+			return true, nil
+		}
+	}
+	if len(t.OldTable.ForeignKeys) != len(t.NewTable.ForeignKeys) {
+		return true, nil
+	}
+	foreignKeys := map[string]bool{}
+	for _, v := range t.OldTable.ForeignKeys {
+		fk, err := t.resolveForeignKey(t.OldTable, v)
+		if err != nil {
+			return false, err
+		}
+		foreignKeys[fk.signature("sqlite3")] = true
+	}
+	for _, v := range t.NewTable.ForeignKeys {
+		fk, err := t.resolveForeignKey(t.NewTable, v)
+		if err != nil {
+			return false, err
+		}
+		if !foreignKeys[fk.signature("sqlite3")] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//字段的自增是否相同，数据库隐含的自增字段，定义中没有声明identity的也相同
+func identityEque(oldCol, newCol *DBTableColumn) bool {
+	return oldCol.Identity == newCol.Identity || oldCol.ImplicitIdentity && !newCol.Identity
+}
+
+//sqlite3重建表：建立新结构的临时表，复制数据，删除旧表，临时表改名，再建索引和触发器，整个计划在一个事务中执行
+//外键打开时，删除旧表会隐含执行delete，触发子表的级联删除，所以在事务外关闭外键，提交前检查外键，完成后再打开
+//oldColumns是新表每个字段对应的旧字段，没有的是新增字段
+//regenerated是改成计算字段的旧字段，数据会丢失
+func (t *TableSchema) rebuildTable(oldColumns, regenerated []*DBTableColumn) error {
+	t.plan.Transaction = true
+	fkOn, err := GetSqlFun(t.NewTable.Db, "PRAGMA foreign_keys", nil)
+	if err != nil {
+		return err
+	}
+	//删除表时触发器也被删除，需要重建
+	triggers := []string{}
+	strSql := fmt.Sprintf("select sql from sqlite_master where type='trigger' and upper(tbl_name)=upper('%s') and sql is not null",
+		t.OldTable.TableName)
+	if err := t.NewTable.Db.Select(&triggers, strSql); err != nil {
+		return SqlError{strSql, nil, err}
+	}
+	reason := fmt.Sprintf("rebuild table %s", t.OldTable.Name())
+	//没有对应新字段的旧字段会随旧表删除
+	kept := map[*DBTableColumn]bool{}
+	for _, v := range oldColumns {
+		kept[v] = true
+	}
+	dropped := []*DBTableColumn{}
+	droppedNames := []string{}
+	for _, v := range t.OldTable.AllField() {
+		if !kept[v] {
+			dropped = append(dropped, v)
+			droppedNames = append(droppedNames, v.Name)
+		}
+	}
+	if err := t.archiveColumns(append(append([]*DBTableColumn{}, dropped...), regenerated...)); err != nil {
+		return err
+	}
+	tmpName := "DBX_NEW_" + t.NewTable.TableName
+	if len(t.NewTable.Schema) > 0 {
+		tmpName = t.NewTable.Schema + "." + tmpName
+	}
+	if safe.Int(fkOn) == 1 {
+		t.plan.Prepare = append(t.plan.Prepare, "PRAGMA foreign_keys=OFF")
+		t.plan.Finish = append(t.plan.Finish, "PRAGMA foreign_keys=ON")
+	}
+	strSql, err = t.createTableSql(tmpName)
+	if err != nil {
+		return err
+	}
+	t.step(strSql, reason)
+	//复制数据，先把旧字段名改成新的字段名，转换表达式中用的是新名称
+	alias := []string{}
+	cols := []string{}
+	values := []string{}
+	narrow := false
+	for i, col := range t.NewTable.AllField() {
+		old := oldColumns[i]
+		if old == nil || len(col.Generated) > 0 {
+			continue
+		}
+		alias = append(alias, fmt.Sprintf("%s AS %s", t.quote(old.Name), t.quote(col.Name)))
+		cols = append(cols, t.quote(col.Name))
+		narrow = narrow || narrowColumn(old, col)
+		if !old.typeEque(col) || len(col.Convert) > 0 {
+			if err := t.checkConvert(old, col); err != nil {
+				return err
+			}
+			values = append(values, col.convertExpress("sqlite3", old))
+		} else {
+			values = append(values, t.quote(col.Name))
+		}
+	}
+	if len(cols) > 0 {
+		step := t.step(fmt.Sprintf("insert into %s(%s) select %s from (select %s from %s) t",
+			t.quote(tmpName), strings.Join(cols, ","), strings.Join(values, ","), strings.Join(alias, ","), t.OldTable.quotedName()), "copy data")
+		if narrow {
+			step.narrowing()
+		}
+	}
+	if len(dropped) > 0 {
+		t.step(fmt.Sprintf("drop table %s", t.OldTable.quotedName()),
+			fmt.Sprintf("%s, columns %s not in new define", reason, strings.Join(droppedNames, ","))).destructive()
+	} else if len(regenerated) > 0 {
+		names := []string{}
+		for _, v := range regenerated {
+			names = append(names, v.Name)
+		}
+		t.step(fmt.Sprintf("drop table %s", t.OldTable.quotedName()),
+			fmt.Sprintf("%s, columns %s change to generated", reason, strings.Join(names, ","))).destructive()
+	} else {
+		step := t.step(fmt.Sprintf("drop table %s", t.OldTable.quotedName()), reason)
+		if !reflect.DeepEqual(t.OldTable.PrimaryKeys(), t.NewTable.PrimaryKeys()) {
+			step.destructive()
+		}
+	}
+	//sqlite3改名的新表名不能带schema
+	strSql, err = tableRenameSql(t.NewTable.Db, tmpName, t.NewTable.TableName)
+	if err != nil {
+		return err
+	}
+	t.step(strSql, reason)
+	if err := t.createIndexes(); err != nil {
+		return err
+	}
+	for _, v := range triggers {
+		t.step(v, "recreate trigger")
+	}
+	if safe.Int(fkOn) == 1 {
+		t.step("PRAGMA foreign_key_check", "check foreign keys").check()
+	}
+	return nil
+}
+
+//sqlite3不支持的结构调整，例如删除字段、修改主键，用重建表的方式完成，change修改获取到的表结构
+func sqliteRebuild(db DB, tableName string, change func(tab *DBTable)) error {
+	old := NewTable(db, tableName)
+	old.FetchColumns()
+	tab := old.Clone()
+	change(tab)
+	sch := &TableSchema{
+		OldTable: old,
+		NewTable: tab,
+		plan: &SchemaPlan{
+			Driver: db.DriverName(),
+			Table:  tab.Name(),
+		},
+	}
+	oldColumns := []*DBTableColumn{}
+	for _, col := range tab.AllField() {
+		oldColumns = append(oldColumns, old.Field(col.Name))
+	}
+	if err := sch.rebuildTable(oldColumns, nil); err != nil {
+		return err
+	}
+	return sch.plan.Apply(db)
+}
+
+//oracle、mysql的字段类型变化时，不能直接修改有数据的字段，需要重建字段
+//类型相同只是长度变化的，以及数据库类型没有变化的，例如clob改成json，不需要重建
+func (t *TableSchema) needRebuild(oldCol, newCol *DBTableColumn) bool {
+	switch t.NewTable.Db.DriverName() {
+	case "oci8", "mysql":
+		if len(oldCol.Generated) > 0 || len(newCol.Generated) > 0 {
+			return false
+		}
+		return (!oldCol.typeEque(newCol) || len(newCol.Convert) > 0) &&
+			oldCol.DBType(t.NewTable.Db.DriverName()) != newCol.DBType(t.NewTable.Db.DriverName())
+	}
+	return false
+}
+
+//索引中是否含有指定的字段，字段名是大写
+func indexOnColumns(idx *DBIndex, cols map[string]bool) bool {
+	for _, v := range idx.Columns {
+		if cols[strings.ToUpper(v)] {
+			return true
+		}
+	}
+	return false
+}
+
+//新增临时字段，转换复制原有数据后，删除原字段，再将临时字段改名，字段此时已经是新名称
+func (t *TableSchema) rebuildColumn(oldCol, newCol *DBTableColumn) error {
+	if err := t.checkConvert(oldCol, newCol); err != nil {
+		return err
+	}
+	driver := t.NewTable.Db.DriverName()
+	tabName := t.NewTable.quotedName()
+	reason := fmt.Sprintf("column %s type change from %s to %s, convert data", newCol.Name, oldCol.DBType(driver), newCol.DBType(driver))
+	tmp := newCol.Clone()
+	tmp.Name = shortName("DBX_"+newCol.Name, 30)
+	tmp.Null = true
+	tmp.Default = ""
+	tmp.Identity = false
+	tmp.Comment = ""
+	undo, err := tableRemoveColumnsSql(t.NewTable.Db, tabName, []string{tmp.Name})
+	if err != nil {
+		return err
+	}
+	t.step(fmt.Sprintf("alter table %s add %s", tabName, tmp.DBDefine(driver)), reason).undo(undo)
+	t.step(fmt.Sprintf("update %s set %s=%s where %s is not null",
+		tabName, t.quote(tmp.Name), newCol.convertExpress(driver, oldCol), t.quote(newCol.Name)), reason)
+	//原有的数据已经转换到临时字段，删除原字段是否丢失数据取决于转换
+	step, err := t.dropColumns([]*DBTableColumn{oldCol}, []string{newCol.Name}, reason)
+	if err != nil {
+		return err
+	}
+	if narrowColumn(oldCol, newCol) {
+		step.narrowing()
+	}
+	switch driver {
+	case "oci8":
+		t.step(fmt.Sprintf("alter table %s rename column %s to %s", tabName, t.quote(tmp.Name), t.quote(newCol.Name)), reason).
+			undo(fmt.Sprintf("alter table %s rename column %s to %s", tabName, t.quote(newCol.Name), t.quote(tmp.Name)))
+		if !newCol.Null {
+			t.step(fmt.Sprintf("alter table %s modify %s not null", tabName, t.quote(newCol.Name)), reason).
+				undo(fmt.Sprintf("alter table %s modify %s null", tabName, t.quote(newCol.Name)))
+		}
+	case "mysql":
+		renamed := tmp.Clone()
+		renamed.Name = newCol.Name
+		renamed.Null = newCol.Null
+		t.step(fmt.Sprintf("alter table %s CHANGE column %s %s", tabName, t.quote(tmp.Name), renamed.DBDefine(driver)), reason).
+			undo(fmt.Sprintf("alter table %s CHANGE column %s %s", tabName, t.quote(newCol.Name), tmp.DBDefine(driver)))
+	}
+	return nil
+}
+
+//转换字段类型时，用数据库的转换规则检查原有的数据是否都能转换，不能转换的行数记录在计划中，
+//每个字段只记录前convertSampleRows行的主键，转换表达式执行出错的，错误信息也记录在计划中
+func (t *TableSchema) checkConvert(oldCol, newCol *DBTableColumn) error {
+	driver := t.NewTable.Db.DriverName()
+	fail := newCol.convertFailExpress(driver, oldCol)
+	if len(fail) == 0 {
+		return nil
+	}
+	//计划生成时表和字段都还没有改名，原字段用新名称作为别名，和转换表达式中的名称一致
+	from := fmt.Sprintf("(select * from %s) t", t.OldTable.quotedName())
+	if oldCol.Name != newCol.Name {
+		from = fmt.Sprintf("(select t0.*,%s AS %s from %s t0) t", t.quote(oldCol.Name), t.quote(newCol.Name), t.OldTable.quotedName())
+	}
+	//表达式中可能有postgres的::，不能按命名参数绑定
+	where := fmt.Sprintf("%s is not null and %s", t.quote(newCol.Name), fail)
+	strSql := fmt.Sprintf("select count(*) from %s where %s", from, where)
+	var count int64
+	if err := t.NewTable.Db.Get(&count, strSql); err != nil {
+		//检查的函数不支持时，直接执行转换，出错即不能转换
+		strSql = fmt.Sprintf("select count(*) from (select %s as v from %s where %s is not null) c",
+			newCol.convertExpress(driver, oldCol), from, t.quote(newCol.Name))
+		if err = t.NewTable.Db.Get(&count, strSql); err != nil {
+			t.plan.FailedRows++
+			t.plan.Failures = append(t.plan.Failures, &ConvertFailure{
+				Table:  t.NewTable.Name(),
+				Column: newCol.Name,
+				Value:  err.Error(),
+			})
+		}
+		return nil
+	}
+	if count == 0 {
+		return nil
+	}
+	t.plan.FailedRows += count
+	pks := t.OldTable.PrimaryKeys()
+	strSql = fmt.Sprintf("select %s from %s where %s",
+		strings.Join(quoteNames(driver, append(append([]string{}, pks...), newCol.Name)), ","), from, where)
+	if driver == "oci8" {
+		strSql = fmt.Sprintf("select * from (%s) where rownum<=%d", strSql, convertSampleRows)
+	} else {
+		strSql = fmt.Sprintf("%s limit %d", strSql, convertSampleRows)
+	}
+	rows, err := t.NewTable.Db.Queryx(strSql)
+	if err != nil {
+		return SqlError{strSql, nil, err}
+	}
+	defer rows.Close()
+	for rows.Next() {
+		values, err := rows.SliceScan()
+		if err != nil {
+			return SqlError{strSql, nil, err}
+		}
+		keys := map[string]interface{}{}
+		for i, k := range pks {
+			keys[k] = values[i]
+		}
+		t.plan.Failures = append(t.plan.Failures, &ConvertFailure{
+			Table:  t.NewTable.Name(),
+			Column: newCol.Name,
+			Keys:   keys,
+			Value:  safe.String(values[len(pks)]),
+		})
+	}
+	return rows.Err()
+}
+
+//新增字段的枚举值约束
+func (t *TableSchema) addEnumCheck(col *DBTableColumn) error {
+	strSql, err := addTableCheckSql(t.NewTable.Db, t.NewTable.Name(), t.NewTable.enumCheckName(col.Name), col.enumExpress(t.NewTable.Db.DriverName()))
+	if err != nil {
+		return err
+	}
+	undo, err := dropTableCheckSql(t.NewTable.Db, t.NewTable.Name(), t.NewTable.enumCheckName(col.Name))
+	if err != nil {
+		return err
+	}
+	t.step(strSql, fmt.Sprintf("column %s enum %v", col.Name, col.Enum)).undo(undo)
+	return nil
+}
+
+//已有字段改成自增字段，原有的数据需要保留，所以自增的起始值从最大值开始
+//oracle不能给已有字段加identity，改用序列作为默认值
+func (t *TableSchema) addColumnIdentity(oldCol, col *DBTableColumn) error {
+	tabName := t.NewTable.quotedName()
+	reason := fmt.Sprintf("column %s change to identity", col.Name)
+	switch t.NewTable.Db.DriverName() {
+	case "postgres":
+		t.step(fmt.Sprintf("alter table %s alter column %s add generated by default as identity", tabName, t.quote(col.Name)), reason)
+		//pg_get_serial_sequence的字段名参数不转换大小写，用保存的名称
+		t.step(fmt.Sprintf("select setval(pg_get_serial_sequence('%s','%s'),coalesce(max(%s),0)+1,false) from %s",
+			tabName, storedName("postgres", col.Name), t.quote(col.Name), tabName), "identity start from max value")
+	case "oci8":
+		//计划生成时表和字段都还没有改名
+		strSql := fmt.Sprintf("select nvl(max(%s),0)+1 from %s", t.quote(oldCol.Name), t.OldTable.quotedName())
+		start, err := GetSqlFun(t.NewTable.Db, strSql, nil)
+		if err != nil {
+			return SqlError{strSql, nil, err}
+		}
+		seqName := objectName("%s_%s_SEQ", t.NewTable.TableName, col.Name)
+		if len(t.NewTable.Schema) > 0 {
+			seqName = t.NewTable.Schema + "." + seqName
+		}
+		seqName = t.quote(seqName)
+		t.step(fmt.Sprintf("create sequence %s start with %d", seqName, safe.Int(start)), reason).
+			undo(fmt.Sprintf("drop sequence %s", seqName))
+		t.step(fmt.Sprintf("alter table %s modify %s default %s.nextval", tabName, t.quote(col.Name), seqName), reason).
+			undo(columnDefaultSql("oci8", tabName, col.Name, oldCol.Default))
+	case "mysql":
+		t.step(fmt.Sprintf("alter table %s MODIFY %s", tabName, col.DBDefine(t.NewTable.Db.DriverName())), reason).
+			undo(fmt.Sprintf("alter table %s MODIFY %s", tabName, t.renamedColumn(oldCol, col).DBDefine("mysql")))
+	default:
+		log.WithFields(log.Fields{
+			"table":  tabName,
+			"column": col.Name,
+			"driver": t.NewTable.Db.DriverName(),
+		}).Panic("add column identity not impl")
+	}
+	return nil
+}
+
+//修改字段默认值的语句，默认值为空则去掉默认值
+func columnDefaultSql(driver, tabName, colName, def string) string {
+	switch driver {
+	case "oci8":
+		if len(def) > 0 {
+			return fmt.Sprintf("alter table %s modify %s default %s", QuoteName(driver, tabName), QuoteName(driver, colName), def)
+		}
+		return fmt.Sprintf("alter table %s modify %s default null", QuoteName(driver, tabName), QuoteName(driver, colName))
+	default:
+		if len(def) > 0 {
+			return fmt.Sprintf("alter table %s alter column %s set default %s", QuoteName(driver, tabName), QuoteName(driver, colName), def)
+		}
+		return fmt.Sprintf("alter table %s alter column %s drop default", QuoteName(driver, tabName), QuoteName(driver, colName))
+	}
+}
+
+//去掉字段的自增，oracle如果是序列作为默认值的，则去掉默认值
+func (t *TableSchema) dropColumnIdentity(oldCol, newCol *DBTableColumn) error {
+	tabName := t.NewTable.quotedName()
+	reason := fmt.Sprintf("column %s drop identity", newCol.Name)
+	switch t.NewTable.Db.DriverName() {
+	case "postgres":
+		t.step(fmt.Sprintf("alter table %s alter column %s drop identity if exists", tabName, t.quote(newCol.Name)), reason)
+	case "oci8":
+		if len(oldCol.Default) > 0 {
+			t.step(fmt.Sprintf("alter table %s modify %s default null", tabName, t.quote(newCol.Name)), reason).
+				undo(columnDefaultSql("oci8", tabName, newCol.Name, oldCol.Default))
+		} else {
+			//已有字段不能再加上identity
+			t.step(fmt.Sprintf("alter table %s modify %s drop identity", tabName, t.quote(newCol.Name)), reason).irreversible()
+		}
+	case "mysql":
+		t.step(fmt.Sprintf("alter table %s MODIFY %s", tabName, newCol.DBDefine(t.NewTable.Db.DriverName())), reason).
+			undo(fmt.Sprintf("alter table %s MODIFY %s", tabName, t.renamedColumn(oldCol, newCol).DBDefine("mysql")))
+	default:
+		log.WithFields(log.Fields{
+			"table":  tabName,
+			"column": newCol.Name,
+			"driver": t.NewTable.Db.DriverName(),
+		}).Panic("drop column identity not impl")
+	}
+	return nil
+}
+
+//设置表的说明，sqlite3不支持，记录警告后忽略
+func (t *TableSchema) setTableComment() error {
+	reason := fmt.Sprintf("table comment %q", t.NewTable.Comment)
+	old := ""
+	if t.OldTable != nil {
+		old = t.OldTable.Comment
+	}
+	switch t.NewTable.Db.DriverName() {
+	case "postgres", "oci8":
+		t.step(fmt.Sprintf("comment on table %s is %s", t.NewTable.quotedName(), safe.SignString(t.NewTable.Comment)), reason).
+			undo(fmt.Sprintf("comment on table %s is %s", t.NewTable.quotedName(), safe.SignString(old)))
+	case "mysql":
+		t.step(fmt.Sprintf("alter table %s comment %s", t.NewTable.quotedName(), safe.SignString(t.NewTable.Comment)), reason).
+			undo(fmt.Sprintf("alter table %s comment %s", t.NewTable.quotedName(), safe.SignString(old)))
+	case "sqlite3":
+		log.Warnf("sqlite3 not support comment, table %s comment ignored", t.NewTable.Name())
+	}
+	return nil
+}
+
+//设置字段的说明，mysql的说明是字段定义的一部分，需要重新定义字段，sqlite3不支持，记录警告后忽略
+//oldComment是撤销时恢复的说明
+func (t *TableSchema) setColumnComment(col *DBTableColumn, oldComment string) error {
+	reason := fmt.Sprintf("column %s comment %q", col.Name, col.Comment)
+	switch t.NewTable.Db.DriverName() {
+	case "postgres", "oci8":
+		t.step(fmt.Sprintf("comment on column %s.%s is %s", t.NewTable.quotedName(), t.quote(col.Name), safe.SignString(col.Comment)), reason).
+			undo(fmt.Sprintf("comment on column %s.%s is %s", t.NewTable.quotedName(), t.quote(col.Name), safe.SignString(oldComment)))
+	case "mysql":
+		old := col.Clone()
+		old.Comment = oldComment
+		t.step(fmt.Sprintf("alter table %s MODIFY %s", t.NewTable.quotedName(), col.DBDefine(t.NewTable.Db.DriverName())), reason).
+			undo(fmt.Sprintf("alter table %s MODIFY %s", t.NewTable.quotedName(), old.DBDefine(t.NewTable.Db.DriverName())))
+	case "sqlite3":
+		log.Warnf("sqlite3 not support comment, column %s.%s comment ignored", t.NewTable.Name(), col.Name)
+	}
+	return nil
+}
+
+//字段的单字段普通索引
+func (t *TableSchema) createColumnIndex(col *DBTableColumn) error {
+	strSql, err := createColumnIndexSql(t.NewTable.Db, t.NewTable.Name(), col.Name)
+	if err != nil {
+		return err
+	}
+	step := t.step(strSql, fmt.Sprintf("create column %s index", col.Name))
+	//postgres的索引名称是自动生成的，在事务中执行，不需要撤销
+	if t.NewTable.Db.DriverName() != "postgres" {
+		undo, err := dropColumnIndexSql(t.NewTable.Db, t.NewTable.Name(), columnIndexName(t.NewTable.Name(), col.Name))
+		if err != nil {
 			return err
 		}
-		log.Printf("create table %s column %s index\n", t.NewTable.Name(), newCol.Name)
+		step.undo(undo)
 	}
 	return nil
 }
+
+//旧字段用新名称的定义，用于撤销时恢复字段的定义
+func (t *TableSchema) renamedColumn(oldCol, newCol *DBTableColumn) *DBTableColumn {
+	rev := oldCol.Clone()
+	rev.Name = newCol.Name
+	return rev
+}
+
+//备份表的名称
+func (t *TableSchema) backupName() string {
+	name := objectName("DBX_BAK_%s", t.OldTable.TableName)
+	if len(t.OldTable.Schema) > 0 {
+		name = t.OldTable.Schema + "." + name
+	}
+	return t.quote(name)
+}
+
+//sql语句中引用的名称
+func (t *TableSchema) quote(name string) string {
+	return QuoteName(t.NewTable.Db.DriverName(), name)
+}
+
+//oracle、mysql的DDL不能回滚，把要删除或重建的字段按主键备份到临时表，撤销时恢复数据，全部完成后删除
+//表没有主键，或者主键字段本身要删除或重建的，不能备份，删除字段的语句不能撤销
+func (t *TableSchema) backupColumns(names map[string]bool) {
+	if t.plan.Transaction {
+		return
+	}
+	pks := t.OldTable.PrimaryKeys()
+	if len(pks) == 0 || indexOnColumns(&DBIndex{Columns: pks}, names) {
+		return
+	}
+	cols := []string{}
+	t.backup = map[string]bool{}
+	for _, v := range t.OldTable.AllField() {
+		if names[strings.ToUpper(v.Name)] && len(v.Generated) == 0 {
+			cols = append(cols, v.Name)
+			t.backup[strings.ToUpper(v.Name)] = true
+		}
+	}
+	if len(cols) == 0 {
+		return
+	}
+	t.step(fmt.Sprintf("create table %s as select %s from %s", t.backupName(),
+		strings.Join(quoteNames(t.NewTable.Db.DriverName(), append(append([]string{}, pks...), cols...)), ","), t.OldTable.quotedName()),
+		fmt.Sprintf("backup columns %s", strings.Join(cols, ","))).
+		restore(fmt.Sprintf("drop table %s", t.backupName()))
+}
+
+//删除字段，names是执行时字段的名称，cols是对应的旧字段
+//撤销时重新加上字段，有备份的字段在表恢复成原来的结构后，再从备份中恢复数据
+func (t *TableSchema) dropColumns(cols []*DBTableColumn, names []string, reason string) (*SchemaStep, error) {
+	driver := t.NewTable.Db.DriverName()
+	strSql, err := tableRemoveColumnsSql(t.NewTable.Db, t.NewTable.Name(), names)
+	if err != nil {
+		return nil, err
+	}
+	step := t.step(strSql, reason)
+	if t.plan.Transaction {
+		return step, nil
+	}
+	for i, col := range cols {
+		added := col.Clone()
+		added.Name = names[i]
+		if len(col.Generated) > 0 {
+			step.undo(fmt.Sprintf("alter table %s add %s", t.NewTable.quotedName(), added.DBDefine(driver)))
+			continue
+		}
+		if !t.backup[strings.ToUpper(col.Name)] {
+			step.irreversible()
+			return step, nil
+		}
+		added.Null = true
+		added.Identity = false
+		step.undo(fmt.Sprintf("alter table %s add %s", t.NewTable.quotedName(), added.DBDefine(driver)))
+		//恢复数据时，表和字段都已经恢复成旧名称
+		conds := []string{}
+		for _, k := range t.OldTable.PrimaryKeys() {
+			conds = append(conds, fmt.Sprintf("b.%s=t.%s", t.quote(k), t.quote(k)))
+		}
+		step.restore(fmt.Sprintf("update %s t set %s=(select b.%s from %s b where %s)",
+			t.OldTable.quotedName(), t.quote(col.Name), t.quote(col.Name), t.backupName(), strings.Join(conds, " and ")))
+		if !col.Null || col.Identity {
+			switch driver {
+			case "oci8":
+				step.restore(fmt.Sprintf("alter table %s modify %s not null", t.OldTable.quotedName(), t.quote(col.Name)))
+			case "mysql":
+				step.restore(fmt.Sprintf("alter table %s MODIFY %s", t.OldTable.quotedName(), col.DBDefine(driver)))
+			}
+		}
+	}
+	return step, nil
+}
+
+//删除字段前，Policy要求归档的，把字段的数据按主键保存到归档表中，归档表名带上时间，不会自动删除
+func (t *TableSchema) archiveColumns(cols []*DBTableColumn) error {
+	if t.Policy == nil || !t.Policy.Archive {
+		return nil
+	}
+	names := []string{}
+	for _, v := range cols {
+		if len(v.Generated) == 0 {
+			names = append(names, v.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	pks := t.OldTable.PrimaryKeys()
+	if len(pks) == 0 {
+		return fmt.Errorf("table %s has no primary key, can't archive columns %s", t.OldTable.Name(), strings.Join(names, ","))
+	}
+	name := objectName("DBX_ARC_%s_%s", t.OldTable.TableName, time.Now().Format("20060102150405"))
+	if len(t.OldTable.Schema) > 0 {
+		name = t.OldTable.Schema + "." + name
+	}
+	name = t.quote(name)
+	t.step(fmt.Sprintf("create table %s as select %s from %s", name,
+		strings.Join(quoteNames(t.NewTable.Db.DriverName(), append(append([]string{}, pks...), names...)), ","), t.OldTable.quotedName()),
+		fmt.Sprintf("archive columns %s", strings.Join(names, ","))).
+		undo(fmt.Sprintf("drop table %s", name))
+	return nil
+}
+
+//字段的修改是否可能丢失数据：文本长度变短、数值的整数位或小数位变少、类型转换成不能完整表示原值的类型
+func narrowColumn(oldCol, newCol *DBTableColumn) bool {
+	if !oldCol.typeEque(newCol) {
+		switch newCol.Type {
+		case "STR":
+			return newCol.MaxLength > 0
+		case "DEC", "FLOAT":
+			return oldCol.Type != "INT"
+		case "TIMESTAMP", "TIMESTAMPTZ":
+			return oldCol.GoType() != TypeDatetime || oldCol.Type == "TIMESTAMPTZ"
+		case "DATE":
+			//oracle的DATE没有小数秒
+			return oldCol.Type != "DATEONLY"
+		}
+		//改成DATEONLY会丢失时间
+		return true
+	}
+	switch newCol.Type {
+	case "STR":
+		return newCol.MaxLength > 0 && (oldCol.MaxLength <= 0 || newCol.MaxLength < oldCol.MaxLength)
+	case "DEC":
+		return newCol.Precision > 0 && (oldCol.Precision <= 0 ||
+			newCol.Precision-newCol.Scale < oldCol.Precision-oldCol.Scale || newCol.Scale < oldCol.Scale)
+	}
+	return false
+}
+
+//新增一个索引，没有名称则自动生成
+func (t *TableSchema) createIndex(idx *DBIndex) error {
+	one := *idx
+	one.Name = t.NewTable.indexName(idx)
+	strSql, err := createTableIndexSql(t.NewTable.Db, t.NewTable.Name(), &one)
+	if err != nil {
+		return err
+	}
+	undo, err := dropColumnIndexSql(t.NewTable.Db, t.NewTable.Name(), one.Name)
+	if err != nil {
+		return err
+	}
+	t.step(strSql, fmt.Sprintf("create index %s", one.Name)).undo(undo)
+	return nil
+}
+
+//补全外键的名称以及引用的字段，引用字段为空的，取引用表的主键
+func (t *TableSchema) resolveForeignKey(tab *DBTable, fk *DBForeignKey) (*DBForeignKey, error) {
+	rev := *fk
+	rev.Name = tab.foreignKeyName(fk)
+	if len(rev.RefColumns) > 0 {
+		return &rev, nil
+	}
+	ref := tableOf(tab.Db, fk.RefTable)
+	if ref.Name() == t.NewTable.Name() {
+		//引用自身
+		rev.RefColumns = t.NewTable.PrimaryKeys()
+	} else {
+		if ok, err := TableExists(tab.Db, ref.Name()); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, fmt.Errorf("foreign key %s references table %s not exists", rev.Name, fk.RefTable)
+		}
+		rev.RefColumns = ref.PrimaryKeys()
+	}
+	if len(rev.RefColumns) != len(rev.Columns) {
+		return nil, fmt.Errorf("foreign key %s columns %v not match %s primary key %v", rev.Name, rev.Columns, fk.RefTable, rev.RefColumns)
+	}
+	return &rev, nil
+}
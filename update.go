@@ -48,14 +48,14 @@ func (u *Update) Exec() (icount int64, err error) {
 			if val == "" {
 				val = "NULL"
 			}
-			sets = append(sets, fmt.Sprintf("%s=%s", k, val))
+			sets = append(sets, fmt.Sprintf("%s=%s", u.Table.quote(k), val))
 		} else {
 			idx++
 			//各个数据库均有自动类型转换机制，字符串值会自动转换
 			//如果有出错，则在这里增加转换
 			pname := fmt.Sprintf("up_%d", idx)
 			params[pname] = v
-			sets = append(sets, fmt.Sprintf("%s=:%s", k, pname))
+			sets = append(sets, fmt.Sprintf("%s=:%s", u.Table.quote(k), pname))
 		}
 	}
 	if len(u.AdditionSet) > 0 {
@@ -67,10 +67,10 @@ func (u *Update) Exec() (icount int64, err error) {
 	}
 	dataAlias := "datasql_"
 	for i, field := range u.Table.PrimaryKeys() {
-		where = append(where, fmt.Sprintf("%s.%s = %s.%s", u.Table.Name(), field, dataAlias, u.DataUniqueFields[i]))
+		where = append(where, fmt.Sprintf("%s.%s = %s.%s", u.Table.quotedName(), u.Table.quote(field), dataAlias, u.Table.quote(u.DataUniqueFields[i])))
 	}
 	strSql := fmt.Sprintf("update %s set %s where exists(select * from (%s) %s where %s)",
-		u.Table.Name(),
+		u.Table.quotedName(),
 		strings.Join(sets, ","),
 		u.DataSql,
 		dataAlias,
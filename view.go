@@ -0,0 +1,133 @@
+package dbx
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//DBView 视图的定义，Body是视图的select语句，创建前用RenderSql渲染，
+//渲染参数为空时，传入{"Driver":数据库类型}，可以在模板中区分不同的数据库
+//视图没有数据，改名时删除旧名称的视图，再建立新视图
+type DBView struct {
+	Db         DB
+	ViewName   string
+	Schema     string
+	FormerName []string
+	Body       string
+	RenderArgs interface{}
+}
+
+func NewView(db DB, viewName string, body string) *DBView {
+	if len(viewName) == 0 {
+		log.Panic("view name is empty")
+	}
+	rev := &DBView{
+		Db:   db,
+		Body: body,
+	}
+	if ns := strings.Split(viewName, "."); len(ns) > 1 {
+		rev.Schema = defineName(ns[0])
+		rev.ViewName = defineName(ns[1])
+	} else {
+		rev.ViewName = defineName(viewName)
+	}
+	return rev
+}
+
+func (v *DBView) Name() string {
+	if len(v.Schema) > 0 {
+		return v.Schema + "." + v.ViewName
+	}
+	return v.ViewName
+}
+
+//渲染后的select语句
+func (v *DBView) sql() (string, error) {
+	args := v.RenderArgs
+	if args == nil {
+		args = map[string]interface{}{"Driver": v.Db.DriverName()}
+	}
+	strSql, err := RenderSql(v.Body, args)
+	if err != nil {
+		return "", err
+	}
+	strSql = strings.TrimSpace(strSql)
+	if len(strSql) == 0 {
+		return "", fmt.Errorf("view %s body is empty", v.Name())
+	}
+	return strSql, nil
+}
+
+//Create 建立视图，视图已存在则出错
+func (v *DBView) Create() error {
+	strSql, err := v.sql()
+	if err != nil {
+		return err
+	}
+	return execDDL(v.Db, fmt.Sprintf("CREATE VIEW %s AS\n%s", QuoteName(v.Db.DriverName(), v.Name()), strSql))
+}
+
+//Replace 建立或替换视图，sqlite3不支持replace，先删除再建立
+//postgres的create or replace不能删除或修改已有的字段，出错时需要先Drop
+func (v *DBView) Replace() error {
+	strSql, err := v.sql()
+	if err != nil {
+		return err
+	}
+	switch v.Db.DriverName() {
+	case "postgres", "oci8", "mysql":
+		return execDDL(v.Db, fmt.Sprintf("CREATE OR REPLACE VIEW %s AS\n%s", QuoteName(v.Db.DriverName(), v.Name()), strSql))
+	case "sqlite3":
+		if err := execDDL(v.Db, fmt.Sprintf("DROP VIEW IF EXISTS %s", QuoteName(v.Db.DriverName(), v.Name()))); err != nil {
+			return err
+		}
+		return execDDL(v.Db, fmt.Sprintf("CREATE VIEW %s AS\n%s", QuoteName(v.Db.DriverName(), v.Name()), strSql))
+	default:
+		return fmt.Errorf("not impl," + v.Db.DriverName())
+	}
+}
+
+//Drop 删除视图
+func (v *DBView) Drop() error {
+	return DropView(v.Db, v.Name())
+}
+
+//Update 更新视图到数据库，存在旧名称的视图则先删除，再建立或替换
+func (v *DBView) Update() error {
+	for _, name := range v.FormerName {
+		ok, err := ViewExists(v.Db, name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err := DropView(v.Db, name); err != nil {
+				return err
+			}
+		}
+	}
+	return v.Replace()
+}
+
+//Table 获取视图的字段，返回的表可以用于SqlSelect.Table，为条件提供数据类型
+func (v *DBView) Table() *DBTable {
+	rev := tableOf(v.Db, v.Name())
+	rev.FetchColumns()
+	return rev
+}
+
+//DropView 删除视图
+func DropView(db DB, viewName string) error {
+	return execDDL(db, fmt.Sprintf("DROP VIEW %s", QuoteName(db.DriverName(), viewName)))
+}
+
+//UpdateViews 按顺序更新多个视图，被引用的视图要放在前面
+func UpdateViews(views ...*DBView) error {
+	for _, v := range views {
+		if err := v.Update(); err != nil {
+			return err
+		}
+	}
+	return nil
+}